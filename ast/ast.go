@@ -10,6 +10,8 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position //节点第一个字符的位置
+	End() token.Position //节点最后一个字符之后的位置
 }
 
 type Statement interface {
@@ -54,9 +56,22 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+func (ie *IfExpression) Pos() token.Position { return ie.Token.Pos }
+
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	return ie.Token.End
+}
+
 type BlockStatement struct {
 	Token      token.Token // '{'token
 	Statements []Statement
+	Rbrace     token.Token // '}'token
 }
 
 func (bs *BlockStatement) statementNode() {
@@ -77,6 +92,18 @@ func (bs *BlockStatement) String() string {
 	return out.String()
 }
 
+func (bs *BlockStatement) Pos() token.Position { return bs.Token.Pos }
+
+func (bs *BlockStatement) End() token.Position {
+	if bs.Rbrace.End.IsValid() {
+		return bs.Rbrace.End
+	}
+	if n := len(bs.Statements); n > 0 {
+		return endOf(bs.Statements[n-1], bs.Token.End)
+	}
+	return bs.Token.End
+}
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -94,6 +121,10 @@ func (b *Boolean) String() string {
 	return b.Token.Literal
 }
 
+func (b *Boolean) Pos() token.Position { return b.Token.Pos }
+
+func (b *Boolean) End() token.Position { return b.Token.End }
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -103,6 +134,20 @@ func (p *Program) String() string {
 	return out.String()
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return posOf(p.Statements[0], token.Position{})
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if n := len(p.Statements); n > 0 {
+		return endOf(p.Statements[n-1], token.Position{})
+	}
+	return token.Position{}
+}
+
 func (p *Program) TokenLiteral() string {
 	if len(p.Statements) > 0 {
 		return p.Statements[0].TokenLiteral()
@@ -137,6 +182,18 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+func (ls *LetStatement) Pos() token.Position { return ls.Token.Pos }
+
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return endOf(ls.Value, ls.Token.End)
+	}
+	if ls.Name != nil {
+		return ls.Name.End()
+	}
+	return ls.Token.End
+}
+
 type Identifier struct {
 	Token token.Token
 	Value string
@@ -154,6 +211,10 @@ func (i *Identifier) String() string {
 	return i.Value
 }
 
+func (i *Identifier) Pos() token.Position { return i.Token.Pos }
+
+func (i *Identifier) End() token.Position { return i.Token.End }
+
 type ReturnStatement struct {
 	Token       token.Token
 	ReturnValue Expression
@@ -177,6 +238,12 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+func (rs *ReturnStatement) Pos() token.Position { return rs.Token.Pos }
+
+func (rs *ReturnStatement) End() token.Position {
+	return endOf(rs.ReturnValue, rs.Token.End)
+}
+
 type ExpressionStatement struct {
 	Token      token.Token
 	Expression Expression
@@ -197,6 +264,14 @@ func (es *ExpressionStatement) String() string {
 	return ""
 }
 
+func (es *ExpressionStatement) Pos() token.Position {
+	return posOf(es.Expression, es.Token.Pos)
+}
+
+func (es *ExpressionStatement) End() token.Position {
+	return endOf(es.Expression, es.Token.End)
+}
+
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
@@ -214,6 +289,31 @@ func (il *IntegerLiteral) String() string {
 	return il.Token.Literal
 }
 
+func (il *IntegerLiteral) Pos() token.Position { return il.Token.Pos }
+
+func (il *IntegerLiteral) End() token.Position { return il.Token.End }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode() {
+
+}
+
+func (fl *FloatLiteral) TokenLiteral() string {
+	return fl.Token.Literal
+}
+
+func (fl *FloatLiteral) String() string {
+	return fl.Token.Literal
+}
+
+func (fl *FloatLiteral) Pos() token.Position { return fl.Token.Pos }
+
+func (fl *FloatLiteral) End() token.Position { return fl.Token.End }
+
 type InfixExpression struct {
 	Token    token.Token
 	Operator string
@@ -239,6 +339,44 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+func (ie *InfixExpression) Pos() token.Position {
+	return posOf(ie.Left, ie.Token.Pos)
+}
+
+func (ie *InfixExpression) End() token.Position {
+	return endOf(ie.Right, ie.Token.End)
+}
+
+// AssignExpression 是 x = v 以及 x += v 等复合赋值，值为赋值后的新值
+type AssignExpression struct {
+	Token    token.Token // 赋值运算符token
+	Operator string
+	Target   Expression
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode() {}
+
+func (ae *AssignExpression) TokenLiteral() string {
+	return ae.Token.Literal
+}
+
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	return out.String()
+}
+
+func (ae *AssignExpression) Pos() token.Position {
+	return posOf(ae.Target, ae.Token.Pos)
+}
+
+func (ae *AssignExpression) End() token.Position {
+	return endOf(ae.Value, ae.Token.End)
+}
+
 type PrefixExpression struct {
 	Token    token.Token
 	Operator string
@@ -262,6 +400,12 @@ func (pe *PrefixExpression) String() string {
 	return out.String()
 }
 
+func (pe *PrefixExpression) Pos() token.Position { return pe.Token.Pos }
+
+func (pe *PrefixExpression) End() token.Position {
+	return endOf(pe.Right, pe.Token.End)
+}
+
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
@@ -292,10 +436,20 @@ func (fl *FunctionLiteral) String() string {
 	return out.String()
 }
 
+func (fl *FunctionLiteral) Pos() token.Position { return fl.Token.Pos }
+
+func (fl *FunctionLiteral) End() token.Position {
+	if fl.Body != nil {
+		return fl.Body.End()
+	}
+	return fl.Token.End
+}
+
 type CallExpression struct {
-	Token     token.Token
+	Token     token.Token // '('token
 	Function  Expression
 	Arguments []Expression
+	Rparen    token.Token // ')'token
 }
 
 func (ce *CallExpression) expressionNode() {}
@@ -318,6 +472,20 @@ func (ce *CallExpression) String() string {
 	return out.String()
 }
 
+func (ce *CallExpression) Pos() token.Position {
+	return posOf(ce.Function, ce.Token.Pos)
+}
+
+func (ce *CallExpression) End() token.Position {
+	if ce.Rparen.End.IsValid() {
+		return ce.Rparen.End
+	}
+	if n := len(ce.Arguments); n > 0 {
+		return endOf(ce.Arguments[n-1], ce.Token.End)
+	}
+	return ce.Token.End
+}
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -335,9 +503,14 @@ func (sl *StringLiteral) String() string {
 	return sl.Token.Literal
 }
 
+func (sl *StringLiteral) Pos() token.Position { return sl.Token.Pos }
+
+func (sl *StringLiteral) End() token.Position { return sl.Token.End }
+
 type ArrayLiteral struct {
-	Token    token.Token
+	Token    token.Token // '['token
 	Elements []Expression
+	Rbracket token.Token // ']'token
 }
 
 func (al *ArrayLiteral) expressionNode() {
@@ -363,10 +536,20 @@ func (al *ArrayLiteral) String() string {
 	return out.String()
 }
 
+func (al *ArrayLiteral) Pos() token.Position { return al.Token.Pos }
+
+func (al *ArrayLiteral) End() token.Position {
+	if al.Rbracket.End.IsValid() {
+		return al.Rbracket.End
+	}
+	return al.Token.End
+}
+
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token // '['token
+	Left     Expression
+	Index    Expression
+	Rbracket token.Token // ']'token
 }
 
 func (ie *IndexExpression) expressionNode() {
@@ -389,9 +572,21 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+func (ie *IndexExpression) Pos() token.Position {
+	return posOf(ie.Left, ie.Token.Pos)
+}
+
+func (ie *IndexExpression) End() token.Position {
+	if ie.Rbracket.End.IsValid() {
+		return ie.Rbracket.End
+	}
+	return endOf(ie.Index, ie.Token.End)
+}
+
 type HashLiteral struct {
-	Token token.Token
-	Pairs map[Expression]Expression
+	Token  token.Token // '{'token
+	Pairs  map[Expression]Expression
+	Rbrace token.Token // '}'token
 }
 
 func (hl *HashLiteral) expressionNode() {
@@ -416,6 +611,15 @@ func (hl *HashLiteral) String() string {
 	return out.String()
 }
 
+func (hl *HashLiteral) Pos() token.Position { return hl.Token.Pos }
+
+func (hl *HashLiteral) End() token.Position {
+	if hl.Rbrace.End.IsValid() {
+		return hl.Rbrace.End
+	}
+	return hl.Token.End
+}
+
 type MacroLiteral struct {
 	Token      token.Token //'macro'
 	Parameters []*Identifier
@@ -444,3 +648,135 @@ func (ml *MacroLiteral) String() string {
 	out.WriteString(ml.Body.String())
 	return out.String()
 }
+
+func (ml *MacroLiteral) Pos() token.Position { return ml.Token.Pos }
+
+func (ml *MacroLiteral) End() token.Position {
+	if ml.Body != nil {
+		return ml.Body.End()
+	}
+	return ml.Token.End
+}
+
+type WhileStatement struct {
+	Token     token.Token // 'while'token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode() {}
+
+func (ws *WhileStatement) TokenLiteral() string {
+	return ws.Token.Literal
+}
+
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+	return out.String()
+}
+
+func (ws *WhileStatement) Pos() token.Position { return ws.Token.Pos }
+
+func (ws *WhileStatement) End() token.Position {
+	if ws.Body != nil {
+		return ws.Body.End()
+	}
+	return ws.Token.End
+}
+
+// ForStatement 是 for (x in iterable) { ... }，可以遍历数组、hash的key和字符串中的字符
+type ForStatement struct {
+	Token    token.Token // 'for'token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode() {}
+
+func (fs *ForStatement) TokenLiteral() string {
+	return fs.Token.Literal
+}
+
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for(")
+	out.WriteString(fs.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+	return out.String()
+}
+
+func (fs *ForStatement) Pos() token.Position { return fs.Token.Pos }
+
+func (fs *ForStatement) End() token.Position {
+	if fs.Body != nil {
+		return fs.Body.End()
+	}
+	return fs.Token.End
+}
+
+type BreakStatement struct {
+	Token token.Token
+}
+
+func (bs *BreakStatement) statementNode() {}
+
+func (bs *BreakStatement) TokenLiteral() string {
+	return bs.Token.Literal
+}
+
+func (bs *BreakStatement) String() string {
+	return bs.Token.Literal + ";"
+}
+
+func (bs *BreakStatement) Pos() token.Position { return bs.Token.Pos }
+
+func (bs *BreakStatement) End() token.Position { return bs.Token.End }
+
+type ContinueStatement struct {
+	Token token.Token
+}
+
+func (cs *ContinueStatement) statementNode() {}
+
+func (cs *ContinueStatement) TokenLiteral() string {
+	return cs.Token.Literal
+}
+
+func (cs *ContinueStatement) String() string {
+	return cs.Token.Literal + ";"
+}
+
+func (cs *ContinueStatement) Pos() token.Position { return cs.Token.Pos }
+
+func (cs *ContinueStatement) End() token.Position { return cs.Token.End }
+
+// posOf和endOf在子节点缺失(解析出错或宏展开生成的节点)时退回到fallback
+func posOf(n Node, fallback token.Position) token.Position {
+	if n == nil {
+		return fallback
+	}
+	if pos := n.Pos(); pos.IsValid() {
+		return pos
+	}
+	return fallback
+}
+
+func endOf(n Node, fallback token.Position) token.Position {
+	if n == nil {
+		return fallback
+	}
+	if end := n.End(); end.IsValid() {
+		return end
+	}
+	return fallback
+}
@@ -24,3 +24,29 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestClone(t *testing.T) {
+	ident := &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"}
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Expression: &CallExpression{
+					Function:  ident,
+					Arguments: []Expression{&Identifier{Value: "y"}},
+				},
+			},
+		},
+	}
+
+	clone := Clone(program).(*Program)
+	if clone.String() != program.String() {
+		t.Fatalf("clone differs. want=%q, got=%q", program.String(), clone.String())
+	}
+
+	call := clone.Statements[0].(*ExpressionStatement).Expression.(*CallExpression)
+	call.Arguments[0] = &Identifier{Value: "z"}
+	call.Function.(*Identifier).Value = "w"
+	if program.String() != "x(y)" {
+		t.Errorf("modifying the clone changed the original. got=%q", program.String())
+	}
+}
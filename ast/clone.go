@@ -0,0 +1,171 @@
+package ast
+
+import "reflect"
+
+// Clone 深拷贝一棵语法树。Modify会原地修改节点，
+// 需要保留原树时(例如宏体里的quote每次展开都要从原样开始)先拷贝一份
+func Clone(node Node) Node {
+	if node == nil || reflect.ValueOf(node).IsNil() {
+		return node
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		c := *node
+		c.Statements = cloneStatements(node.Statements)
+		return &c
+	case *ExpressionStatement:
+		c := *node
+		c.Expression = cloneExpression(node.Expression)
+		return &c
+	case *LetStatement:
+		c := *node
+		c.Name = cloneIdentifier(node.Name)
+		c.Value = cloneExpression(node.Value)
+		return &c
+	case *ReturnStatement:
+		c := *node
+		c.ReturnValue = cloneExpression(node.ReturnValue)
+		return &c
+	case *BlockStatement:
+		c := *node
+		c.Statements = cloneStatements(node.Statements)
+		return &c
+	case *WhileStatement:
+		c := *node
+		c.Condition = cloneExpression(node.Condition)
+		c.Body = cloneBlock(node.Body)
+		return &c
+	case *ForStatement:
+		c := *node
+		c.Variable = cloneIdentifier(node.Variable)
+		c.Iterable = cloneExpression(node.Iterable)
+		c.Body = cloneBlock(node.Body)
+		return &c
+	case *BreakStatement:
+		c := *node
+		return &c
+	case *ContinueStatement:
+		c := *node
+		return &c
+	case *Identifier:
+		c := *node
+		return &c
+	case *IntegerLiteral:
+		c := *node
+		return &c
+	case *FloatLiteral:
+		c := *node
+		return &c
+	case *StringLiteral:
+		c := *node
+		return &c
+	case *Boolean:
+		c := *node
+		return &c
+	case *PrefixExpression:
+		c := *node
+		c.Right = cloneExpression(node.Right)
+		return &c
+	case *InfixExpression:
+		c := *node
+		c.Left = cloneExpression(node.Left)
+		c.Right = cloneExpression(node.Right)
+		return &c
+	case *AssignExpression:
+		c := *node
+		c.Target = cloneExpression(node.Target)
+		c.Value = cloneExpression(node.Value)
+		return &c
+	case *IfExpression:
+		c := *node
+		c.Condition = cloneExpression(node.Condition)
+		c.Consequence = cloneBlock(node.Consequence)
+		c.Alternative = cloneBlock(node.Alternative)
+		return &c
+	case *FunctionLiteral:
+		c := *node
+		c.Parameters = cloneIdentifiers(node.Parameters)
+		c.Body = cloneBlock(node.Body)
+		return &c
+	case *MacroLiteral:
+		c := *node
+		c.Parameters = cloneIdentifiers(node.Parameters)
+		c.Body = cloneBlock(node.Body)
+		return &c
+	case *CallExpression:
+		c := *node
+		c.Function = cloneExpression(node.Function)
+		c.Arguments = cloneExpressions(node.Arguments)
+		return &c
+	case *ArrayLiteral:
+		c := *node
+		c.Elements = cloneExpressions(node.Elements)
+		return &c
+	case *IndexExpression:
+		c := *node
+		c.Left = cloneExpression(node.Left)
+		c.Index = cloneExpression(node.Index)
+		return &c
+	case *HashLiteral:
+		c := *node
+		c.Pairs = make(map[Expression]Expression, len(node.Pairs))
+		for k, v := range node.Pairs {
+			c.Pairs[cloneExpression(k)] = cloneExpression(v)
+		}
+		return &c
+	}
+	return node
+}
+
+func cloneExpression(e Expression) Expression {
+	c, _ := Clone(e).(Expression)
+	return c
+}
+
+func cloneBlock(b *BlockStatement) *BlockStatement {
+	if b == nil {
+		return nil
+	}
+	return Clone(b).(*BlockStatement)
+}
+
+func cloneIdentifier(i *Identifier) *Identifier {
+	if i == nil {
+		return nil
+	}
+	return Clone(i).(*Identifier)
+}
+
+func cloneStatements(stmts []Statement) []Statement {
+	if stmts == nil {
+		return nil
+	}
+	c := make([]Statement, len(stmts))
+	for i, s := range stmts {
+		c[i], _ = Clone(s).(Statement)
+	}
+	return c
+}
+
+func cloneExpressions(exps []Expression) []Expression {
+	if exps == nil {
+		return nil
+	}
+	c := make([]Expression, len(exps))
+	for i, e := range exps {
+		c[i] = cloneExpression(e)
+	}
+	return c
+}
+
+func cloneIdentifiers(idents []*Identifier) []*Identifier {
+	if idents == nil {
+		return nil
+	}
+	c := make([]*Identifier, len(idents))
+	for i, ident := range idents {
+		c[i] = cloneIdentifier(ident)
+	}
+	return c
+}
@@ -13,6 +13,9 @@ func Modify(node Node, modifier ModifierFunc) Node {
 	case *InfixExpression:
 		node.Left, _ = Modify(node.Left, modifier).(Expression)
 		node.Right, _ = Modify(node.Right, modifier).(Expression)
+	case *AssignExpression:
+		node.Target, _ = Modify(node.Target, modifier).(Expression)
+		node.Value, _ = Modify(node.Value, modifier).(Expression)
 	case *PrefixExpression:
 		node.Right, _ = Modify(node.Right, modifier).(Expression)
 	case *IndexExpression:
@@ -32,6 +35,12 @@ func Modify(node Node, modifier ModifierFunc) Node {
 		node.ReturnValue, _ = Modify(node.ReturnValue, modifier).(Expression)
 	case *LetStatement:
 		node.Value, _ = Modify(node.Value, modifier).(Expression)
+	case *WhileStatement:
+		node.Condition, _ = Modify(node.Condition, modifier).(Expression)
+		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+	case *ForStatement:
+		node.Iterable, _ = Modify(node.Iterable, modifier).(Expression)
+		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
 	case *FunctionLiteral:
 		for i, ide := range node.Parameters {
 			node.Parameters[i], _ = Modify(ide, modifier).(*Identifier)
@@ -58,6 +58,18 @@ func TestModify(t *testing.T) {
 			&IndexExpression{Left: one(), Index: one()},
 			&IndexExpression{Left: two(), Index: two()},
 		},
+		{
+			&AssignExpression{
+				Target:   &IndexExpression{Left: &Identifier{Value: "a"}, Index: one()},
+				Operator: "=",
+				Value:    one(),
+			},
+			&AssignExpression{
+				Target:   &IndexExpression{Left: &Identifier{Value: "a"}, Index: two()},
+				Operator: "=",
+				Value:    two(),
+			},
+		},
 		{
 			&IfExpression{
 				Condition: one(),
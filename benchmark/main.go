@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"myinterpreter/compiler"
@@ -47,6 +48,11 @@ func main() {
 		start := time.Now()
 		err = machine.Run()
 		if err != nil {
+			var rtErr *vm.RuntimeError
+			if errors.As(err, &rtErr) {
+				fmt.Print(rtErr.Traceback())
+				return
+			}
 			fmt.Printf("vm error: %s", err)
 			return
 		}
@@ -41,6 +41,21 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpMod
+	OpGreaterThanEqual
+	OpJumpTruthy
+	OpIter
+	OpIterNext
+	OpAssignLocal
+	OpSetFree
+	OpGetLocalCell
+	OpGetFreeCell
+	OpSetIndex
+	OpDupTwo
+	OpCompareJump
+	OpWide
+	OpLessThan
+	OpLessThanEqual
 )
 
 type Definition struct {
@@ -49,36 +64,51 @@ type Definition struct {
 }
 
 var definitions = map[Opcode]*Definition{
-	OpConstant:       &Definition{Name: "OpConstant", OperandWidths: []int{2}},
-	OpAdd:            {"OpAdd", []int{}},
-	OpPop:            {"OpPop", []int{}},
-	OpSub:            {"OpSub", []int{}},
-	OpMul:            {"OpMul", []int{}},
-	OpDiv:            {"OpDiv", []int{}},
-	OpTrue:           {"OpTrue", []int{}},
-	OpFalse:          {"OpFalse", []int{}},
-	OpEqual:          {"OpEqual", []int{}},
-	OpNotEqual:       {"OpNotEqual", []int{}},
-	OpGreaterThan:    {"OpGreaterThan", []int{}},
-	OpMinus:          {"OpMinus", []int{}},
-	OpBang:           {"OpBang", []int{}},
-	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
-	OpJump:           {"OpJump", []int{2}},
-	OpNull:           {"OpNull", []int{}},
-	OpGetGlobal:      {"OpGetGlobal", []int{2}},
-	OpSetGlobal:      {"OpSetGlobal", []int{2}},
-	OpArray:          {"OpArray", []int{2}}, //操作数为数组中元素个数
-	OpHash:           {"OpHash", []int{2}},  //操作数为hash中k个数和v个数之和
-	OpIndex:          {"OpIndex", []int{}},
-	OpReturnValue:    {"OpReturnValue", []int{}},
-	OpReturn:         {"OpReturn", []int{}},
-	OpGetLocal:       {"OpGetLocal", []int{1}},
-	OpSetLocal:       {"OpSetLocal", []int{1}},
-	OpCall:           {"OpCall", []int{1}}, //操作数为参数个数
-	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
-	OpClosure:        {"OpClosure", []int{2, 1}}, //第一个操作数为常量索引用于找object.CompiledFunction,第二个操作数表示自由变量的个数
-	OpGetFree:        {"OpGetFree", []int{1}},
-	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpConstant:         &Definition{Name: "OpConstant", OperandWidths: []int{2}},
+	OpAdd:              {"OpAdd", []int{}},
+	OpPop:              {"OpPop", []int{}},
+	OpSub:              {"OpSub", []int{}},
+	OpMul:              {"OpMul", []int{}},
+	OpDiv:              {"OpDiv", []int{}},
+	OpTrue:             {"OpTrue", []int{}},
+	OpFalse:            {"OpFalse", []int{}},
+	OpEqual:            {"OpEqual", []int{}},
+	OpNotEqual:         {"OpNotEqual", []int{}},
+	OpGreaterThan:      {"OpGreaterThan", []int{}},
+	OpMinus:            {"OpMinus", []int{}},
+	OpBang:             {"OpBang", []int{}},
+	OpJumpNotTruthy:    {"OpJumpNotTruthy", []int{2}},
+	OpJump:             {"OpJump", []int{2}},
+	OpNull:             {"OpNull", []int{}},
+	OpGetGlobal:        {"OpGetGlobal", []int{2}},
+	OpSetGlobal:        {"OpSetGlobal", []int{2}},
+	OpArray:            {"OpArray", []int{2}}, //操作数为数组中元素个数
+	OpHash:             {"OpHash", []int{2}},  //操作数为hash中k个数和v个数之和
+	OpIndex:            {"OpIndex", []int{}},
+	OpReturnValue:      {"OpReturnValue", []int{}},
+	OpReturn:           {"OpReturn", []int{}},
+	OpGetLocal:         {"OpGetLocal", []int{1}},
+	OpSetLocal:         {"OpSetLocal", []int{1}},
+	OpCall:             {"OpCall", []int{1}}, //操作数为参数个数
+	OpGetBuiltin:       {"OpGetBuiltin", []int{1}},
+	OpClosure:          {"OpClosure", []int{2, 1}}, //第一个操作数为常量索引用于找object.CompiledFunction,第二个操作数表示自由变量的个数
+	OpGetFree:          {"OpGetFree", []int{1}},
+	OpCurrentClosure:   {"OpCurrentClosure", []int{}},
+	OpMod:              {"OpMod", []int{}},
+	OpGreaterThanEqual: {"OpGreaterThanEqual", []int{}},
+	OpJumpTruthy:       {"OpJumpTruthy", []int{2}},
+	OpIter:             {"OpIter", []int{}},            //把栈顶的数组、hash或字符串换成迭代器
+	OpIterNext:         {"OpIterNext", []int{}},        //弹出迭代器，有下一个元素时压入元素和true，否则只压入false
+	OpAssignLocal:      {"OpAssignLocal", []int{1}},    //给已有的局部变量赋值，变量被捕获时写入它的Cell
+	OpSetFree:          {"OpSetFree", []int{1}},        //给自由变量赋值
+	OpGetLocalCell:     {"OpGetLocalCell", []int{1}},   //压入局部变量的Cell，用于创建闭包
+	OpGetFreeCell:      {"OpGetFreeCell", []int{1}},    //压入自由变量的Cell，用于创建闭包
+	OpSetIndex:         {"OpSetIndex", []int{}},        //弹出集合、索引和值，修改集合后压入值
+	OpDupTwo:           {"OpDupTwo", []int{}},          //复制栈顶的两个元素，用于a[i] += v
+	OpCompareJump:      {"OpCompareJump", []int{1, 2}}, //用第一个操作数表示的比较指令比较栈顶两个值，结果为false时跳转到第二个操作数
+	OpWide:             {"OpWide", []int{}},            //前缀，下一条指令的1字节操作数变成2字节，见Encode
+	OpLessThan:         {"OpLessThan", []int{}},        //<和<=按源码顺序计算两边，不交换成>和>=
+	OpLessThanEqual:    {"OpLessThanEqual", []int{}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -121,15 +151,17 @@ func (ins Instructions) String() string {
 
 	i := 0
 	for i < len(ins) {
-		def, err := Lookup(ins[i])
+		in, err := ReadInstruction(ins, i)
 		if err != nil {
 			fmt.Fprintf(&out, "error: %s\n", err)
-			continue
+			break
 		}
-		operands, read := ReadOperands(def, ins[i+1:])
-
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
-		i += 1 + read
+		text := ins.fmtInstruction(in.Def, in.Operands)
+		if in.Wide {
+			text = "OpWide " + text
+		}
+		fmt.Fprintf(&out, "%04d %s\n", i, text)
+		i += in.Len
 	}
 	return out.String()
 }
@@ -153,19 +185,7 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 }
 
 func ReadOperands(def *Definition, instructions Instructions) ([]int, int) {
-	operands := make([]int, len(def.OperandWidths))
-	offset := 0
-
-	for i, width := range def.OperandWidths {
-		switch width {
-		case 1:
-			operands[i] = int(instructions[offset])
-		case 2:
-			operands[i] = int(ReadUint16(instructions[offset:]))
-		}
-		offset += width
-	}
-	return operands, offset
+	return readOperandWidths(def.OperandWidths, instructions)
 }
 
 func ReadUint16(ins Instructions) uint16 {
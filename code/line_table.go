@@ -0,0 +1,83 @@
+package code
+
+import "encoding/binary"
+
+// LineEntry 表示从Offset开始的指令对应源码中的Line和Column，直到下一个entry为止
+type LineEntry struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// LineTable 是按Offset递增排列的LineEntry的紧凑编码，
+// 每个entry依次写入: offset增量(uvarint)、line增量(varint)、column(uvarint)
+type LineTable []byte
+
+func MakeLineTable(entries []LineEntry) LineTable {
+	table := make([]byte, 0, len(entries)*3)
+	buf := make([]byte, binary.MaxVarintLen64)
+	prevOffset, prevLine := 0, 0
+
+	for _, e := range entries {
+		n := binary.PutUvarint(buf, uint64(e.Offset-prevOffset))
+		table = append(table, buf[:n]...)
+		n = binary.PutVarint(buf, int64(e.Line-prevLine))
+		table = append(table, buf[:n]...)
+		n = binary.PutUvarint(buf, uint64(e.Column))
+		table = append(table, buf[:n]...)
+		prevOffset, prevLine = e.Offset, e.Line
+	}
+	return table
+}
+
+// Entries 解码出全部entry，表损坏时返回已解码的部分
+func (t LineTable) Entries() []LineEntry {
+	entries := []LineEntry{}
+	t.walk(func(e LineEntry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	return entries
+}
+
+// Lookup 返回offset处指令对应的行列，找不到时返回0, 0
+func (t LineTable) Lookup(offset int) (line, column int) {
+	t.walk(func(e LineEntry) bool {
+		if e.Offset > offset {
+			return false
+		}
+		line, column = e.Line, e.Column
+		return true
+	})
+	return line, column
+}
+
+func (t LineTable) walk(fn func(LineEntry) bool) {
+	var e LineEntry
+	rest := []byte(t)
+
+	for len(rest) > 0 {
+		offsetDelta, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return
+		}
+		rest = rest[n:]
+		lineDelta, n := binary.Varint(rest)
+		if n <= 0 {
+			return
+		}
+		rest = rest[n:]
+		column, n := binary.Uvarint(rest)
+		if n <= 0 {
+			return
+		}
+		rest = rest[n:]
+
+		e.Offset += int(offsetDelta)
+		e.Line += int(lineDelta)
+		e.Column = int(column)
+		if !fn(e) {
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package code
+
+import "testing"
+
+func TestLineTable(t *testing.T) {
+	entries := []LineEntry{
+		{Offset: 0, Line: 1, Column: 1},
+		{Offset: 3, Line: 1, Column: 9},
+		{Offset: 7, Line: 0, Column: 0},
+		{Offset: 200, Line: 1000, Column: 300},
+		{Offset: 201, Line: 2, Column: 5},
+	}
+	table := MakeLineTable(entries)
+
+	decoded := table.Entries()
+	if len(decoded) != len(entries) {
+		t.Fatalf("wrong number of entries. want=%d, got=%d", len(entries), len(decoded))
+	}
+	for i, e := range entries {
+		if decoded[i] != e {
+			t.Errorf("entry %d wrong. want=%+v, got=%+v", i, e, decoded[i])
+		}
+	}
+
+	tests := []struct {
+		offset         int
+		expectedLine   int
+		expectedColumn int
+	}{
+		{0, 1, 1},
+		{2, 1, 1},
+		{3, 1, 9},
+		{6, 1, 9},
+		{7, 0, 0},
+		{199, 0, 0},
+		{200, 1000, 300},
+		{201, 2, 5},
+		{5000, 2, 5},
+	}
+	for _, ts := range tests {
+		line, column := table.Lookup(ts.offset)
+		if line != ts.expectedLine || column != ts.expectedColumn {
+			t.Errorf("Lookup(%d) wrong. want=%d:%d, got=%d:%d",
+				ts.offset, ts.expectedLine, ts.expectedColumn, line, column)
+		}
+	}
+
+	line, column := LineTable(nil).Lookup(10)
+	if line != 0 || column != 0 {
+		t.Errorf("empty table Lookup wrong. got=%d:%d", line, column)
+	}
+}
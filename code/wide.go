@@ -0,0 +1,132 @@
+package code
+
+import "fmt"
+
+// wideOpcodes 是可以加OpWide前缀的指令，加前缀后它们的1字节操作数变成2字节。
+// OpCompareJump的1字节操作数是opcode，不需要加宽
+var wideOpcodes = map[Opcode]bool{
+	OpGetLocal:     true,
+	OpSetLocal:     true,
+	OpAssignLocal:  true,
+	OpGetLocalCell: true,
+	OpCall:         true,
+	OpGetBuiltin:   true,
+	OpClosure:      true,
+	OpGetFree:      true,
+	OpSetFree:      true,
+	OpGetFreeCell:  true,
+}
+
+// Instruction 是解码后的一条指令，带OpWide前缀时Len包括前缀的一个字节
+type Instruction struct {
+	Op       Opcode
+	Def      *Definition
+	Operands []int
+	Wide     bool
+	Len      int
+}
+
+// wideWidths 返回加OpWide前缀后各操作数的宽度
+func wideWidths(widths []int) []int {
+	wide := make([]int, len(widths))
+	for i, w := range widths {
+		wide[i] = w
+		if w == 1 {
+			wide[i] = 2
+		}
+	}
+	return wide
+}
+
+func fits(widths []int, operands []int) bool {
+	if len(operands) != len(widths) {
+		return false
+	}
+	for i, o := range operands {
+		if o < 0 || o >= 1<<(8*widths[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode 和Make一样编码一条指令，但不会截断操作数：
+// 1字节的操作数放不下时加OpWide前缀，加宽之后仍然放不下时返回错误
+func Encode(op Opcode, operands ...int) ([]byte, error) {
+	def, ok := definitions[op]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	if fits(def.OperandWidths, operands) {
+		return Make(op, operands...), nil
+	}
+	if !wideOpcodes[op] || !fits(wideWidths(def.OperandWidths), operands) {
+		return nil, fmt.Errorf("operands %v out of range for %s", operands, def.Name)
+	}
+
+	widths := wideWidths(def.OperandWidths)
+	instruction := []byte{byte(OpWide), byte(op)}
+	for i, o := range operands {
+		if widths[i] == 2 {
+			instruction = append(instruction, byte(o>>8), byte(o))
+		} else {
+			instruction = append(instruction, byte(o))
+		}
+	}
+	return instruction, nil
+}
+
+// ReadInstruction 解码offset处的一条指令，OpWide前缀和它修饰的指令作为一条指令返回
+// 越界、未知的opcode或者操作数超出指令末尾时返回错误
+func ReadInstruction(ins Instructions, offset int) (Instruction, error) {
+	in := Instruction{}
+	if offset < 0 || offset >= len(ins) {
+		return in, fmt.Errorf("instruction offset %d out of range", offset)
+	}
+	start := offset
+	if ins[offset] == byte(OpWide) {
+		if offset+1 >= len(ins) || !wideOpcodes[Opcode(ins[offset+1])] {
+			return in, fmt.Errorf("invalid OpWide prefix at %d", offset)
+		}
+		in.Wide = true
+		in.Len = 1
+		offset++
+	}
+	def, err := Lookup(ins[offset])
+	if err != nil {
+		return in, err
+	}
+	in.Op = Opcode(ins[offset])
+	in.Def = def
+
+	widths := def.OperandWidths
+	if in.Wide {
+		widths = wideWidths(widths)
+	}
+	size := 0
+	for _, w := range widths {
+		size += w
+	}
+	if offset+1+size > len(ins) {
+		return in, fmt.Errorf("truncated %s at %d", def.Name, start)
+	}
+	operands, read := readOperandWidths(widths, ins[offset+1:])
+	in.Operands = operands
+	in.Len += 1 + read
+	return in, nil
+}
+
+func readOperandWidths(widths []int, ins Instructions) ([]int, int) {
+	operands := make([]int, len(widths))
+	offset := 0
+	for i, width := range widths {
+		switch width {
+		case 1:
+			operands[i] = int(ins[offset])
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		}
+		offset += width
+	}
+	return operands, offset
+}
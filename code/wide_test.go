@@ -0,0 +1,92 @@
+package code
+
+import "testing"
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpSetLocal, []int{255}, []byte{byte(OpSetLocal), 255}},
+		{OpSetLocal, []int{256}, []byte{byte(OpWide), byte(OpSetLocal), 1, 0}},
+		{OpCall, []int{300}, []byte{byte(OpWide), byte(OpCall), 1, 44}},
+		{OpClosure, []int{3, 256}, []byte{byte(OpWide), byte(OpClosure), 0, 3, 1, 0}},
+		{OpConstant, []int{65535}, []byte{byte(OpConstant), 255, 255}},
+	}
+
+	for _, ts := range tests {
+		instruction, err := Encode(ts.op, ts.operands...)
+		if err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+		if string(instruction) != string(ts.expected) {
+			t.Errorf("wrong encoding of %d %v. want=%v, got=%v", ts.op, ts.operands, ts.expected, instruction)
+		}
+
+		in, err := ReadInstruction(instruction, 0)
+		if err != nil {
+			t.Fatalf("read error: %s", err)
+		}
+		if in.Op != ts.op || in.Len != len(ts.expected) || in.Wide != (len(ts.expected) > len(Make(ts.op, ts.operands...))) {
+			t.Errorf("wrong decoded instruction %+v", in)
+		}
+		for i, o := range ts.operands {
+			if in.Operands[i] != o {
+				t.Errorf("operand %d wrong. want=%d, got=%d", i, o, in.Operands[i])
+			}
+		}
+	}
+}
+
+func TestEncodeOutOfRange(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+	}{
+		{OpConstant, []int{65536}},
+		{OpJump, []int{70000}},
+		{OpGetLocal, []int{65536}},
+		{OpCompareJump, []int{256, 0}},
+	}
+
+	for _, ts := range tests {
+		_, err := Encode(ts.op, ts.operands...)
+		if err == nil {
+			t.Errorf("expected error for %d %v", ts.op, ts.operands)
+		}
+	}
+}
+
+func TestWideInstructionsString(t *testing.T) {
+	ins := Instructions{}
+	for _, in := range [][]int{{int(OpGetLocal), 300}, {int(OpGetLocal), 1}} {
+		b, _ := Encode(Opcode(in[0]), in[1])
+		ins = append(ins, b...)
+	}
+	expected := "0000 OpWide OpGetLocal 300\n0004 OpGetLocal 1\n"
+	if ins.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", expected, ins.String())
+	}
+}
+
+func TestReadInstructionErrors(t *testing.T) {
+	tests := []struct {
+		ins      Instructions
+		offset   int
+		expected string
+	}{
+		{Instructions{byte(OpConstant), 0}, 0, "truncated OpConstant at 0"},
+		{Instructions{byte(OpPop), byte(OpWide), byte(OpGetLocal), 1}, 1, "truncated OpGetLocal at 1"},
+		{Instructions{byte(OpWide), byte(OpAdd)}, 0, "invalid OpWide prefix at 0"},
+		{Instructions{255}, 0, "opcode 255 undefined"},
+		{Instructions{byte(OpPop)}, 1, "instruction offset 1 out of range"},
+	}
+
+	for _, ts := range tests {
+		_, err := ReadInstruction(ts.ins, ts.offset)
+		if err == nil || err.Error() != ts.expected {
+			t.Errorf("wrong error for %v. want=%q, got=%v", ts.ins, ts.expected, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"myinterpreter/ast"
 	"myinterpreter/code"
 	"myinterpreter/object"
+	"myinterpreter/token"
 	"sort"
 )
 
@@ -13,17 +14,51 @@ type Compiler struct {
 	symbolTable *SymbolTable
 	scopes      []CompilationScope
 	scopeIndex  int
+	pos         token.Position //当前正在编译的节点的位置，emit时记录到行号表中
+	filename    string
+
+	optimization  int
+	constantIndex map[constantKey]int //常量池去重用，OptBasic及以上才使用
+
+	err error //emit时发现的超出限制的错误，由Compile返回
 }
 
 type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction //最后一条指令
 	previousInstruction EmittedInstruction //倒数第二条指令
+	lineEntries         []code.LineEntry
+	loops               []*loopContext //当前函数内正在编译的循环，最内层在最后
+}
+
+// loopContext 记录continue跳转的目标和需要回填的break跳转
+type loopContext struct {
+	continuePos int
+	breakJumps  []int
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	LineTable    code.LineTable
+	Filename     string
+}
+
+// Error 是带有源码位置的编译错误
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Message
+	}
+	return e.Message
+}
+
+func newError(pos token.Position, format string, a ...any) *Error {
+	return &Error{Pos: pos, Message: fmt.Sprintf(format, a...)}
 }
 
 type EmittedInstruction struct {
@@ -31,7 +66,7 @@ type EmittedInstruction struct {
 	Position int
 }
 
-func New() *Compiler {
+func New(opts ...Option) *Compiler {
 	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
@@ -41,11 +76,16 @@ func New() *Compiler {
 	for i, v := range object.Builtins {
 		symbolTable.DefineBuiltin(i, v.Name)
 	}
-	return &Compiler{
-		scopes:      []CompilationScope{mainScope},
-		constants:   []object.Object{},
-		symbolTable: symbolTable,
+	c := &Compiler{
+		scopes:        []CompilationScope{mainScope},
+		constants:     []object.Object{},
+		symbolTable:   symbolTable,
+		constantIndex: make(map[constantKey]int),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
 }
 
 func (c *Compiler) currentInstructions() code.Instructions {
@@ -71,9 +111,23 @@ func (c *Compiler) leaveScope() code.Instructions {
 	return instructions
 }
 
-func (c *Compiler) Compile(node ast.Node) error {
+func (c *Compiler) Compile(node ast.Node) (err error) {
+	prevPos := c.pos
+	if pos := nodePosition(node); pos.IsValid() {
+		c.pos = pos
+	}
+	defer func() {
+		c.pos = prevPos
+		if err == nil {
+			err = c.err
+		}
+	}()
+
 	switch node := node.(type) {
 	case *ast.Program:
+		if pos := node.Pos(); pos.Filename != "" {
+			c.filename = pos.Filename
+		}
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
@@ -87,17 +141,14 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			err := c.Compile(node.Right)
-			if err != nil {
-				return err
+		if c.optimization >= OptBasic {
+			if value, ok := constantValue(node); ok {
+				c.emitConstantValue(value)
+				return nil
 			}
-			err = c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
-			c.emit(code.OpGreaterThan)
-			return nil
+		}
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
 		}
 		err := c.Compile(node.Left)
 		if err != nil {
@@ -116,18 +167,29 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanEqual)
+		case "<":
+			c.emit(code.OpLessThan)
+		case "<=":
+			c.emit(code.OpLessThanEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return newError(node.Token.Pos, "unknown operator %s", node.Operator)
 		}
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -135,6 +197,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpFalse)
 		}
 	case *ast.PrefixExpression:
+		if c.optimization >= OptBasic {
+			if value, ok := constantValue(node); ok {
+				c.emitConstantValue(value)
+				return nil
+			}
+		}
 		err := c.Compile(node.Right)
 		if err != nil {
 			return err
@@ -146,22 +214,25 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return newError(node.Token.Pos, "unknown operator %s", node.Operator)
 		}
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
 	case *ast.IfExpression:
+		if c.optimization >= OptBasic {
+			if condition, ok := constantValue(node.Condition); ok {
+				return c.compileConstantIf(node, condition)
+			}
+		}
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
 		}
 		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
-		err = c.Compile(node.Consequence)
+		err = c.compileBlockValue(node.Consequence)
 		if err != nil {
 			return err
 		}
-
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastInstruction()
-		}
 		jumpPos := c.emit(code.OpJump, 9999)
 		afterConsequencePos := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
@@ -169,14 +240,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
 		} else {
-			err := c.Compile(node.Alternative)
+			err := c.compileBlockValue(node.Alternative)
 			if err != nil {
 				return err
 			}
 		}
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastInstruction()
-		}
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 
@@ -193,15 +261,27 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
-		}
+		c.storeSymbol(symbol)
+	case *ast.WhileStatement:
+		return c.compileWhileStatement(node)
+	case *ast.ForStatement:
+		return c.compileForStatement(node)
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return newError(node.Pos(), "break outside loop")
+		}
+		loop.breakJumps = append(loop.breakJumps, c.emit(code.OpJump, 9999))
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return newError(node.Pos(), "continue outside loop")
+		}
+		c.emit(code.OpJump, loop.continuePos)
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return newError(node.Pos(), "undefined variable %s", node.Value)
 		}
 		c.loadSymbol(symbol)
 	case *ast.StringLiteral:
@@ -248,7 +328,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpIndex)
 	case *ast.FunctionLiteral:
 		c.enterScope()
-		if node.Name != "" {
+		//函数体给自己的名字赋值时不能用OpCurrentClosure，名字要解析到外层真正的绑定
+		if node.Name != "" && !assignsTo(node.Body, node.Name) {
 			c.symbolTable.DefineFunctionName(node.Name)
 		}
 		for _, p := range node.Parameters {
@@ -266,11 +347,27 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		localNames := c.symbolTable.DefinedNames()
+		lineEntries := c.scopes[c.scopeIndex].lineEntries
 		instructions := c.leaveScope()
-		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+		if c.optimization >= OptPeephole {
+			instructions, lineEntries = peephole(instructions, lineEntries, false)
+		}
+		freeNames := make([]string, len(freeSymbols))
+		for i, s := range freeSymbols {
+			c.loadCell(s)
+			freeNames[i] = s.Name
+		}
+		compiledFn := &object.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			LineTable:     code.MakeLineTable(lineEntries),
+			Filename:      node.Pos().Filename,
+			Name:          node.Name,
+			LocalNames:    localNames,
+			FreeNames:     freeNames,
 		}
-		compiledFn := &object.CompiledFunction{Instructions: instructions, NumLocals: numLocals, NumParameters: len(node.Parameters)}
 		c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
 	case *ast.CallExpression:
 		err := c.Compile(node.Function)
@@ -295,6 +392,305 @@ func (c *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// compileLogicalExpression 编译短路求值的&&和||，结果总是布尔值:
+//
+//	a && b: a; JumpNotTruthy F; b; JumpNotTruthy F; True; Jump E; F: False; E:
+//	a || b: a; JumpTruthy T; b; JumpTruthy T; False; Jump E; T: True; E:
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	jumpOp, result, shortCircuit := code.OpJumpNotTruthy, code.OpTrue, code.OpFalse
+	if node.Operator == "||" {
+		jumpOp, result, shortCircuit = code.OpJumpTruthy, code.OpFalse, code.OpTrue
+	}
+
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+	leftJumpPos := c.emit(jumpOp, 9999)
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+	rightJumpPos := c.emit(jumpOp, 9999)
+
+	c.emit(result)
+	jumpPos := c.emit(code.OpJump, 9999)
+	shortCircuitPos := len(c.currentInstructions())
+	c.changeOperand(leftJumpPos, shortCircuitPos)
+	c.changeOperand(rightJumpPos, shortCircuitPos)
+	c.emit(shortCircuit)
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+var compoundAssignOps = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+	"%=": code.OpMod,
+}
+
+// assignsTo 报告node中是否有给name赋值的表达式，不考虑同名变量的遮蔽
+func assignsTo(node ast.Node, name string) bool {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		for _, stmt := range node.Statements {
+			if assignsTo(stmt, name) {
+				return true
+			}
+		}
+	case *ast.ExpressionStatement:
+		return assignsTo(node.Expression, name)
+	case *ast.LetStatement:
+		return assignsTo(node.Value, name)
+	case *ast.ReturnStatement:
+		return node.ReturnValue != nil && assignsTo(node.ReturnValue, name)
+	case *ast.WhileStatement:
+		return assignsTo(node.Condition, name) || assignsTo(node.Body, name)
+	case *ast.ForStatement:
+		return assignsTo(node.Iterable, name) || assignsTo(node.Body, name)
+	case *ast.AssignExpression:
+		if ident, ok := node.Target.(*ast.Identifier); ok && ident.Value == name {
+			return true
+		}
+		return assignsTo(node.Target, name) || assignsTo(node.Value, name)
+	case *ast.InfixExpression:
+		return assignsTo(node.Left, name) || assignsTo(node.Right, name)
+	case *ast.PrefixExpression:
+		return assignsTo(node.Right, name)
+	case *ast.IfExpression:
+		return assignsTo(node.Condition, name) || assignsTo(node.Consequence, name) ||
+			(node.Alternative != nil && assignsTo(node.Alternative, name))
+	case *ast.FunctionLiteral:
+		return assignsTo(node.Body, name)
+	case *ast.CallExpression:
+		if assignsTo(node.Function, name) {
+			return true
+		}
+		for _, arg := range node.Arguments {
+			if assignsTo(arg, name) {
+				return true
+			}
+		}
+	case *ast.IndexExpression:
+		return assignsTo(node.Left, name) || assignsTo(node.Index, name)
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if assignsTo(el, name) {
+				return true
+			}
+		}
+	case *ast.HashLiteral:
+		for k, v := range node.Pairs {
+			if assignsTo(k, name) || assignsTo(v, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compileAssignExpression 编译赋值，赋值后把新值重新压栈作为表达式的值
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	var ident *ast.Identifier
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		ident = target
+	case *ast.IndexExpression:
+		return c.compileIndexAssignment(target, node)
+	default:
+		return newError(node.Token.Pos, "invalid assignment target %s", node.Target.String())
+	}
+	symbol, ok := c.symbolTable.Resolve(ident.Value)
+	if !ok {
+		return newError(ident.Pos(), "undefined variable %s", ident.Value)
+	}
+	switch symbol.Scope {
+	case BuiltinScope:
+		return newError(ident.Pos(), "cannot assign to builtin %s", ident.Value)
+	}
+
+	if node.Operator != "=" {
+		op, ok := compoundAssignOps[node.Operator]
+		if !ok {
+			return newError(node.Token.Pos, "unknown operator %s", node.Operator)
+		}
+		c.loadSymbol(symbol)
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(op)
+	} else {
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+	}
+	c.assignSymbol(symbol)
+	c.loadSymbol(symbol)
+	return nil
+}
+
+// compileIndexAssignment 编译a[i] = v，复合赋值时集合和索引只求值一次:
+//
+//	a; i; v; SetIndex
+//	a; i; DupTwo; Index; v; Add; SetIndex
+func (c *Compiler) compileIndexAssignment(target *ast.IndexExpression, node *ast.AssignExpression) error {
+	err := c.Compile(target.Left)
+	if err != nil {
+		return err
+	}
+	err = c.Compile(target.Index)
+	if err != nil {
+		return err
+	}
+	if node.Operator != "=" {
+		op, ok := compoundAssignOps[node.Operator]
+		if !ok {
+			return newError(node.Token.Pos, "unknown operator %s", node.Operator)
+		}
+		c.emit(code.OpDupTwo)
+		c.emit(code.OpIndex)
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(op)
+	} else {
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+	}
+	c.emit(code.OpSetIndex)
+	return nil
+}
+
+// compileBlockValue 编译if的分支，保证分支执行完后栈顶留下一个值
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastInstruction()
+		return nil
+	}
+	if n := len(block.Statements); n > 0 {
+		if _, ok := block.Statements[n-1].(*ast.ReturnStatement); ok {
+			return nil
+		}
+	}
+	//空的分支或以let、循环等语句结尾的分支没有值
+	c.emit(code.OpNull)
+	return nil
+}
+
+// loop: condition; JumpNotTruthy end; body; Jump loop; end:
+func (c *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	loopStart := len(c.currentInstructions())
+	err := c.Compile(node.Condition)
+	if err != nil {
+		return err
+	}
+	exitJumpPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	err = c.compileLoopBody(node.Body, loopStart)
+	if err != nil {
+		return err
+	}
+	c.changeOperand(exitJumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// iterable; Iter; Set $iter
+// loop: Get $iter; IterNext; JumpNotTruthy end; Set x; body; Jump loop; end:
+func (c *Compiler) compileForStatement(node *ast.ForStatement) error {
+	err := c.Compile(node.Iterable)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+	iterSymbol := c.symbolTable.Define("$iter") //$不能出现在标识符中，不会和用户变量冲突
+	c.storeSymbol(iterSymbol)
+
+	loopStart := len(c.currentInstructions())
+	c.loadSymbol(iterSymbol)
+	c.emit(code.OpIterNext)
+	exitJumpPos := c.emit(code.OpJumpNotTruthy, 9999)
+	c.storeSymbol(c.symbolTable.Define(node.Variable.Value))
+
+	err = c.compileLoopBody(node.Body, loopStart)
+	if err != nil {
+		return err
+	}
+	c.changeOperand(exitJumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileLoopBody 编译循环体和跳回loopStart的指令，并回填循环体中的break
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement, loopStart int) error {
+	loop := &loopContext{continuePos: loopStart}
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, loop)
+
+	err := c.Compile(body)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpJump, loopStart)
+
+	scope = &c.scopes[c.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+	afterLoopPos := len(c.currentInstructions())
+	for _, pos := range loop.breakJumps {
+		c.changeOperand(pos, afterLoopPos)
+	}
+	return nil
+}
+
+func (c *Compiler) currentLoop() *loopContext {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// assignSymbol 修改已有的绑定，和storeSymbol不同，被捕获的局部变量会写入共享的Cell
+func (c *Compiler) assignSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpAssignLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// loadCell 为创建闭包压入被捕获变量的Cell
+func (c *Compiler) loadCell(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpGetLocalCell, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFreeCell, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
@@ -316,10 +712,11 @@ func (c *Compiler) replaceLastPopWithReturn() {
 	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
 }
 
-func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
-	compiler := New()
+func NewWithState(s *SymbolTable, constants []object.Object, opts ...Option) *Compiler {
+	compiler := New(opts...)
 	compiler.symbolTable = s
 	compiler.constants = constants
+	compiler.indexConstants()
 	return compiler
 }
 
@@ -332,7 +729,11 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 
 func (c *Compiler) changeOperand(opPos int, operand int) {
 	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+	newInstruction, err := code.Encode(op, operand)
+	if err != nil || len(newInstruction) != len(code.Make(op, operand)) {
+		c.limitExceeded(op)
+		return
+	}
 	c.replaceInstruction(opPos, newInstruction)
 }
 
@@ -344,6 +745,12 @@ func (c *Compiler) removeLastInstruction() {
 	news := old[:last.Position]
 	c.scopes[c.scopeIndex].instructions = news
 	c.scopes[c.scopeIndex].lastInstruction = prev
+
+	entries := c.scopes[c.scopeIndex].lineEntries
+	for len(entries) > 0 && entries[len(entries)-1].Offset >= last.Position {
+		entries = entries[:len(entries)-1]
+	}
+	c.scopes[c.scopeIndex].lineEntries = entries
 }
 
 func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
@@ -354,19 +761,62 @@ func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
 }
 
+// addConstant 把常量加入常量池，开启优化时相同的整数、浮点数和字符串只保存一份
 func (c *Compiler) addConstant(obj object.Object) int {
+	key, ok := keyOf(obj)
+	if ok && c.optimization >= OptBasic {
+		if idx, exists := c.constantIndex[key]; exists {
+			return idx
+		}
+	}
 	c.constants = append(c.constants, obj)
-	return len(c.constants) - 1
+	idx := len(c.constants) - 1
+	if ok {
+		c.constantIndex[key] = idx
+	}
+	return idx
 }
 
+// emit 生成一条指令，1字节的操作数放不下时加OpWide前缀，
+// 加宽之后仍然放不下时记录错误，由Compile返回
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	ins := code.Make(op, operands...)
+	ins, err := code.Encode(op, operands...)
+	if err != nil {
+		c.limitExceeded(op)
+		ins = code.Make(op, operands...)
+	}
 	pos := c.addInstruction(ins)
 
 	c.setLastInstruction(op, pos)
+	c.addLineEntry(pos)
 	return pos
 }
 
+// addLineEntry 记录从offset开始的指令对应的源码行列，行列不变时不重复记录
+func (c *Compiler) addLineEntry(offset int) {
+	entries := c.scopes[c.scopeIndex].lineEntries
+	if n := len(entries); n > 0 && entries[n-1].Line == c.pos.Line && entries[n-1].Column == c.pos.Column {
+		return
+	}
+	entry := code.LineEntry{Offset: offset, Line: c.pos.Line, Column: c.pos.Column}
+	c.scopes[c.scopeIndex].lineEntries = append(entries, entry)
+}
+
+// nodePosition 返回报错时应当指向的位置，运算符表达式指向运算符本身
+func nodePosition(node ast.Node) token.Position {
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		return node.Token.Pos
+	case *ast.IndexExpression:
+		return node.Token.Pos
+	case *ast.AssignExpression:
+		return node.Token.Pos
+	case nil, *ast.Program, *ast.BlockStatement:
+		return token.Position{}
+	}
+	return node.Pos()
+}
+
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 	prev := c.scopes[c.scopeIndex].lastInstruction
 	last := EmittedInstruction{Opcode: op, Position: pos}
@@ -381,9 +831,55 @@ func (c *Compiler) addInstruction(ins []byte) int {
 	return posNewInstruction
 }
 
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions := c.currentInstructions()
+	lineEntries := c.scopes[c.scopeIndex].lineEntries
+	if c.optimization >= OptPeephole {
+		instructions, lineEntries = peephole(instructions, lineEntries, true)
+	}
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: instructions,
 		Constants:    c.constants,
+		LineTable:    code.MakeLineTable(lineEntries),
+		Filename:     c.filename,
+	}
+}
+
+// limitMessages 描述每种指令的操作数超出范围时是超出了哪个限制
+var limitMessages = map[code.Opcode]string{
+	code.OpConstant:      "too many constants",
+	code.OpGetGlobal:     "too many global variables",
+	code.OpSetGlobal:     "too many global variables",
+	code.OpGetLocal:      "too many local variables",
+	code.OpSetLocal:      "too many local variables",
+	code.OpAssignLocal:   "too many local variables",
+	code.OpGetLocalCell:  "too many local variables",
+	code.OpCall:          "too many arguments",
+	code.OpGetBuiltin:    "too many builtins",
+	code.OpClosure:       "too many free variables",
+	code.OpGetFree:       "too many free variables",
+	code.OpSetFree:       "too many free variables",
+	code.OpGetFreeCell:   "too many free variables",
+	code.OpArray:         "too many array elements",
+	code.OpHash:          "too many hash literal entries",
+	code.OpJump:          "function too large, jump out of range",
+	code.OpJumpNotTruthy: "function too large, jump out of range",
+	code.OpJumpTruthy:    "function too large, jump out of range",
+}
+
+// limitExceeded 记录第一个超出限制的错误，操作数最大为65535
+func (c *Compiler) limitExceeded(op code.Opcode) {
+	if c.err != nil {
+		return
+	}
+	msg, ok := limitMessages[op]
+	if !ok {
+		def, _ := code.Lookup(byte(op))
+		msg = "operand out of range for " + def.Name
 	}
+	c.err = newError(c.pos, "%s (limit %d)", msg, 1<<16-1)
 }
@@ -7,6 +7,7 @@ import (
 	"myinterpreter/lexer"
 	"myinterpreter/object"
 	"myinterpreter/parser"
+	"strings"
 	"testing"
 )
 
@@ -131,12 +132,34 @@ func TestIntegerArithmetic(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestFloatArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1.5 + 2",
+			expectedConstants: []any{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	t.Helper()
+	runCompilerTestsWithOptions(t, tests)
+}
+
+func runCompilerTestsWithOptions(t *testing.T, tests []compilerTestCase, opts ...Option) {
+	t.Helper()
 
 	for _, ts := range tests {
 		program := parse(ts.input)
-		compiler := New()
+		compiler := New(opts...)
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -193,6 +216,11 @@ func testConstants(t *testing.T, expected []any, actual []object.Object) error {
 			if err != nil {
 				return fmt.Errorf("constant %d -- testIntegerObject failed:%s", i, err.Error())
 			}
+		case float64:
+			f, ok := actual[i].(*object.Float)
+			if !ok || f.Value != constType {
+				return fmt.Errorf("constant %d - not Float %g. got=%T(%+v)", i, constType, actual[i], actual[i])
+			}
 		case string:
 			err := testStringObject(actual[i], constType)
 			if err != nil {
@@ -265,11 +293,11 @@ func TestBooleanExpression(t *testing.T) {
 		},
 		{
 			input:             "1<2",
-			expectedConstants: []any{2, 1},
+			expectedConstants: []any{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
 				code.Make(code.OpPop),
 			},
 		},
@@ -906,7 +934,7 @@ fn(a) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 0, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -936,13 +964,13 @@ fn(a) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 0, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 1, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -986,15 +1014,15 @@ fn() {
 				[]code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetFreeCell, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocalCell, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -1077,3 +1105,364 @@ countDown(1);
 	}
 	runCompilerTests(t, tests)
 }
+
+func TestCompilerErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1;\na + b;", "2:5: undefined variable b"},
+		{"fn() {\n  let x = y;\n}", "2:11: undefined variable y"},
+	}
+
+	for _, ts := range tests {
+		program := parse(ts.input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error for %q", ts.input)
+		}
+		if err.Error() != ts.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", ts.expected, err)
+		}
+	}
+}
+
+func TestComparisonAndModuloOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "5 % 2",
+			expectedConstants: []any{5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThanEqual),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true && false",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0008
+				code.Make(code.OpTrue),
+				// 0009
+				code.Make(code.OpJump, 13),
+				// 0012
+				code.Make(code.OpFalse),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true || false",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpTruthy, 12),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpTruthy, 12),
+				// 0008
+				code.Make(code.OpFalse),
+				// 0009
+				code.Make(code.OpJump, 13),
+				// 0012
+				code.Make(code.OpTrue),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `while (true) { 1; break; continue; }`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 17),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 17),
+				// 0011
+				code.Make(code.OpJump, 0),
+				// 0014
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			input:             `for (x in [1]) { x }`,
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpSetGlobal, 0),
+				// 0010
+				code.Make(code.OpGetGlobal, 0),
+				// 0013
+				code.Make(code.OpIterNext),
+				// 0014
+				code.Make(code.OpJumpNotTruthy, 27),
+				// 0017
+				code.Make(code.OpSetGlobal, 1),
+				// 0020
+				code.Make(code.OpGetGlobal, 1),
+				// 0023
+				code.Make(code.OpPop),
+				// 0024
+				code.Make(code.OpJump, 10),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: break outside loop"},
+		{"while (true) { fn() { continue; } }", "1:23: continue outside loop"},
+	}
+
+	for _, ts := range tests {
+		program := parse(ts.input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error for %q", ts.input)
+		}
+		if err.Error() != ts.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", ts.expected, err)
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let x = 1; x = 2;`,
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { let x = 1; x += 2; }`,
+			expectedConstants: []any{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpAssignLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn(a) { fn() { a = 1; } }`,
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocalCell, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 1;", "1:1: undefined variable x"},
+		{"len = 1;", "1:1: cannot assign to builtin len"},
+	}
+
+	for _, ts := range tests {
+		program := parse(ts.input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error for %q", ts.input)
+		}
+		if err.Error() != ts.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", ts.expected, err)
+		}
+	}
+}
+
+func TestIndexAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let a = [1]; a[0] = 2;`,
+			expectedConstants: []any{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let h = {}; h["k"] += 1;`,
+			expectedConstants: []any{"k", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDupTwo),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestWideOperands(t *testing.T) {
+	//300个局部变量，第256个之后的局部变量需要OpWide前缀
+	var body strings.Builder
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&body, "let %s = %d; ", localName(i), i)
+	}
+	body.WriteString(localName(299))
+
+	program := parse("fn() { " + body.String() + " }")
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	fn, ok := compiler.Bytecode().Constants[len(compiler.Bytecode().Constants)-1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("last constant is not a function")
+	}
+	if fn.NumLocals != 300 {
+		t.Errorf("wrong NumLocals. want=300, got=%d", fn.NumLocals)
+	}
+	listing := fn.Instructions.String()
+	for _, want := range []string{"OpSetLocal 255\n", "OpWide OpSetLocal 256\n", "OpWide OpGetLocal 299\n"} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("instructions do not contain %q", want)
+		}
+	}
+}
+
+func TestLimitErrors(t *testing.T) {
+	elements := strings.TrimSuffix(strings.Repeat("true, ", 65536), ", ")
+
+	program := parse("let a = 1;\n[" + elements + "]")
+	err := New().Compile(program)
+	if err == nil {
+		t.Fatalf("expected compiler error")
+	}
+	if err.Error() != "2:1: too many array elements (limit 65535)" {
+		t.Errorf("wrong compiler error. got=%q", err)
+	}
+}
+
+// localName 生成只包含字母的变量名，标识符中不能有数字
+func localName(i int) string {
+	name := ""
+	for {
+		name = string(rune('a'+i%26)) + name
+		i /= 26
+		if i == 0 {
+			return "v" + name
+		}
+	}
+}
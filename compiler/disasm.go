@@ -0,0 +1,161 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+	"myinterpreter/code"
+	"myinterpreter/object"
+	"sort"
+	"strconv"
+)
+
+// Disassemble 输出main指令以及常量池中每个函数的反汇编。
+// 常量、内置函数和全局变量名以注释的形式写在指令后面，跳转目标显示为标签；
+// symbols为nil时(例如从.mkc文件加载)不显示全局变量名
+func Disassemble(out io.Writer, bc *Bytecode, symbols *SymbolTable) {
+	var globals map[int]string
+	if symbols != nil {
+		globals = symbols.GlobalNames()
+	}
+	d := &disassembler{out: out, constants: bc.Constants, globals: globals}
+
+	fmt.Fprintf(out, "== <main> ==\n")
+	d.listing(bc.Instructions)
+	for i, c := range bc.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(out, "\n== constant %d: %s (params=%d, locals=%d) ==\n",
+			i, functionLabel(fn), fn.NumParameters, fn.NumLocals)
+		d.listing(fn.Instructions)
+	}
+}
+
+type disassembler struct {
+	out       io.Writer
+	constants []object.Object
+	globals   map[int]string
+}
+
+type disasmInstruction struct {
+	offset   int
+	op       code.Opcode
+	def      *code.Definition
+	operands []int
+	wide     bool
+}
+
+func (d *disassembler) listing(ins code.Instructions) {
+	var decoded []disasmInstruction
+	for i := 0; i < len(ins); {
+		in, err := code.ReadInstruction(ins, i)
+		if err != nil {
+			decoded = append(decoded, disasmInstruction{offset: i})
+			break
+		}
+		decoded = append(decoded, disasmInstruction{offset: i, op: in.Op, def: in.Def, operands: in.Operands, wide: in.Wide})
+		i += in.Len
+	}
+
+	labels := jumpLabels(decoded)
+	for _, in := range decoded {
+		if label, ok := labels[in.offset]; ok {
+			fmt.Fprintf(d.out, "%s:\n", label)
+		}
+		if in.def == nil {
+			fmt.Fprintf(d.out, "%04d error: opcode %d undefined\n", in.offset, ins[in.offset])
+			return
+		}
+		text, comment := d.instruction(in, labels)
+		if comment != "" {
+			fmt.Fprintf(d.out, "%04d %-24s ; %s\n", in.offset, text, comment)
+		} else {
+			fmt.Fprintf(d.out, "%04d %s\n", in.offset, text)
+		}
+	}
+	//跳到指令末尾的标签，例如循环结束后没有更多指令
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(d.out, "%s:\n", label)
+	}
+}
+
+func (d *disassembler) instruction(in disasmInstruction, labels map[int]string) (string, string) {
+	text := in.def.Name
+	if in.wide {
+		text = "OpWide " + text
+	}
+	if in.op == code.OpCompareJump {
+		cmp, _ := code.Lookup(byte(in.operands[0]))
+		if cmp != nil {
+			return text + " " + cmp.Name + " " + labels[in.operands[1]], ""
+		}
+	}
+	if pos, ok := jumpOperand(in.op); ok {
+		return text + " " + labels[in.operands[pos]], ""
+	}
+	for _, o := range in.operands {
+		text += " " + strconv.Itoa(o)
+	}
+
+	switch in.op {
+	case code.OpConstant:
+		return text, d.constantComment(in.operands[0])
+	case code.OpGetGlobal, code.OpSetGlobal:
+		return text, d.globals[in.operands[0]]
+	case code.OpGetBuiltin:
+		if idx := in.operands[0]; idx < len(object.Builtins) {
+			return text, object.Builtins[idx].Name
+		}
+	case code.OpClosure:
+		return text, fmt.Sprintf("%s, %d free", d.constantComment(in.operands[0]), in.operands[1])
+	}
+	return text, ""
+}
+
+func (d *disassembler) constantComment(idx int) string {
+	if idx >= len(d.constants) {
+		return "<invalid constant>"
+	}
+	switch c := d.constants[idx].(type) {
+	case *object.String:
+		return strconv.Quote(c.Value)
+	case *object.CompiledFunction:
+		return functionLabel(c)
+	default:
+		return c.Inspect()
+	}
+}
+
+// jumpLabels 按跳转目标在指令中的顺序为它们分配L0、L1...标签
+func jumpLabels(decoded []disasmInstruction) map[int]string {
+	var targets []int
+	seen := map[int]bool{}
+	for _, in := range decoded {
+		if in.def == nil {
+			continue
+		}
+		pos, ok := jumpOperand(in.op)
+		if !ok {
+			continue
+		}
+		if target := in.operands[pos]; !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	sort.Ints(targets)
+
+	labels := make(map[int]string, len(targets))
+	for i, target := range targets {
+		labels[target] = "L" + strconv.Itoa(i)
+	}
+	return labels
+}
+
+func functionLabel(fn *object.CompiledFunction) string {
+	if fn.Name == "" {
+		return "fn <anonymous>"
+	}
+	return "fn " + fn.Name
+}
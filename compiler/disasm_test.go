@@ -0,0 +1,66 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `
+let greet = fn(name) { puts("hello " + name) };
+let newCounter = fn() { let n = 0; fn() { n += 1 } };
+while (true) { break; }
+`
+	comp := New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `== <main> ==
+0000 OpClosure 1 0            ; fn greet, 0 free
+0004 OpSetGlobal 0            ; greet
+0007 OpClosure 5 0            ; fn newCounter, 0 free
+0011 OpSetGlobal 1            ; newCounter
+L0:
+0014 OpTrue
+0015 OpJumpNotTruthy L1
+0018 OpJump L1
+0021 OpJump L0
+L1:
+
+== constant 1: fn greet (params=1, locals=1) ==
+0000 OpGetBuiltin 1           ; puts
+0002 OpConstant 0             ; "hello "
+0005 OpGetLocal 0
+0007 OpAdd
+0008 OpCall 1
+0010 OpReturnValue
+
+== constant 4: fn <anonymous> (params=0, locals=0) ==
+0000 OpGetFree 0
+0002 OpConstant 3             ; 1
+0005 OpAdd
+0006 OpSetFree 0
+0008 OpGetFree 0
+0010 OpReturnValue
+
+== constant 5: fn newCounter (params=0, locals=1) ==
+0000 OpConstant 2             ; 0
+0003 OpSetLocal 0
+0005 OpGetLocalCell 0
+0007 OpClosure 4 1            ; fn <anonymous>, 1 free
+0011 OpReturnValue
+`
+	var out strings.Builder
+	Disassemble(&out, comp.Bytecode(), comp.SymbolTable())
+	if out.String() != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	Disassemble(&out, comp.Bytecode(), nil)
+	if !strings.Contains(out.String(), "0004 OpSetGlobal 0\n") {
+		t.Errorf("global names shown without symbol table:\n%s", out.String())
+	}
+}
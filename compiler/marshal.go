@@ -0,0 +1,445 @@
+package compiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"myinterpreter/code"
+	"myinterpreter/object"
+)
+
+// .mkc文件格式，所有变长整数使用encoding/binary的varint编码:
+//
+//	magic "MKC\x00" | version(uint16 大端) | filename | 常量池 | main指令 | main行号表
+//
+// 常量池先写常量个数，每个常量以一个字节的类型标记开头；
+// 字符串和字节序列都以uvarint长度开头。
+// 指令集改变(例如增加了新的opcode)时也要增加版本号，旧的虚拟机不能运行新的指令
+const (
+	BytecodeMagic   = "MKC\x00"
+	BytecodeVersion = 5 // 2: 增加OpCompareJump，3: 增加OpWide前缀，4: 函数增加局部变量名和自由变量名，5: 增加OpLessThan和OpLessThanEqual
+)
+
+const (
+	constInteger byte = iota + 1
+	constFloat
+	constString
+	constFunction
+)
+
+// Marshal 把编译结果编码为.mkc格式，常量池只能包含整数、浮点数、字符串和函数
+func Marshal(bc *Bytecode) ([]byte, error) {
+	e := &encoder{}
+	e.buf = append(e.buf, BytecodeMagic...)
+	e.buf = binary.BigEndian.AppendUint16(e.buf, BytecodeVersion)
+	e.string(bc.Filename)
+
+	e.uvarint(uint64(len(bc.Constants)))
+	for i, c := range bc.Constants {
+		err := e.constant(c)
+		if err != nil {
+			return nil, fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+	e.bytes(bc.Instructions)
+	e.bytes(bc.LineTable)
+	return e.buf, nil
+}
+
+// Unmarshal 解码Marshal的输出，magic或版本不匹配、数据被截断或者指令无效时返回错误
+func Unmarshal(data []byte) (*Bytecode, error) {
+	if len(data) < len(BytecodeMagic)+2 || string(data[:len(BytecodeMagic)]) != BytecodeMagic {
+		return nil, fmt.Errorf("not a monkey bytecode file")
+	}
+	data = data[len(BytecodeMagic):]
+	version := binary.BigEndian.Uint16(data)
+	if version != BytecodeVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %d, want %d", version, BytecodeVersion)
+	}
+
+	d := &decoder{data: data[2:]}
+	bc := &Bytecode{Filename: d.string()}
+	n := d.uvarint()
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		bc.Constants = append(bc.Constants, d.constant())
+	}
+	bc.Instructions = code.Instructions(d.bytes())
+	bc.LineTable = code.LineTable(d.bytes())
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.data) != 0 {
+		return nil, fmt.Errorf("%d bytes of trailing data", len(d.data))
+	}
+	if bc.Constants == nil {
+		bc.Constants = []object.Object{}
+	}
+	if err := validate(bc); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// maxGlobals和maxStack 分别和vm.GlobalsSize、vm.StackSize相同，vm导入了compiler，这里不能直接引用
+const (
+	maxGlobals = 65536
+	maxStack   = 2048
+)
+
+// validate 检查解码出的所有指令，损坏的文件在加载时报错，而不是让虚拟机panic：
+// opcode必须已定义、操作数不能超出指令末尾，常量、全局变量、局部变量、自由变量、
+// 内置函数的索引和跳转目标都必须在范围内，每条指令执行时栈上都要有足够的值，
+// 局部变量和栈的最大深度加起来不能超过虚拟机的栈
+func validate(bc *Bytecode) error {
+	main := &object.CompiledFunction{Instructions: bc.Instructions}
+	if err := validateFunction(main, bc.Constants); err != nil {
+		return fmt.Errorf("main: %w", err)
+	}
+	for i, c := range bc.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		if fn.NumParameters > fn.NumLocals {
+			return fmt.Errorf("constant %d: %d parameters but only %d locals", i, fn.NumParameters, fn.NumLocals)
+		}
+		if err := validateFunction(fn, bc.Constants); err != nil {
+			return fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validateFunction(fn *object.CompiledFunction, constants []object.Object) error {
+	ins := fn.Instructions
+	for ip := 0; ip < len(ins); {
+		in, err := code.ReadInstruction(ins, ip)
+		if err != nil {
+			return err
+		}
+		limit := -1
+		operand := 0
+		if len(in.Operands) > 0 {
+			operand = in.Operands[0]
+		}
+		switch in.Op {
+		case code.OpConstant:
+			limit = len(constants)
+		case code.OpClosure:
+			limit = len(constants)
+			if operand >= limit {
+				break
+			}
+			closure, ok := constants[operand].(*object.CompiledFunction)
+			if !ok {
+				return fmt.Errorf("OpClosure at %d: constant %d is not a function", ip, operand)
+			}
+			if in.Operands[1] != len(closure.FreeNames) {
+				return fmt.Errorf("OpClosure at %d: %d free variables, want %d", ip, in.Operands[1], len(closure.FreeNames))
+			}
+		case code.OpGetGlobal, code.OpSetGlobal:
+			limit = maxGlobals
+		case code.OpGetLocal, code.OpSetLocal, code.OpAssignLocal, code.OpGetLocalCell:
+			limit = fn.NumLocals
+		case code.OpGetFree, code.OpSetFree, code.OpGetFreeCell:
+			limit = len(fn.FreeNames)
+		case code.OpGetBuiltin:
+			limit = len(object.Builtins)
+		case code.OpJump, code.OpJumpNotTruthy, code.OpJumpTruthy:
+			limit = len(ins) + 1
+		case code.OpCompareJump:
+			operand, limit = in.Operands[1], len(ins)+1
+		}
+		if limit >= 0 && operand >= limit {
+			return fmt.Errorf("%s at %d: operand %d out of range", in.Def.Name, ip, operand)
+		}
+		ip += in.Len
+	}
+	return validateStack(fn)
+}
+
+// validateStack 沿所有执行路径计算每条指令执行前的栈深度(不含局部变量)。
+// 编译器生成的代码从不同路径到达同一条指令时栈深度都相同，不同时按损坏的文件处理
+func validateStack(fn *object.CompiledFunction) error {
+	ins := fn.Instructions
+	//validateFunction已经检查过每条指令
+	starts := make([]bool, len(ins)+1)
+	starts[len(ins)] = true
+	for ip := 0; ip < len(ins); {
+		starts[ip] = true
+		in, _ := code.ReadInstruction(ins, ip)
+		ip += in.Len
+	}
+	depths := make([]int, len(ins)+1)
+	for i := range depths {
+		depths[i] = -1
+	}
+	work := []int{}
+	reach := func(from, ip, depth int) error {
+		if !starts[ip] {
+			return fmt.Errorf("jump at %d: target %d is inside an instruction", from, ip)
+		}
+		if depths[ip] == -1 {
+			depths[ip] = depth
+			work = append(work, ip)
+		} else if depths[ip] != depth {
+			return fmt.Errorf("inconsistent stack depth at %d: %d and %d", ip, depths[ip], depth)
+		}
+		return nil
+	}
+
+	maxDepth := 0
+	if err := reach(0, 0, 0); err != nil {
+		return err
+	}
+	for len(work) > 0 {
+		ip := work[len(work)-1]
+		work = work[:len(work)-1]
+		if ip == len(ins) {
+			continue
+		}
+		in, _ := code.ReadInstruction(ins, ip)
+		depth := depths[ip]
+		pop, push := stackEffect(in)
+		if pop > depth {
+			return fmt.Errorf("%s at %d: stack underflow", in.Def.Name, ip)
+		}
+		depth += push - pop
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		next := ip + in.Len
+
+		var err error
+		switch in.Op {
+		case code.OpJump:
+			err = reach(ip, in.Operands[0], depth)
+		case code.OpJumpNotTruthy, code.OpJumpTruthy:
+			if err = reach(ip, next, depth); err == nil {
+				err = reach(ip, in.Operands[0], depth)
+			}
+		case code.OpCompareJump:
+			if err = reach(ip, next, depth); err == nil {
+				err = reach(ip, in.Operands[1], depth)
+			}
+		case code.OpIterNext:
+			//OpIterNext后面总是OpJumpNotTruthy：有下一个元素时留下元素，结束时什么也不留
+			jump, rerr := code.ReadInstruction(ins, next)
+			if rerr != nil || jump.Op != code.OpJumpNotTruthy {
+				return fmt.Errorf("OpIterNext at %d: not followed by OpJumpNotTruthy", ip)
+			}
+			if err = reach(ip, next+jump.Len, depth-1); err == nil {
+				err = reach(ip, jump.Operands[0], depth-2)
+			}
+		case code.OpReturnValue, code.OpReturn:
+		default:
+			err = reach(ip, next, depth)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if fn.NumLocals+maxDepth > maxStack {
+		return fmt.Errorf("%d locals and stack depth %d exceed the stack size %d", fn.NumLocals, maxDepth, maxStack)
+	}
+	return nil
+}
+
+// stackEffect 返回指令从栈上弹出和压入的值的个数
+func stackEffect(in code.Instruction) (pop, push int) {
+	switch in.Op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal, code.OpGetLocal,
+		code.OpGetBuiltin, code.OpGetFree, code.OpCurrentClosure, code.OpGetLocalCell, code.OpGetFreeCell:
+		return 0, 1
+	case code.OpPop, code.OpSetGlobal, code.OpSetLocal, code.OpAssignLocal, code.OpSetFree,
+		code.OpJumpNotTruthy, code.OpJumpTruthy, code.OpReturnValue:
+		return 1, 0
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpEqual, code.OpNotEqual,
+		code.OpGreaterThan, code.OpGreaterThanEqual, code.OpLessThan, code.OpLessThanEqual, code.OpIndex:
+		return 2, 1
+	case code.OpMinus, code.OpBang, code.OpIter:
+		return 1, 1
+	case code.OpArray, code.OpHash:
+		return in.Operands[0], 1
+	case code.OpCall:
+		return in.Operands[0] + 1, 1
+	case code.OpClosure:
+		return in.Operands[1], 1
+	case code.OpSetIndex:
+		return 3, 1
+	case code.OpDupTwo:
+		return 2, 4
+	case code.OpCompareJump:
+		return 2, 0
+	case code.OpIterNext:
+		return 1, 2
+	}
+	return 0, 0
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+func (e *encoder) strings(list []string) {
+	e.uvarint(uint64(len(list)))
+	for _, s := range list {
+		e.string(s)
+	}
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf = append(e.buf, constInteger)
+		e.buf = binary.AppendVarint(e.buf, obj.Value)
+	case *object.Float:
+		e.buf = append(e.buf, constFloat)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(obj.Value))
+	case *object.String:
+		e.buf = append(e.buf, constString)
+		e.string(obj.Value)
+	case *object.CompiledFunction:
+		e.buf = append(e.buf, constFunction)
+		e.uvarint(uint64(obj.NumLocals))
+		e.uvarint(uint64(obj.NumParameters))
+		e.string(obj.Name)
+		e.string(obj.Filename)
+		e.bytes(obj.Instructions)
+		e.bytes(obj.LineTable)
+		e.strings(obj.LocalNames)
+		e.strings(obj.FreeNames)
+	default:
+		return fmt.Errorf("cannot marshal %s", obj.Type())
+	}
+	return nil
+}
+
+// decoder 遇到第一个错误后记录在err中，之后的读取都返回零值
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(format string, a ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, a...)
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.fail("unexpected end of bytecode")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail("malformed varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail("malformed varint")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.data)) < n {
+		d.fail("unexpected end of bytecode")
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, d.data)
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) strings() []string {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) { //每个字符串至少占一个字节，防止错误的长度导致分配过多内存
+		d.fail("unexpected end of bytecode")
+		return nil
+	}
+	list := make([]string, n)
+	for i := range list {
+		list[i] = d.string()
+	}
+	return list
+}
+
+func (d *decoder) constant() object.Object {
+	switch tag := d.byte(); tag {
+	case constInteger:
+		return &object.Integer{Value: d.varint()}
+	case constFloat:
+		if len(d.data) < 8 {
+			d.fail("unexpected end of bytecode")
+			return nil
+		}
+		bits := binary.BigEndian.Uint64(d.data)
+		d.data = d.data[8:]
+		return &object.Float{Value: math.Float64frombits(bits)}
+	case constString:
+		return &object.String{Value: d.string()}
+	case constFunction:
+		fn := &object.CompiledFunction{
+			NumLocals:     int(d.uvarint()),
+			NumParameters: int(d.uvarint()),
+			Name:          d.string(),
+			Filename:      d.string(),
+		}
+		fn.Instructions = code.Instructions(d.bytes())
+		fn.LineTable = code.LineTable(d.bytes())
+		fn.LocalNames = d.strings()
+		fn.FreeNames = d.strings()
+		return fn
+	default:
+		d.fail("unknown constant tag %d", tag)
+		return nil
+	}
+}
@@ -0,0 +1,131 @@
+package compiler
+
+import (
+	"fmt"
+	"myinterpreter/code"
+	"myinterpreter/object"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalRoundTrip(t *testing.T) {
+	input := `
+let greet = fn(name) { "hello " + name };
+let counter = fn() { let n = 0; fn() { n += 1 } }();
+greet("monkey");
+[1, -2, 3.5, 1e-9];
+for (x in [1, 2]) { if (x > 1 && x < 3) { break } else { continue } };
+`
+	program := parse(input)
+	comp := New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+
+	data, err := Marshal(bytecode)
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+	if !strings.HasPrefix(string(data), BytecodeMagic) {
+		t.Fatalf("missing magic header. got=%q", data[:4])
+	}
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("unmarshal error: %s", err)
+	}
+	if !reflect.DeepEqual(bytecode, decoded) {
+		t.Errorf("bytecode changed after round trip.\nwant=%+v\ngot =%+v", bytecode, decoded)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	comp := New()
+	err := comp.Compile(parse(`fn(a) { a + "x" }`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	data, err := Marshal(comp.Bytecode())
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+
+	wrongVersion := append([]byte{}, data...)
+	wrongVersion[5] = BytecodeVersion + 1
+
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("let x = 1;"), "not a monkey bytecode file"},
+		{wrongVersion, fmt.Sprintf("unsupported bytecode version %d, want %d", BytecodeVersion+1, BytecodeVersion)},
+		{data[:len(data)-3], "unexpected end of bytecode"},
+		{append(append([]byte{}, data...), 0), "1 bytes of trailing data"},
+	}
+
+	for _, ts := range tests {
+		_, err := Unmarshal(ts.data)
+		if err == nil {
+			t.Errorf("expected error %q, got none", ts.expected)
+			continue
+		}
+		if err.Error() != ts.expected {
+			t.Errorf("wrong error. want=%q, got=%q", ts.expected, err)
+		}
+	}
+}
+
+func TestUnmarshalInvalidInstructions(t *testing.T) {
+	fn := &object.CompiledFunction{Instructions: code.Make(code.OpGetLocal, 1), NumLocals: 1}
+	concat := func(ins ...[]byte) code.Instructions {
+		out := code.Instructions{}
+		for _, in := range ins {
+			out = append(out, in...)
+		}
+		return out
+	}
+
+	tests := []struct {
+		bytecode *Bytecode
+		expected string
+	}{
+		{&Bytecode{Instructions: code.Instructions{255}}, "main: opcode 255 undefined"},
+		{&Bytecode{Instructions: code.Make(code.OpConstant, 1)[:2]}, "main: truncated OpConstant at 0"},
+		{&Bytecode{Instructions: code.Make(code.OpConstant, 1), Constants: []object.Object{&object.Integer{}}}, "main: OpConstant at 0: operand 1 out of range"},
+		{&Bytecode{Instructions: code.Make(code.OpGetLocal, 0)}, "main: OpGetLocal at 0: operand 0 out of range"},
+		{&Bytecode{Instructions: code.Make(code.OpGetBuiltin, 200)}, "main: OpGetBuiltin at 0: operand 200 out of range"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpPop), code.Make(code.OpJump, 5))}, "main: OpJump at 1: operand 5 out of range"},
+		{&Bytecode{Instructions: code.Make(code.OpClosure, 0, 0), Constants: []object.Object{&object.Integer{}}}, "main: OpClosure at 0: constant 0 is not a function"},
+		{&Bytecode{Instructions: code.Make(code.OpClosure, 0, 1), Constants: []object.Object{fn}}, "main: OpClosure at 0: 1 free variables, want 0"},
+		{&Bytecode{Constants: []object.Object{fn}}, "constant 0: OpGetLocal at 0: operand 1 out of range"},
+		{&Bytecode{Instructions: code.Make(code.OpAdd)}, "main: OpAdd at 0: stack underflow"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpCall, 1))}, "main: OpCall at 1: stack underflow"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpNull), code.Make(code.OpJump, 2))}, "main: jump at 1: target 2 is inside an instruction"},
+		{&Bytecode{Instructions: concat(
+			code.Make(code.OpTrue),
+			code.Make(code.OpTrue),
+			code.Make(code.OpJumpNotTruthy, 6),
+			code.Make(code.OpTrue),
+			code.Make(code.OpPop),
+		)}, "main: inconsistent stack depth at 6: 1 and 2"},
+		{&Bytecode{Instructions: concat(code.Make(code.OpNull), code.Make(code.OpIterNext), code.Make(code.OpPop))}, "main: OpIterNext at 1: not followed by OpJumpNotTruthy"},
+		{&Bytecode{Constants: []object.Object{&object.CompiledFunction{Instructions: code.Make(code.OpReturn), NumLocals: 100000}}},
+			"constant 0: 100000 locals and stack depth 0 exceed the stack size 2048"},
+	}
+
+	for _, ts := range tests {
+		if ts.bytecode.Constants == nil {
+			ts.bytecode.Constants = []object.Object{}
+		}
+		data, err := Marshal(ts.bytecode)
+		if err != nil {
+			t.Fatalf("marshal error: %s", err)
+		}
+		_, err = Unmarshal(data)
+		if err == nil || err.Error() != ts.expected {
+			t.Errorf("wrong error. want=%q, got=%v", ts.expected, err)
+		}
+	}
+}
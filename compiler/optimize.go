@@ -0,0 +1,251 @@
+package compiler
+
+import (
+	"math"
+	"myinterpreter/ast"
+	"myinterpreter/code"
+	"myinterpreter/object"
+	"strconv"
+)
+
+// Option 配置编译器，传给New或NewWithState
+type Option func(*Compiler)
+
+// 优化级别，级别越高包含的优化越多
+const (
+	OptNone     = iota // 不优化，按语法树逐个节点生成指令
+	OptBasic           // 常量折叠、删除条件为常量的if分支、常量池去重
+	OptPeephole        // 在OptBasic的基础上对生成的指令做窥孔优化
+)
+
+// WithOptimization 设置优化级别，默认是OptNone
+func WithOptimization(level int) Option {
+	return func(c *Compiler) {
+		c.optimization = level
+	}
+}
+
+// constantValue 在编译期计算只由字面量组成的表达式，结果和虚拟机运行时完全一致。
+// 运行时会出错的表达式(除以0、类型不匹配)不折叠
+func constantValue(node ast.Expression) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}, true
+	case *ast.Boolean:
+		return nativeBool(node.Value), true
+	case *ast.PrefixExpression:
+		right, ok := constantValue(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldPrefix(node.Operator, right)
+	case *ast.InfixExpression:
+		left, ok := constantValue(node.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := constantValue(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldInfix(node.Operator, left, right)
+	}
+	return nil, false
+}
+
+func foldPrefix(operator string, right object.Object) (object.Object, bool) {
+	switch operator {
+	case "!":
+		return nativeBool(!constantTruthy(right)), true
+	case "-":
+		if i, ok := right.(*object.Integer); ok {
+			return &object.Integer{Value: -i.Value}, true
+		}
+	}
+	return nil, false
+}
+
+func foldInfix(operator string, left, right object.Object) (object.Object, bool) {
+	switch operator {
+	case "&&":
+		return nativeBool(constantTruthy(left) && constantTruthy(right)), true
+	case "||":
+		return nativeBool(constantTruthy(left) || constantTruthy(right)), true
+	}
+
+	switch left := left.(type) {
+	case *object.Integer:
+		right, ok := right.(*object.Integer)
+		if !ok {
+			return nil, false
+		}
+		return foldIntegers(operator, left.Value, right.Value)
+	case *object.String:
+		right, ok := right.(*object.String)
+		if !ok {
+			return nil, false
+		}
+		switch operator {
+		case "+":
+			return &object.String{Value: left.Value + right.Value}, true
+		case "==":
+			return nativeBool(left.Value == right.Value), true
+		case "!=":
+			return nativeBool(left.Value != right.Value), true
+		}
+	case *object.Boolean:
+		right, ok := right.(*object.Boolean)
+		if !ok {
+			return nil, false
+		}
+		switch operator {
+		case "==":
+			return nativeBool(left.Value == right.Value), true
+		case "!=":
+			return nativeBool(left.Value != right.Value), true
+		}
+	}
+	return nil, false
+}
+
+func foldIntegers(operator string, l, r int64) (object.Object, bool) {
+	switch operator {
+	case "+":
+		return &object.Integer{Value: l + r}, true
+	case "-":
+		return &object.Integer{Value: l - r}, true
+	case "*":
+		return &object.Integer{Value: l * r}, true
+	case "/", "%":
+		if r == 0 {
+			return nil, false
+		}
+		if operator == "/" {
+			return &object.Integer{Value: l / r}, true
+		}
+		return &object.Integer{Value: l % r}, true
+	case "<":
+		return nativeBool(l < r), true
+	case "<=":
+		return nativeBool(l <= r), true
+	case ">":
+		return nativeBool(l > r), true
+	case ">=":
+		return nativeBool(l >= r), true
+	case "==":
+		return nativeBool(l == r), true
+	case "!=":
+		return nativeBool(l != r), true
+	}
+	return nil, false
+}
+
+// constantTruthy 和虚拟机的isTruthy一致，常量不会是null
+func constantTruthy(obj object.Object) bool {
+	if b, ok := obj.(*object.Boolean); ok {
+		return b.Value
+	}
+	return true
+}
+
+func nativeBool(v bool) *object.Boolean {
+	return &object.Boolean{Value: v}
+}
+
+func (c *Compiler) emitConstantValue(obj object.Object) {
+	if b, ok := obj.(*object.Boolean); ok {
+		if b.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+		return
+	}
+	c.emit(code.OpConstant, c.addConstant(obj))
+}
+
+// compileConstantIf 编译条件为常量的if，只生成会执行的分支
+func (c *Compiler) compileConstantIf(node *ast.IfExpression, condition object.Object) error {
+	taken, dropped := node.Consequence, node.Alternative
+	if !constantTruthy(condition) {
+		taken, dropped = node.Alternative, node.Consequence
+	}
+	if dropped != nil {
+		err := c.compileDiscarded(dropped)
+		if err != nil {
+			return err
+		}
+	}
+	if taken == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+	return c.compileBlockValue(taken)
+}
+
+// compileDiscarded 编译不会执行的代码然后丢弃生成的指令和常量。
+// 仍然要编译一遍，这样其中的let和不优化时一样定义变量，错误也一样会报告
+func (c *Compiler) compileDiscarded(node ast.Node) error {
+	scope := &c.scopes[c.scopeIndex]
+	start := len(scope.instructions)
+	last, previous := scope.lastInstruction, scope.previousInstruction
+	numEntries := len(scope.lineEntries)
+	numConstants := len(c.constants)
+	numBreaks := 0
+	if loop := c.currentLoop(); loop != nil {
+		numBreaks = len(loop.breakJumps)
+	}
+
+	err := c.Compile(node)
+	if err != nil {
+		return err
+	}
+
+	scope = &c.scopes[c.scopeIndex]
+	scope.instructions = scope.instructions[:start]
+	scope.lastInstruction, scope.previousInstruction = last, previous
+	scope.lineEntries = scope.lineEntries[:numEntries]
+	if loop := c.currentLoop(); loop != nil {
+		loop.breakJumps = loop.breakJumps[:numBreaks]
+	}
+	c.constants = c.constants[:numConstants]
+	for key, idx := range c.constantIndex {
+		if idx >= numConstants {
+			delete(c.constantIndex, key)
+		}
+	}
+	return nil
+}
+
+// constantKey 标识常量池中可以共用的常量，函数不参与去重
+type constantKey struct {
+	typ   object.ObjectType
+	value string
+}
+
+func keyOf(obj object.Object) (constantKey, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return constantKey{obj.Type(), strconv.FormatInt(obj.Value, 10)}, true
+	case *object.Float:
+		//按位比较，0.0和-0.0是不同的常量
+		return constantKey{obj.Type(), strconv.FormatUint(math.Float64bits(obj.Value), 16)}, true
+	case *object.String:
+		return constantKey{obj.Type(), obj.Value}, true
+	}
+	return constantKey{}, false
+}
+
+// indexConstants 为已有的常量建立索引，REPL接着上一次的常量池编译时需要
+func (c *Compiler) indexConstants() {
+	c.constantIndex = make(map[constantKey]int)
+	for i, obj := range c.constants {
+		if key, ok := keyOf(obj); ok {
+			if _, exists := c.constantIndex[key]; !exists {
+				c.constantIndex[key] = i
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package compiler
+
+import (
+	"myinterpreter/code"
+	"testing"
+)
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3",
+			expectedConstants: []any{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" + "key"`,
+			expectedConstants: []any{"monkey"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			//字符串按值比较，可以折叠
+			input:             `"ab" == "a" + "b"`,
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "!(1 < 2) || true == false; -(10 % 4)",
+			expectedConstants: []any{-2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			//只有常量部分被折叠
+			input:             "let x = 1; x + (2 * 3)",
+			expectedConstants: []any{1, 6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			//运行时会出错的表达式保持原样
+			input:             `1 / 0; 1 + "a"`,
+			expectedConstants: []any{1, 0, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWithOptions(t, tests, WithOptimization(OptBasic))
+}
+
+func TestDeadBranchElimination(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (1 > 2) { 10 } else { 20 }; 3333",
+			expectedConstants: []any{20, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (true) { 10 }",
+			expectedConstants: []any{10},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { 10 }",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			//被删除的分支中的let仍然定义变量
+			input:             "if (false) { let x = fn() { 1 }; }; x",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "while (true) { if (false) { break; } else { 1 } }",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 11),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 0),
+			},
+		},
+	}
+
+	runCompilerTestsWithOptions(t, tests, WithOptimization(OptBasic))
+}
+
+func TestConstantDeduplication(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let a = 1; let b = "x"; [1, "x", 1.5, 1, 1.5, "x"]`,
+			expectedConstants: []any{1, "x", 1.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 6),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWithOptions(t, tests, WithOptimization(OptBasic))
+}
@@ -0,0 +1,232 @@
+package compiler
+
+import (
+	"myinterpreter/code"
+)
+
+// peepholeInstruction 是解码后的一条指令，优化过程中只标记删除，最后统一重新排布
+type peepholeInstruction struct {
+	offset   int
+	op       code.Opcode
+	operands []int
+	deleted  bool
+	line     int
+	column   int
+}
+
+// peephole 对一个函数(或main)的指令做窥孔优化：
+//
+//   - 跳转到无条件跳转的跳转直接跳到最终目标，跳到下一条指令的OpJump被删除
+//   - 压入后立即弹出的无副作用指令对(例如OpGetLocal; OpPop)被删除
+//   - 比较指令后面紧跟的OpJumpNotTruthy合并成OpCompareJump
+//   - OpBang后面紧跟的OpJumpNotTruthy合并成OpJumpTruthy
+//
+// 删除指令后重新计算跳转目标和行号表。keepLastPop为true时保留最后一个OpPop，
+// REPL和测试通过LastPoppedStackElem读取main最后一个表达式的值
+func peephole(ins code.Instructions, entries []code.LineEntry, keepLastPop bool) (code.Instructions, []code.LineEntry) {
+	decoded, ok := decodeForPeephole(ins, entries)
+	if !ok {
+		return ins, entries
+	}
+	lastPop := -1
+	if keepLastPop {
+		for i := len(decoded) - 1; i >= 0; i-- {
+			if decoded[i].op == code.OpPop {
+				lastPop = i
+				break
+			}
+		}
+	}
+
+	p := &peepholeOptimizer{ins: decoded, length: len(ins), lastPop: lastPop}
+	for p.pass() {
+	}
+	return p.assemble()
+}
+
+func decodeForPeephole(ins code.Instructions, entries []code.LineEntry) ([]*peepholeInstruction, bool) {
+	var decoded []*peepholeInstruction
+	entry := -1
+	for i := 0; i < len(ins); {
+		read, err := code.ReadInstruction(ins, i)
+		if err != nil {
+			return nil, false
+		}
+		for entry+1 < len(entries) && entries[entry+1].Offset <= i {
+			entry++
+		}
+		in := &peepholeInstruction{offset: i, op: read.Op, operands: read.Operands}
+		if entry >= 0 {
+			in.line, in.column = entries[entry].Line, entries[entry].Column
+		}
+		decoded = append(decoded, in)
+		i += read.Len
+	}
+	return decoded, true
+}
+
+type peepholeOptimizer struct {
+	ins     []*peepholeInstruction
+	length  int //原指令的字节数，跳到这里表示跳到末尾
+	lastPop int
+}
+
+// pass 把所有规则应用一遍，有修改时返回true
+func (p *peepholeOptimizer) pass() bool {
+	changed := false
+	targets := p.jumpTargets()
+
+	for i, in := range p.ins {
+		if in.deleted {
+			continue
+		}
+		next := p.next(i)
+
+		if pos, ok := jumpOperand(in.op); ok {
+			//跳转串联：目标是无条件跳转时直接跳到它的目标
+			for steps := 0; steps < len(p.ins); steps++ {
+				target := p.at(in.operands[pos])
+				if target < 0 || p.ins[target].op != code.OpJump || p.ins[target] == in {
+					break
+				}
+				if p.ins[target].operands[0] == in.operands[pos] {
+					break
+				}
+				in.operands[pos] = p.ins[target].operands[0]
+				changed = true
+			}
+			if in.op == code.OpJump && p.at(in.operands[0]) == next {
+				in.deleted = true
+				changed = true
+			}
+			continue
+		}
+
+		if next < 0 || targets[p.ins[next].offset] {
+			continue
+		}
+		nextIn := p.ins[next]
+		switch {
+		case isPurePush(in.op) && nextIn.op == code.OpPop && next != p.lastPop:
+			in.deleted, nextIn.deleted = true, true
+			changed = true
+		case isComparison(in.op) && nextIn.op == code.OpJumpNotTruthy:
+			in.operands = []int{int(in.op), nextIn.operands[0]}
+			in.op = code.OpCompareJump
+			nextIn.deleted = true
+			changed = true
+		case in.op == code.OpBang && nextIn.op == code.OpJumpNotTruthy:
+			nextIn.op = code.OpJumpTruthy
+			in.deleted = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// jumpTargets 返回还存在的跳转指令的全部目标，合并或删除被跳转到的指令会改变程序的含义
+func (p *peepholeOptimizer) jumpTargets() map[int]bool {
+	targets := make(map[int]bool)
+	for _, in := range p.ins {
+		if pos, ok := jumpOperand(in.op); ok && !in.deleted {
+			if target := p.at(in.operands[pos]); target >= 0 {
+				targets[p.ins[target].offset] = true
+			}
+		}
+	}
+	return targets
+}
+
+// at 返回原偏移量offset处或之后第一条没有被删除的指令，跳到末尾时返回-1
+func (p *peepholeOptimizer) at(offset int) int {
+	for i, in := range p.ins {
+		if in.offset >= offset && !in.deleted {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *peepholeOptimizer) next(i int) int {
+	for j := i + 1; j < len(p.ins); j++ {
+		if !p.ins[j].deleted {
+			return j
+		}
+	}
+	return -1
+}
+
+// assemble 重新编码剩下的指令，跳转目标换算成新的偏移量，行号表按新偏移量重建
+func (p *peepholeOptimizer) assemble() (code.Instructions, []code.LineEntry) {
+	newOffsets := make(map[int]int, len(p.ins)+1)
+	offset := 0
+	for _, in := range p.ins {
+		newOffsets[in.offset] = offset
+		if !in.deleted {
+			offset += len(in.encode())
+		}
+	}
+	newOffsets[p.length] = offset
+
+	//被删除的指令映射到它后面第一条保留的指令
+	for i := len(p.ins) - 1; i >= 0; i-- {
+		if p.ins[i].deleted {
+			if i+1 < len(p.ins) {
+				newOffsets[p.ins[i].offset] = newOffsets[p.ins[i+1].offset]
+			} else {
+				newOffsets[p.ins[i].offset] = offset
+			}
+		}
+	}
+
+	ins := code.Instructions{}
+	entries := []code.LineEntry{}
+	for _, in := range p.ins {
+		if in.deleted {
+			continue
+		}
+		if pos, ok := jumpOperand(in.op); ok {
+			in.operands[pos] = newOffsets[in.operands[pos]]
+		}
+		if n := len(entries); n == 0 || entries[n-1].Line != in.line || entries[n-1].Column != in.column {
+			entries = append(entries, code.LineEntry{Offset: len(ins), Line: in.line, Column: in.column})
+		}
+		ins = append(ins, in.encode()...)
+	}
+	return ins, entries
+}
+
+// encode 重新编码指令。窥孔优化不会让操作数变大，原来放得下的现在也放得下
+func (in *peepholeInstruction) encode() []byte {
+	b, _ := code.Encode(in.op, in.operands...)
+	return b
+}
+
+// jumpOperand 返回跳转指令中跳转目标是第几个操作数
+func jumpOperand(op code.Opcode) (int, bool) {
+	switch op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpJumpTruthy:
+		return 0, true
+	case code.OpCompareJump:
+		return 1, true
+	}
+	return 0, false
+}
+
+// isPurePush 判断指令是否只是压入一个值，既不读栈也不会出错
+func isPurePush(op code.Opcode) bool {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal,
+		code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree, code.OpCurrentClosure:
+		return true
+	}
+	return false
+}
+
+func isComparison(op code.Opcode) bool {
+	switch op {
+	case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanEqual, code.OpLessThan, code.OpLessThanEqual:
+		return true
+	}
+	return false
+}
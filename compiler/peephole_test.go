@@ -0,0 +1,119 @@
+package compiler
+
+import (
+	"myinterpreter/code"
+	"testing"
+)
+
+func TestPeephole(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			//比较和条件跳转合并
+			input:             "let a = 1; if (a == 2) { 10 }",
+			expectedConstants: []any{1, 2, 10},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				// 0012
+				code.Make(code.OpCompareJump, int(code.OpEqual), 22),
+				// 0016
+				code.Make(code.OpConstant, 2),
+				// 0019
+				code.Make(code.OpJump, 23),
+				// 0022
+				code.Make(code.OpNull),
+				// 0023
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			//跳转串联：内层分支结束后直接跳到最外层的结尾
+			input:             "let a = 1; if (a) { if (a) { 1 } else { 2 } } else { 3 }",
+			expectedConstants: []any{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpJumpNotTruthy, 30),
+				// 0012
+				code.Make(code.OpGetGlobal, 0),
+				// 0015
+				code.Make(code.OpJumpNotTruthy, 24),
+				// 0018
+				code.Make(code.OpConstant, 0),
+				// 0021
+				code.Make(code.OpJump, 33),
+				// 0024
+				code.Make(code.OpConstant, 1),
+				// 0027
+				code.Make(code.OpJump, 33),
+				// 0030
+				code.Make(code.OpConstant, 2),
+				// 0033
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			//无用的压入弹出被删除，!和条件跳转合并成OpJumpTruthy
+			input: "fn(x) { x; while (!x) { 1; } }",
+			expectedConstants: []any{
+				1,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal, 0),
+					// 0002
+					code.Make(code.OpJumpTruthy, 8),
+					// 0005
+					code.Make(code.OpJump, 0),
+					// 0008
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWithOptions(t, tests, WithOptimization(OptPeephole))
+}
+
+func TestPeepholeLineTable(t *testing.T) {
+	input := "let a = 1;\na;\na + \"x\""
+
+	compiler := New(WithOptimization(OptPeephole))
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	//let a = 1; a + "x"，第二行的a;被删除
+	expected := []struct {
+		offset int
+		line   int
+		column int
+	}{
+		{0, 1, 9},  // OpConstant 1
+		{3, 1, 1},  // OpSetGlobal
+		{6, 3, 1},  // OpGetGlobal
+		{12, 3, 3}, // OpAdd
+	}
+	for _, e := range expected {
+		line, column := bytecode.LineTable.Lookup(e.offset)
+		if line != e.line || column != e.column {
+			t.Errorf("wrong position at %d. want=%d:%d, got=%d:%d", e.offset, e.line, e.column, line, column)
+		}
+	}
+}
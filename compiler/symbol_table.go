@@ -21,6 +21,7 @@ type SymbolTable struct {
 	store          map[string]Symbol
 	numDefinitions int
 	FreeSymbols    []Symbol
+	names          []string //按槽位记录Define的名字，被同名let覆盖的槽位也保留
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -50,6 +51,7 @@ func (s *SymbolTable) Define(name string) Symbol {
 	}
 	s.store[name] = symbol
 	s.numDefinitions++
+	s.names = append(s.names, name)
 	return symbol
 }
 
@@ -82,3 +84,37 @@ func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.store[original.Name] = symbol
 	return symbol
 }
+
+// Clone 返回当前作用域的浅拷贝，在副本上Define不会影响原来的符号表
+func (s *SymbolTable) Clone() *SymbolTable {
+	clone := &SymbolTable{
+		Outer:          s.Outer,
+		store:          make(map[string]Symbol, len(s.store)),
+		numDefinitions: s.numDefinitions,
+		FreeSymbols:    append([]Symbol{}, s.FreeSymbols...),
+		names:          append([]string{}, s.names...),
+	}
+	for name, sym := range s.store {
+		clone.store[name] = sym
+	}
+	return clone
+}
+
+// DefinedNames 返回当前作用域每个槽位的变量名，下标就是槽位
+func (s *SymbolTable) DefinedNames() []string {
+	return append([]string{}, s.names...)
+}
+
+// GlobalNames 返回全局变量的槽位到名字的映射，被同名let覆盖的旧槽位不在其中
+func (s *SymbolTable) GlobalNames() map[int]string {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	names := make(map[int]string)
+	for name, sym := range s.store {
+		if sym.Scope == GlobalScope {
+			names[sym.Index] = name
+		}
+	}
+	return names
+}
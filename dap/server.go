@@ -0,0 +1,615 @@
+// Package dap 实现调试适配器协议(Debug Adapter Protocol)，让编辑器通过标准输入输出调试脚本。
+// 断点和单步执行由debugger包完成，调用栈和变量直接来自虚拟机
+package dap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"myinterpreter/code"
+	"myinterpreter/compiler"
+	"myinterpreter/debugger"
+	"myinterpreter/evaluator"
+	"myinterpreter/lexer"
+	"myinterpreter/object"
+	"myinterpreter/parser"
+	"myinterpreter/vm"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 脚本只有一个线程
+const threadID = 1
+
+// Server 在一个连接上处理一次调试会话。请求在Serve的goroutine中处理，
+// 脚本在另一个goroutine中运行，暂停时通过resume等待下一个动作
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	writeMu sync.Mutex //保护out和seq，事件和响应来自不同的goroutine
+	seq     int
+
+	program  string
+	lines    map[int]bool //有代码的行，用来确认断点
+	launch   LaunchArguments
+	debugger *debugger.Debugger
+
+	started bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{} //脚本结束后关闭
+	resume  chan debugger.Action
+
+	mu   sync.Mutex //保护stop和refs
+	stop *debugger.Stop
+	refs []func() []Variable //variablesReference减一是下标，每次暂停时清空
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:     bufio.NewReader(in),
+		out:    out,
+		resume: make(chan debugger.Action),
+	}
+}
+
+// Serve 处理请求直到收到disconnect或者输入结束，结束前停止还在运行的脚本
+func (s *Server) Serve() error {
+	defer s.terminate()
+	for {
+		data, err := ReadMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req Request
+		if err := json.Unmarshal(data, &req); err != nil {
+			return fmt.Errorf("dap: %w", err)
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if req.Command == "disconnect" {
+			s.terminate()
+			return s.respond(&req, nil)
+		}
+		body, err := s.handle(&req)
+		switch {
+		case err == errResponded:
+			err = nil
+		case err != nil:
+			err = s.fail(&req, err)
+		default:
+			err = s.respond(&req, body)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle 处理一个请求，返回响应的body。恢复执行的请求在handle返回之前已经发出响应
+func (s *Server) handle(req *Request) (any, error) {
+	switch req.Command {
+	case "initialize":
+		return Capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsFunctionBreakpoints:      true,
+			SupportsTerminateRequest:         true,
+			SupportsEvaluateForHovers:        true,
+		}, nil
+	case "launch":
+		var args LaunchArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		if err := s.load(args); err != nil {
+			return nil, err
+		}
+		//编译成功之后才能确认断点，所以在launch之后才发initialized
+		s.respond(req, nil)
+		s.event("initialized", nil)
+		return nil, errResponded
+	case "setBreakpoints":
+		var args SetBreakpointsArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return map[string]any{"breakpoints": s.setBreakpoints(args)}, nil
+	case "setFunctionBreakpoints":
+		var args SetFunctionBreakpointsArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return map[string]any{"breakpoints": s.setFunctionBreakpoints(args)}, nil
+	case "setExceptionBreakpoints":
+		return nil, nil
+	case "configurationDone":
+		if s.debugger == nil {
+			return nil, errors.New("no program launched")
+		}
+		s.respond(req, nil)
+		s.start()
+		return nil, errResponded
+	case "threads":
+		return map[string]any{"threads": []Thread{{ID: threadID, Name: "main"}}}, nil
+	case "stackTrace":
+		var args StackTraceArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return s.stackTrace(args)
+	case "scopes":
+		var args ScopesArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return s.scopes(args)
+	case "variables":
+		var args VariablesArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return s.variables(args)
+	case "evaluate":
+		var args EvaluateArguments
+		if err := decode(req, &args); err != nil {
+			return nil, err
+		}
+		return s.evaluate(args)
+	case "continue":
+		return nil, s.resumeWith(req, debugger.Continue, map[string]any{"allThreadsContinued": true})
+	case "next":
+		return nil, s.resumeWith(req, debugger.StepOver, nil)
+	case "stepIn":
+		return nil, s.resumeWith(req, debugger.StepInto, nil)
+	case "stepOut":
+		return nil, s.resumeWith(req, debugger.StepOut, nil)
+	case "pause":
+		//已经暂停时什么也不做
+		if _, err := s.paused(); err != nil && s.debugger != nil {
+			s.debugger.Pause()
+		}
+		return nil, nil
+	case "terminate":
+		s.terminate()
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported request %q", req.Command)
+}
+
+// errResponded 表示handle已经发出了响应，Serve不用再响应。
+// 必须在响应之后才做的事情(比如恢复执行)用到它，保证客户端先收到响应再收到后续事件
+var errResponded = errors.New("dap: already responded")
+
+func (s *Server) respond(req *Request, body any) error {
+	return s.send(&Response{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *Server) fail(req *Request, err error) error {
+	return s.send(&Response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: err.Error()})
+}
+
+func (s *Server) event(name string, body any) error {
+	return s.send(&Event{Type: "event", Event: name, Body: body})
+}
+
+func (s *Server) send(msg any) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.seq++
+	switch msg := msg.(type) {
+	case *Response:
+		msg.Seq = s.seq
+	case *Event:
+		msg.Seq = s.seq
+	}
+	return WriteMessage(s.out, msg)
+}
+
+func decode(req *Request, v any) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(req.Arguments, v); err != nil {
+		return fmt.Errorf("bad arguments for %s: %s", req.Command, err)
+	}
+	return nil
+}
+
+// load 编译要调试的脚本并创建虚拟机和调试器，脚本在configurationDone之后才开始运行。
+// 不做优化以便指令和源码行一一对应
+func (s *Server) load(args LaunchArguments) error {
+	if s.debugger != nil {
+		return errors.New("a program is already launched")
+	}
+	if args.Program == "" {
+		return errors.New("launch: program is required")
+	}
+	program, err := filepath.Abs(args.Program)
+	if err != nil {
+		return err
+	}
+	src, err := os.ReadFile(program)
+	if err != nil {
+		return err
+	}
+	comp, err := compile(program, string(src))
+	if err != nil {
+		return err
+	}
+
+	argsArray := &object.Array{Elements: make([]object.Object, len(args.Args))}
+	for i, arg := range args.Args {
+		argsArray.Elements[i] = &object.String{Value: arg}
+	}
+	globals := make([]object.Object, vm.GlobalsSize)
+	globals[0] = argsArray
+
+	bytecode := comp.Bytecode()
+	s.program = program
+	s.launch = args
+	s.lines = codeLines(bytecode)
+	machine := vm.NewWithState(bytecode, globals, s.builtins())
+	s.debugger = debugger.New(machine, comp.SymbolTable().GlobalNames(), s.stopped)
+	s.debugger.StopOnEntry(args.StopOnEntry && !args.NoDebug)
+	return nil
+}
+
+// compile 解析、展开宏并编译脚本，和monkey run一样把args定义为第0个全局变量
+func compile(filename, src string) (*compiler.Compiler, error) {
+	p := parser.New(lexer.NewWithFilename(filename, src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(strings.TrimSuffix(parser.RenderDiagnostics(p.Diagnostics(), src), "\n"))
+	}
+	//宏展开时执行的puts和readline也不能使用被协议占用的标准输入输出
+	macroEnv := object.NewEnvironment()
+	macroEnv.SetIO(object.IO{})
+	program, err := evaluator.DefineAndExpandMacros(program, macroEnv)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	symbols := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbols.DefineBuiltin(i, v.Name)
+	}
+	symbols.Define("args")
+	comp := compiler.NewWithState(symbols, []object.Object{}, compiler.WithOptimization(compiler.OptNone))
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+	return comp, nil
+}
+
+// builtins 返回标准内置函数表，标准输入输出已经被协议占用:
+// puts的输出改为output事件，readline总是返回null，和输入结束时一样
+func (s *Server) builtins() []*object.Builtin {
+	builtins := vm.DefaultBuiltins()
+	for i, def := range object.Builtins {
+		switch def.Name {
+		case "puts":
+			builtins[i] = &object.Builtin{Fn: func(ctx object.CallContext, args ...object.Object) object.Object {
+				var out strings.Builder
+				for _, arg := range args {
+					out.WriteString(arg.Inspect())
+					out.WriteByte('\n')
+				}
+				s.event("output", OutputEvent{Category: "stdout", Output: out.String()})
+				return nil
+			}}
+		case "readline":
+			builtins[i] = &object.Builtin{Fn: func(ctx object.CallContext, args ...object.Object) object.Object {
+				return nil
+			}}
+		}
+	}
+	return builtins
+}
+
+// codeLines 收集主程序和所有函数的行号表中出现的行
+func codeLines(bytecode *compiler.Bytecode) map[int]bool {
+	lines := make(map[int]bool)
+	add := func(table code.LineTable) {
+		for _, e := range table.Entries() {
+			if e.Line != 0 {
+				lines[e.Line] = true
+			}
+		}
+	}
+	add(bytecode.LineTable)
+	for _, c := range bytecode.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			add(fn.LineTable)
+		}
+	}
+	return lines
+}
+
+func (s *Server) setBreakpoints(args SetBreakpointsArguments) []Breakpoint {
+	path := filepath.Clean(args.Source.Path)
+	known := s.debugger != nil && path == s.program
+	if s.debugger != nil {
+		s.debugger.ClearFileBreakpoints(path)
+	}
+	breakpoints := make([]Breakpoint, len(args.Breakpoints))
+	for i, bp := range args.Breakpoints {
+		breakpoints[i] = Breakpoint{Line: bp.Line, Source: &Source{Name: filepath.Base(path), Path: path}}
+		switch {
+		case !known:
+			breakpoints[i].Message = "source is not part of the launched program"
+		case !s.lines[bp.Line]:
+			breakpoints[i].Message = "no code on this line"
+		case !s.launch.NoDebug:
+			breakpoints[i].Verified = true
+			s.debugger.SetBreakpoint(path, bp.Line)
+		}
+	}
+	return breakpoints
+}
+
+// setFunctionBreakpoints 用新的函数断点替换原来的全部函数断点
+func (s *Server) setFunctionBreakpoints(args SetFunctionBreakpointsArguments) []Breakpoint {
+	breakpoints := make([]Breakpoint, len(args.Breakpoints))
+	if s.debugger == nil {
+		return breakpoints
+	}
+	for _, bp := range s.debugger.Breakpoints() {
+		if _, _, ok := debugger.ParseLocation(bp); !ok {
+			s.debugger.ClearFunctionBreakpoint(bp)
+		}
+	}
+	for i, bp := range args.Breakpoints {
+		if s.launch.NoDebug {
+			continue
+		}
+		s.debugger.SetFunctionBreakpoint(bp.Name)
+		breakpoints[i].Verified = true
+	}
+	return breakpoints
+}
+
+// start 在新的goroutine中运行脚本，结束后发出exited和terminated事件
+func (s *Server) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		err := s.debugger.VM().RunContext(s.ctx)
+		code := 0
+		var exitErr *vm.ExitError
+		var rtErr *vm.RuntimeError
+		switch {
+		case err == nil:
+		case errors.As(err, &exitErr):
+			code = exitErr.Code
+		case errors.Is(err, debugger.ErrQuit) || errors.Is(err, context.Canceled):
+			code = 1
+		case errors.As(err, &rtErr):
+			code = 1
+			s.event("output", OutputEvent{Category: "stderr", Output: rtErr.Traceback()})
+		default:
+			code = 1
+			s.event("output", OutputEvent{Category: "stderr", Output: err.Error() + "\n"})
+		}
+		s.event("exited", ExitedEvent{ExitCode: code})
+		s.event("terminated", nil)
+	}()
+}
+
+// terminate 停止正在运行或者暂停的脚本并等待它结束
+func (s *Server) terminate() {
+	if !s.started {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+// stopped 是调试器的Handler，在运行脚本的goroutine中调用。
+// 发出stopped事件后等待continue、next等请求，会话结束时退出脚本
+func (s *Server) stopped(d *debugger.Debugger, stop debugger.Stop) debugger.Action {
+	s.mu.Lock()
+	s.stop = &stop
+	s.refs = nil
+	s.mu.Unlock()
+
+	s.event("stopped", StoppedEvent{Reason: stop.Reason, ThreadID: threadID, AllThreadsStopped: true})
+	select {
+	case action := <-s.resume:
+		return action
+	case <-s.ctx.Done():
+		return debugger.Quit
+	}
+}
+
+// resumeWith 响应请求后让暂停的脚本按action继续执行，之前的帧和变量编号随之失效
+func (s *Server) resumeWith(req *Request, action debugger.Action, body any) error {
+	s.mu.Lock()
+	stopped := s.stop != nil
+	s.stop = nil
+	s.refs = nil
+	s.mu.Unlock()
+	if !stopped {
+		return errors.New("program is not stopped")
+	}
+	s.respond(req, body)
+	s.resume <- action
+	return errResponded
+}
+
+// paused 返回当前暂停时的调用栈，没有暂停时返回错误
+func (s *Server) paused() ([]*vm.Frame, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop == nil {
+		return nil, errors.New("program is not stopped")
+	}
+	return s.debugger.VM().Frames(), nil
+}
+
+// frame 按stackTrace返回的编号找到帧，编号是帧的深度加一
+func (s *Server) frame(id int) (*vm.Frame, error) {
+	frames, err := s.paused()
+	if err != nil {
+		return nil, err
+	}
+	if id < 1 || id > len(frames) {
+		return nil, fmt.Errorf("unknown frame %d", id)
+	}
+	return frames[id-1], nil
+}
+
+func (s *Server) stackTrace(args StackTraceArguments) (any, error) {
+	frames, err := s.paused()
+	if err != nil {
+		return nil, err
+	}
+	var stack []StackFrame
+	for i := len(frames) - 1; i >= 0; i-- {
+		pos := frames[i].SourcePosition()
+		frame := StackFrame{ID: i + 1, Name: debugger.FrameName(i, frames[i]), Line: pos.Line, Column: pos.Column}
+		if pos.Filename != "" {
+			frame.Source = &Source{Name: filepath.Base(pos.Filename), Path: pos.Filename}
+		}
+		stack = append(stack, frame)
+	}
+	total := len(stack)
+	if args.StartFrame > 0 && args.StartFrame <= len(stack) {
+		stack = stack[args.StartFrame:]
+	}
+	if args.Levels > 0 && args.Levels < len(stack) {
+		stack = stack[:args.Levels]
+	}
+	return map[string]any{"stackFrames": stack, "totalFrames": total}, nil
+}
+
+func (s *Server) scopes(args ScopesArguments) (any, error) {
+	f, err := s.frame(args.FrameID)
+	if err != nil {
+		return nil, err
+	}
+	machine := s.debugger.VM()
+	scopes := []Scope{{
+		Name:               "Locals",
+		PresentationHint:   "locals",
+		VariablesReference: s.reference(func() []vm.Variable { return machine.Locals(f) }),
+	}}
+	if len(machine.FreeVariables(f)) > 0 {
+		scopes = append(scopes, Scope{
+			Name:               "Closure",
+			VariablesReference: s.reference(func() []vm.Variable { return machine.FreeVariables(f) }),
+		})
+	}
+	scopes = append(scopes, Scope{
+		Name:               "Globals",
+		VariablesReference: s.reference(s.debugger.Globals),
+	})
+	return map[string]any{"scopes": scopes}, nil
+}
+
+// reference 登记一组可以展开的变量，返回它的variablesReference
+func (s *Server) reference(vars func() []vm.Variable) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appendRef(vars)
+}
+
+// appendRef 和reference相同，调用时已经持有s.mu
+func (s *Server) appendRef(vars func() []vm.Variable) int {
+	s.refs = append(s.refs, func() []Variable {
+		list := []Variable{}
+		for _, v := range vars() {
+			list = append(list, s.variable(v.Name, v.Value))
+		}
+		return list
+	})
+	return len(s.refs)
+}
+
+// variable 把一个值转换成协议中的变量，数组和hash可以继续展开。调用时已经持有s.mu
+func (s *Server) variable(name string, value object.Object) Variable {
+	if value == nil {
+		return Variable{Name: name, Value: "<unset>"}
+	}
+	v := Variable{Name: name, Value: value.Inspect(), Type: string(value.Type())}
+	switch value := value.(type) {
+	case *object.Array:
+		if len(value.Elements) > 0 {
+			v.VariablesReference = s.appendRef(func() []vm.Variable { return elements(value) })
+		}
+	case *object.Hash:
+		if len(value.Pairs) > 0 {
+			v.VariablesReference = s.appendRef(func() []vm.Variable { return pairs(value) })
+		}
+	}
+	return v
+}
+
+func elements(arr *object.Array) []vm.Variable {
+	vars := make([]vm.Variable, len(arr.Elements))
+	for i, el := range arr.Elements {
+		vars[i] = vm.Variable{Name: fmt.Sprintf("[%d]", i), Value: el}
+	}
+	return vars
+}
+
+// pairs 返回hash的键值对，按键的字面形式排序
+func pairs(hash *object.Hash) []vm.Variable {
+	var vars []vm.Variable
+	for _, pair := range hash.Pairs {
+		vars = append(vars, vm.Variable{Name: pair.Key.Inspect(), Value: pair.Value})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
+
+func (s *Server) variables(args VariablesArguments) (any, error) {
+	if _, err := s.paused(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if args.VariablesReference < 1 || args.VariablesReference > len(s.refs) {
+		return nil, fmt.Errorf("unknown variables reference %d", args.VariablesReference)
+	}
+	return map[string]any{"variables": s.refs[args.VariablesReference-1]()}, nil
+}
+
+// evaluate 只支持变量名，在frameId对应的帧中依次查找局部变量、自由变量和全局变量
+func (s *Server) evaluate(args EvaluateArguments) (any, error) {
+	id := args.FrameID
+	if id == 0 {
+		frames, err := s.paused()
+		if err != nil {
+			return nil, err
+		}
+		id = len(frames)
+	}
+	f, err := s.frame(id)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(args.Expression)
+	value, ok := s.debugger.Lookup(f, name)
+	if !ok {
+		return nil, fmt.Errorf("%s is not defined here", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.variable(name, value)
+	return map[string]any{"result": v.Value, "type": v.Type, "variablesReference": v.VariablesReference}, nil
+}
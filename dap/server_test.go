@@ -0,0 +1,424 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProgram = `let counter = 0;
+let add = fn(a, b) {
+  let sum = a + b;
+  counter = counter + 1;
+  sum
+};
+
+let twice = fn(x) {
+  let y = add(x, x);
+  [y, add(y, 1)]
+};
+let result = twice(5);
+puts(result);`
+
+// message 是客户端收到的响应或事件
+type message struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// client 是测试用的调试器前端，按顺序发请求、读消息
+type client struct {
+	t        *testing.T
+	w        io.WriteCloser
+	r        *bufio.Reader
+	seq      int
+	messages chan message
+	done     chan error
+}
+
+func newClient(t *testing.T) *client {
+	t.Helper()
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	c := &client{t: t, w: clientW, r: bufio.NewReader(clientR), messages: make(chan message, 100), done: make(chan error, 1)}
+	go func() {
+		err := NewServer(serverR, serverW).Serve()
+		serverW.Close()
+		c.done <- err
+	}()
+	go func() {
+		defer close(c.messages)
+		for {
+			data, err := ReadMessage(c.r)
+			if err != nil {
+				return
+			}
+			var msg message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Errorf("bad message %s: %s", data, err)
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+	return c
+}
+
+func (c *client) send(command string, args any) {
+	c.t.Helper()
+	c.seq++
+	req := map[string]any{"seq": c.seq, "type": "request", "command": command}
+	if args != nil {
+		req["arguments"] = args
+	}
+	if err := WriteMessage(c.w, req); err != nil {
+		c.t.Fatalf("send %s: %s", command, err)
+	}
+}
+
+func (c *client) next() message {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatalf("connection closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timed out waiting for a message")
+	}
+	return message{}
+}
+
+// request 发送请求并返回它的响应，期间收到的事件必须和events一致
+func (c *client) request(command string, args any, body any, events ...string) message {
+	c.t.Helper()
+	c.send(command, args)
+	resp := c.expect("response", command)
+	if !resp.Success {
+		c.t.Fatalf("%s failed: %s", command, resp.Message)
+	}
+	if body != nil {
+		if err := json.Unmarshal(resp.Body, body); err != nil {
+			c.t.Fatalf("bad %s body %s: %s", command, resp.Body, err)
+		}
+	}
+	for _, event := range events {
+		c.expect("event", event)
+	}
+	return resp
+}
+
+// expect 读取下一条消息，它必须是指定的响应或事件
+func (c *client) expect(typ, name string) message {
+	c.t.Helper()
+	msg := c.next()
+	got := msg.Command
+	if msg.Type == "event" {
+		got = msg.Event
+	}
+	if msg.Type != typ || got != name {
+		c.t.Fatalf("expected %s %s, got %s %s %s", typ, name, msg.Type, got, msg.Body)
+	}
+	return msg
+}
+
+// stopped 等待下一个stopped事件并检查原因
+func (c *client) stopped(reason string) {
+	c.t.Helper()
+	msg := c.expect("event", "stopped")
+	var body StoppedEvent
+	json.Unmarshal(msg.Body, &body)
+	if body.Reason != reason || body.ThreadID != threadID {
+		c.t.Fatalf("expected stop for %s, got %s", reason, msg.Body)
+	}
+}
+
+func (c *client) stackTrace() []StackFrame {
+	c.t.Helper()
+	var body struct {
+		StackFrames []StackFrame `json:"stackFrames"`
+	}
+	c.request("stackTrace", StackTraceArguments{ThreadID: threadID}, &body)
+	return body.StackFrames
+}
+
+// variables 以name=value的形式返回变量
+func (c *client) variables(ref int) string {
+	c.t.Helper()
+	var body struct {
+		Variables []Variable `json:"variables"`
+	}
+	c.request("variables", VariablesArguments{VariablesReference: ref}, &body)
+	var parts []string
+	for _, v := range body.Variables {
+		parts = append(parts, v.Name+"="+v.Value)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (c *client) scopes(frameID int) []Scope {
+	c.t.Helper()
+	var body struct {
+		Scopes []Scope `json:"scopes"`
+	}
+	c.request("scopes", ScopesArguments{FrameID: frameID}, &body)
+	return body.Scopes
+}
+
+// close 断开连接，脚本还没结束时先收到exited和terminated
+func (c *client) close() {
+	c.t.Helper()
+	c.send("disconnect", nil)
+	for msg := c.next(); msg.Type != "response"; msg = c.next() {
+		if msg.Event != "exited" && msg.Event != "terminated" {
+			c.t.Fatalf("unexpected event %s before disconnect response", msg.Event)
+		}
+	}
+	c.w.Close()
+	if err := <-c.done; err != nil {
+		c.t.Fatalf("serve error: %s", err)
+	}
+}
+
+func writeProgram(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.mk")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// launch 初始化会话并加载程序，返回initialize的响应
+func (c *client) launch(args LaunchArguments) Capabilities {
+	c.t.Helper()
+	var caps Capabilities
+	c.request("initialize", map[string]any{"adapterID": "monkey"}, &caps)
+	c.request("launch", args, nil, "initialized")
+	return caps
+}
+
+func TestBreakpointsAndVariables(t *testing.T) {
+	path := writeProgram(t, testProgram)
+	c := newClient(t)
+	caps := c.launch(LaunchArguments{Program: path})
+	if !caps.SupportsConfigurationDoneRequest || !caps.SupportsFunctionBreakpoints {
+		t.Errorf("wrong capabilities: %+v", caps)
+	}
+
+	var bps struct {
+		Breakpoints []Breakpoint `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", SetBreakpointsArguments{
+		Source:      Source{Path: path},
+		Breakpoints: []SourceBreakpoint{{Line: 4}, {Line: 7}},
+	}, &bps)
+	if len(bps.Breakpoints) != 2 || !bps.Breakpoints[0].Verified || bps.Breakpoints[1].Verified {
+		t.Errorf("line 4 should be verified and the empty line 7 not, got=%+v", bps.Breakpoints)
+	}
+	c.request("setBreakpoints", SetBreakpointsArguments{
+		Source:      Source{Path: filepath.Join(filepath.Dir(path), "other.mk")},
+		Breakpoints: []SourceBreakpoint{{Line: 1}},
+	}, &bps)
+	if bps.Breakpoints[0].Verified {
+		t.Errorf("breakpoint in another file should not be verified")
+	}
+	c.request("setExceptionBreakpoints", map[string]any{"filters": []string{}}, nil)
+	c.request("configurationDone", nil, nil)
+	c.stopped("breakpoint")
+
+	var threads struct {
+		Threads []Thread `json:"threads"`
+	}
+	c.request("threads", nil, &threads)
+	if len(threads.Threads) != 1 || threads.Threads[0].ID != threadID {
+		t.Errorf("wrong threads: %+v", threads.Threads)
+	}
+
+	frames := c.stackTrace()
+	var trace []string
+	for _, f := range frames {
+		trace = append(trace, fmt.Sprintf("%s:%d", f.Name, f.Line))
+		if f.Source == nil || f.Source.Path != path {
+			t.Errorf("frame %s has wrong source %+v", f.Name, f.Source)
+		}
+	}
+	if got := strings.Join(trace, " "); got != "add:4 twice:9 <main>:12" {
+		t.Errorf("wrong stack trace: %s", got)
+	}
+
+	scopes := c.scopes(frames[0].ID)
+	if len(scopes) != 2 || scopes[0].Name != "Locals" || scopes[1].Name != "Globals" {
+		t.Fatalf("wrong scopes: %+v", scopes)
+	}
+	if got := c.variables(scopes[0].VariablesReference); got != "a=5 b=5 sum=10" {
+		t.Errorf("wrong locals: %s", got)
+	}
+	if got := c.variables(scopes[1].VariablesReference); !strings.HasPrefix(got, "add=Closure[") || !strings.Contains(got, "args=[] counter=0") {
+		t.Errorf("wrong globals: %s", got)
+	}
+	scopes = c.scopes(frames[1].ID)
+	if got := c.variables(scopes[0].VariablesReference); got != "x=5 y=<unset>" {
+		t.Errorf("wrong locals of the caller: %s", got)
+	}
+
+	var eval struct {
+		Result string `json:"result"`
+	}
+	c.request("evaluate", EvaluateArguments{Expression: "counter", FrameID: frames[0].ID}, &eval)
+	if eval.Result != "0" {
+		t.Errorf("wrong evaluate result: %s", eval.Result)
+	}
+	c.send("evaluate", EvaluateArguments{Expression: "nope", FrameID: frames[0].ID})
+	if resp := c.expect("response", "evaluate"); resp.Success || resp.Message != "nope is not defined here" {
+		t.Errorf("evaluate of an undefined name should fail, got %+v", resp)
+	}
+
+	c.request("next", nil, nil)
+	c.stopped("step")
+	if frames := c.stackTrace(); frames[0].Line != 5 {
+		t.Errorf("next should stop at line 5, got %d", frames[0].Line)
+	}
+
+	c.request("continue", nil, nil)
+	c.stopped("breakpoint")
+	c.request("setBreakpoints", SetBreakpointsArguments{Source: Source{Path: path}}, nil)
+	c.request("continue", nil, nil)
+	output := c.expect("event", "output")
+	var out OutputEvent
+	json.Unmarshal(output.Body, &out)
+	if out.Category != "stdout" || out.Output != "[10, 11]\n" {
+		t.Errorf("wrong output event: %+v", out)
+	}
+	exited := c.expect("event", "exited")
+	if string(exited.Body) != `{"exitCode":0}` {
+		t.Errorf("wrong exited event: %s", exited.Body)
+	}
+	c.expect("event", "terminated")
+	c.close()
+}
+
+func TestStepping(t *testing.T) {
+	path := writeProgram(t, testProgram)
+	c := newClient(t)
+	c.launch(LaunchArguments{Program: path, StopOnEntry: true})
+	c.request("setFunctionBreakpoints", SetFunctionBreakpointsArguments{Breakpoints: []FunctionBreakpoint{{Name: "twice"}}}, nil)
+	c.request("configurationDone", nil, nil)
+	c.stopped("entry")
+
+	c.request("continue", nil, nil)
+	c.stopped("function breakpoint")
+	c.request("stepIn", nil, nil)
+	c.stopped("step")
+	if frames := c.stackTrace(); frames[0].Name != "add" || frames[0].Line != 3 {
+		t.Errorf("stepIn should stop in add at line 3, got %+v", frames[0])
+	}
+	c.request("stepOut", nil, nil)
+	c.stopped("step")
+	frames := c.stackTrace()
+	if frames[0].Name != "twice" || frames[0].Line != 9 {
+		t.Errorf("stepOut should return to twice, got %+v", frames[0])
+	}
+
+	c.request("next", nil, nil)
+	c.stopped("step")
+	c.request("stepOut", nil, nil)
+	c.stopped("step")
+	frames = c.stackTrace()
+	if len(frames) != 1 || frames[0].Line != 12 {
+		t.Fatalf("stepOut should return to main, got %+v", frames)
+	}
+	c.request("next", nil, nil)
+	c.stopped("step")
+	var eval struct {
+		Result             string `json:"result"`
+		VariablesReference int    `json:"variablesReference"`
+	}
+	c.request("evaluate", EvaluateArguments{Expression: "result"}, &eval)
+	if eval.Result != "[10, 11]" || eval.VariablesReference == 0 {
+		t.Fatalf("wrong evaluate result: %+v", eval)
+	}
+	if got := c.variables(eval.VariablesReference); got != "[0]=10 [1]=11" {
+		t.Errorf("wrong array elements: %s", got)
+	}
+	c.close()
+}
+
+// TestStandardIO 标准输入输出被协议占用，脚本和宏中的puts、readline都不能使用它们
+func TestStandardIO(t *testing.T) {
+	path := writeProgram(t, `let m = macro() { puts(readline()); quote(1) };
+let one = m();
+puts(readline(), one);`)
+	c := newClient(t)
+	c.launch(LaunchArguments{Program: path})
+	c.request("configurationDone", nil, nil)
+	output := c.expect("event", "output")
+	var out OutputEvent
+	json.Unmarshal(output.Body, &out)
+	if out.Category != "stdout" || out.Output != "null\n1\n" {
+		t.Errorf("wrong output event: %+v", out)
+	}
+	c.expect("event", "exited")
+	c.expect("event", "terminated")
+	c.close()
+}
+
+func TestLaunchErrors(t *testing.T) {
+	path := writeProgram(t, "let x = ;")
+	c := newClient(t)
+	c.request("initialize", nil, nil)
+	c.send("launch", LaunchArguments{Program: path})
+	resp := c.expect("response", "launch")
+	if resp.Success || !strings.Contains(resp.Message, "no prefix parse function") {
+		t.Errorf("launch of a bad program should fail, got %+v", resp)
+	}
+	c.send("stackTrace", StackTraceArguments{ThreadID: threadID})
+	if resp := c.expect("response", "stackTrace"); resp.Success {
+		t.Errorf("stackTrace without a stopped program should fail")
+	}
+	c.close()
+}
+
+func TestRuntimeErrorAndTerminate(t *testing.T) {
+	path := writeProgram(t, "let f = fn() { 1 + true };\nf();")
+	c := newClient(t)
+	c.launch(LaunchArguments{Program: path})
+	c.request("configurationDone", nil, nil)
+	output := c.expect("event", "output")
+	var out OutputEvent
+	json.Unmarshal(output.Body, &out)
+	if out.Category != "stderr" || !strings.Contains(out.Output, "unsupported types") {
+		t.Errorf("wrong output event: %+v", out)
+	}
+	c.expect("event", "exited")
+	c.expect("event", "terminated")
+	c.close()
+
+	// 暂停时断开连接会结束脚本
+	path = writeProgram(t, "let loop = fn(n) { loop(n + 1) };\nloop(0);")
+	c = newClient(t)
+	c.launch(LaunchArguments{Program: path, StopOnEntry: true})
+	c.request("configurationDone", nil, nil)
+	c.stopped("entry")
+	c.send("disconnect", nil)
+	c.expect("event", "exited")
+	c.expect("event", "terminated")
+	c.expect("response", "disconnect")
+	c.w.Close()
+	if err := <-c.done; err != nil {
+		t.Fatalf("serve error: %s", err)
+	}
+}
@@ -0,0 +1,240 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"myinterpreter/object"
+	"myinterpreter/vm"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const consoleHelp = `commands:
+  break [LINE | FILE:LINE | FUNC]  set a breakpoint, list breakpoints without arguments
+  clear LINE | FILE:LINE | FUNC    remove a breakpoint
+  continue, c                      run until the next breakpoint
+  step, s                          step to the next line, entering calls
+  next, n                          step to the next line in this function
+  out, o                           run until the current function returns
+  backtrace, bt                    show the call stack
+  frame N                          select frame N of the backtrace for inspection
+  locals                           show local variables of the selected frame
+  free                             show free variables of the selected frame
+  globals                          show global variables
+  stack                            show the operand stack of the selected frame
+  print NAME, p NAME               show a variable
+  list                             show the source around the current line
+  quit, q                          stop the program
+an empty line repeats the previous command
+`
+
+// Console 是命令行调试界面，把Stop方法作为Handler传给New。
+// 每次暂停时打印当前位置，然后读取命令直到遇到继续执行的命令
+type Console struct {
+	in      *bufio.Scanner
+	out     io.Writer
+	sources map[string][]string
+	prompt  string
+	last    string
+
+	selected int //frame命令选中的帧在调用栈中的下标
+}
+
+func NewConsole(in io.Reader, out io.Writer) *Console {
+	return &Console{
+		in:      bufio.NewScanner(in),
+		out:     out,
+		sources: make(map[string][]string),
+		prompt:  "(mdb) ",
+	}
+}
+
+// AddSource 提供filename的源码，用于显示当前行。没有提供的文件从磁盘读取
+func (c *Console) AddSource(filename, src string) {
+	c.sources[filename] = strings.Split(src, "\n")
+}
+
+func (c *Console) Stop(d *Debugger, stop Stop) Action {
+	c.selected = stop.Depth
+	fmt.Fprintf(c.out, "stopped at %s in %s (%s)\n", stop.Pos, FrameName(stop.Depth, stop.Frame), stop.Reason)
+	c.printLine(stop.Pos.Filename, stop.Pos.Line, true)
+
+	for {
+		fmt.Fprint(c.out, c.prompt)
+		if !c.in.Scan() {
+			fmt.Fprintln(c.out)
+			return Quit
+		}
+		line := strings.TrimSpace(c.in.Text())
+		if line == "" {
+			line = c.last
+		}
+		c.last = line
+		if action, resume := c.command(d, line); resume {
+			return action
+		}
+	}
+}
+
+// command 执行一条命令，继续执行的命令返回resume为true
+func (c *Console) command(d *Debugger, line string) (action Action, resume bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	cmd, args := fields[0], fields[1:]
+	machine := d.VM()
+	frames := machine.Frames()
+	frame := frames[c.selected]
+
+	switch cmd {
+	case "continue", "c":
+		return Continue, true
+	case "step", "s":
+		return StepInto, true
+	case "next", "n":
+		return StepOver, true
+	case "out", "o":
+		return StepOut, true
+	case "quit", "q":
+		return Quit, true
+	case "break", "b":
+		if len(args) == 0 {
+			for _, bp := range d.Breakpoints() {
+				fmt.Fprintln(c.out, bp)
+			}
+			return 0, false
+		}
+		c.breakpoint(d, args[0], true)
+	case "clear":
+		if len(args) == 0 {
+			fmt.Fprintln(c.out, "usage: clear LINE | FILE:LINE | FUNC")
+			return 0, false
+		}
+		c.breakpoint(d, args[0], false)
+	case "backtrace", "bt":
+		for i := len(frames) - 1; i >= 0; i-- {
+			marker := " "
+			if i == c.selected {
+				marker = "*"
+			}
+			fmt.Fprintf(c.out, "%s #%d %s (%s)\n", marker, i, FrameName(i, frames[i]), frames[i].SourcePosition())
+		}
+	case "frame":
+		n, err := strconv.Atoi(strings.Join(args, ""))
+		if err != nil || n < 0 || n >= len(frames) {
+			fmt.Fprintf(c.out, "frame must be between 0 and %d\n", len(frames)-1)
+			return 0, false
+		}
+		c.selected = n
+		pos := frames[n].SourcePosition()
+		fmt.Fprintf(c.out, "#%d %s (%s)\n", n, FrameName(n, frames[n]), pos)
+		c.printLine(pos.Filename, pos.Line, true)
+	case "locals":
+		c.printVariables(machine.Locals(frame))
+	case "free":
+		c.printVariables(machine.FreeVariables(frame))
+	case "globals":
+		c.printVariables(d.Globals())
+	case "stack":
+		stack := machine.OperandStack(frame)
+		if len(stack) == 0 {
+			fmt.Fprintln(c.out, "(empty)")
+		}
+		for i := len(stack) - 1; i >= 0; i-- {
+			fmt.Fprintf(c.out, "[%d] %s\n", i, inspect(stack[i]))
+		}
+	case "print", "p":
+		if len(args) != 1 {
+			fmt.Fprintln(c.out, "usage: print NAME")
+			return 0, false
+		}
+		value, ok := d.Lookup(frame, args[0])
+		if !ok {
+			fmt.Fprintf(c.out, "%s is not defined here\n", args[0])
+			return 0, false
+		}
+		fmt.Fprintf(c.out, "%s = %s\n", args[0], inspect(value))
+	case "list", "l":
+		pos := frames[c.selected].SourcePosition()
+		for n := pos.Line - 3; n <= pos.Line+3; n++ {
+			c.printLine(pos.Filename, n, n == pos.Line)
+		}
+	case "help", "h":
+		fmt.Fprint(c.out, consoleHelp)
+	default:
+		fmt.Fprintf(c.out, "unknown command %q, type help for a list of commands\n", cmd)
+	}
+	return 0, false
+}
+
+// breakpoint 设置或删除断点，数字表示行号，FILE:LINE表示文件中的行，其他的表示函数名
+func (c *Console) breakpoint(d *Debugger, spec string, set bool) {
+	file, line, isLine := ParseLocation(spec)
+	switch {
+	case isLine && set:
+		d.SetBreakpoint(file, line)
+		fmt.Fprintf(c.out, "breakpoint set at %s\n", spec)
+	case isLine:
+		if !d.ClearBreakpoint(file, line) {
+			fmt.Fprintf(c.out, "no breakpoint at %s\n", spec)
+		}
+	case set:
+		d.SetFunctionBreakpoint(spec)
+		fmt.Fprintf(c.out, "breakpoint set at function %s\n", spec)
+	default:
+		if !d.ClearFunctionBreakpoint(spec) {
+			fmt.Fprintf(c.out, "no breakpoint at function %s\n", spec)
+		}
+	}
+}
+
+// ParseLocation 解析LINE或FILE:LINE形式的断点位置，不是行号时ok为false
+func ParseLocation(spec string) (file string, line int, ok bool) {
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		file, spec = spec[:i], spec[i+1:]
+	}
+	line, err := strconv.Atoi(spec)
+	if err != nil || line <= 0 {
+		return "", 0, false
+	}
+	return file, line, true
+}
+
+func (c *Console) printVariables(vars []vm.Variable) {
+	if len(vars) == 0 {
+		fmt.Fprintln(c.out, "(none)")
+	}
+	for _, v := range vars {
+		fmt.Fprintf(c.out, "%s = %s\n", v.Name, inspect(v.Value))
+	}
+}
+
+// printLine 打印源码中的一行，current为true时在行首标记箭头
+func (c *Console) printLine(filename string, n int, current bool) {
+	lines, ok := c.sources[filename]
+	if !ok {
+		data, err := os.ReadFile(filename)
+		if err == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+		c.sources[filename] = lines
+	}
+	if n < 1 || n > len(lines) {
+		return
+	}
+	marker := "  "
+	if current {
+		marker = "=>"
+	}
+	fmt.Fprintf(c.out, "%s %4d | %s\n", marker, n, strings.TrimRight(lines[n-1], "\r"))
+}
+
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "<unset>"
+	}
+	return obj.Inspect()
+}
@@ -0,0 +1,297 @@
+// Package debugger 在虚拟机的指令钩子上实现断点和单步执行
+package debugger
+
+import (
+	"errors"
+	"myinterpreter/object"
+	"myinterpreter/token"
+	"myinterpreter/vm"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Action 是暂停之后继续执行的方式
+type Action int
+
+const (
+	Continue Action = iota // 运行到下一个断点
+	StepInto               // 运行到下一行，会进入被调用的函数
+	StepOver               // 运行到当前函数或者调用者的下一行
+	StepOut                // 运行到当前函数返回之后，停在调用者中还没执行完的那一行
+	Quit                   // 停止执行，Run返回ErrQuit
+)
+
+// ErrQuit 是在调试器中退出时Run返回的运行时错误包装的错误
+var ErrQuit = errors.New("debugger: quit")
+
+// 暂停的原因
+const (
+	ReasonEntry      = "entry"
+	ReasonStep       = "step"
+	ReasonBreakpoint = "breakpoint"
+	ReasonFunction   = "function breakpoint"
+	ReasonPause      = "pause"
+)
+
+// Stop 描述一次暂停。Depth是当前帧的调用深度，main为0
+type Stop struct {
+	Reason string
+	Pos    token.Position
+	Frame  *vm.Frame
+	Depth  int
+}
+
+// Handler 在程序暂停时被调用，返回之前程序一直保持暂停，可以通过Debugger查看变量。
+// Handler在运行虚拟机的goroutine中调用
+type Handler func(d *Debugger, stop Stop) Action
+
+type lineBreakpoint struct {
+	file string //为空时匹配任何文件
+	line int
+}
+
+// lineMark 记录某一层调用上一条指令所在的行，用来判断是否到了新的一行
+type lineMark struct {
+	frame *vm.Frame
+	line  int
+}
+
+// Debugger 的断点方法和Pause可以在其他goroutine中调用，其余方法只能在程序暂停时调用
+type Debugger struct {
+	vm      *vm.VM
+	globals map[int]string
+	handler Handler
+
+	mu        sync.Mutex //保护lines和functions
+	lines     map[lineBreakpoint]bool
+	functions map[string]bool
+	pause     atomic.Bool
+
+	action Action
+	depth  int //开始单步时的调用深度
+	entry  bool
+	marks  []lineMark
+}
+
+// New 在machine上安装调试钩子。globals是全局变量槽位到名字的映射，
+// 一般来自编译器符号表的GlobalNames
+func New(machine *vm.VM, globals map[int]string, handler Handler) *Debugger {
+	d := &Debugger{
+		vm:        machine,
+		globals:   globals,
+		handler:   handler,
+		lines:     make(map[lineBreakpoint]bool),
+		functions: make(map[string]bool),
+	}
+	machine.SetHook(d.hook)
+	return d
+}
+
+// VM 返回被调试的虚拟机
+func (d *Debugger) VM() *vm.VM {
+	return d.vm
+}
+
+// StopOnEntry 设置是否在执行第一行之前暂停
+func (d *Debugger) StopOnEntry(stop bool) {
+	d.entry = stop
+	if stop {
+		d.action = StepInto
+	} else {
+		d.action = Continue
+	}
+}
+
+// SetBreakpoint 在file的第line行设置断点，file为空时匹配任何文件
+func (d *Debugger) SetBreakpoint(file string, line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lines[lineBreakpoint{cleanPath(file), line}] = true
+}
+
+// ClearBreakpoint 删除SetBreakpoint设置的断点，断点不存在时返回false
+func (d *Debugger) ClearBreakpoint(file string, line int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := lineBreakpoint{cleanPath(file), line}
+	ok := d.lines[key]
+	delete(d.lines, key)
+	return ok
+}
+
+// ClearFileBreakpoints 删除file中的全部行断点
+func (d *Debugger) ClearFileBreakpoints(file string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	file = cleanPath(file)
+	for bp := range d.lines {
+		if bp.file == file {
+			delete(d.lines, bp)
+		}
+	}
+}
+
+// SetFunctionBreakpoint 在进入名为name的函数时暂停，函数名是let绑定的名字
+func (d *Debugger) SetFunctionBreakpoint(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.functions[name] = true
+}
+
+func (d *Debugger) ClearFunctionBreakpoint(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ok := d.functions[name]
+	delete(d.functions, name)
+	return ok
+}
+
+// Breakpoints 返回全部断点的描述，行断点为file:line或line，函数断点为函数名
+func (d *Debugger) Breakpoints() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var list []string
+	for bp := range d.lines {
+		if bp.file == "" {
+			list = append(list, strconv.Itoa(bp.line))
+		} else {
+			list = append(list, bp.file+":"+strconv.Itoa(bp.line))
+		}
+	}
+	for name := range d.functions {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Pause 让正在运行的程序在执行下一条有源码位置的指令之前暂停
+func (d *Debugger) Pause() {
+	d.pause.Store(true)
+}
+
+func cleanPath(file string) string {
+	if file == "" {
+		return ""
+	}
+	return filepath.Clean(file)
+}
+
+func (d *Debugger) hook(machine *vm.VM) error {
+	frames := machine.Frames()
+	depth := len(frames) - 1
+	frame := frames[depth]
+	pos := frame.SourcePosition()
+	newLine := d.mark(depth, frame, pos.Line)
+
+	reason := ""
+	switch {
+	case pos.Line != 0 && d.pause.Swap(false):
+		reason = ReasonPause
+	case depth > 0 && frame.IP() == 0 && d.hasFunctionBreakpoint(frame.Function().Name):
+		reason = ReasonFunction
+	case newLine && d.hasBreakpoint(pos):
+		reason = ReasonBreakpoint
+	case (newLine || d.action == StepOut) && pos.Line != 0 && d.stepDone(depth):
+		reason = ReasonStep
+		if d.entry {
+			reason = ReasonEntry
+		}
+	}
+	if reason == "" {
+		return nil
+	}
+
+	d.entry = false
+	d.action = d.handler(d, Stop{Reason: reason, Pos: pos, Frame: frame, Depth: depth})
+	d.depth = depth
+	if d.action == Quit {
+		return ErrQuit
+	}
+	return nil
+}
+
+// mark 记录这一层调用当前所在的行，到了新的一行时返回true。
+// 从被调用的函数返回到调用者的同一行不算新的一行
+func (d *Debugger) mark(depth int, frame *vm.Frame, line int) bool {
+	for len(d.marks) <= depth {
+		d.marks = append(d.marks, lineMark{})
+	}
+	d.marks = d.marks[:depth+1]
+	m := &d.marks[depth]
+	changed := m.frame != frame || m.line != line
+	m.frame, m.line = frame, line
+	return changed && line != 0
+}
+
+func (d *Debugger) hasFunctionBreakpoint(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.functions[name]
+}
+
+func (d *Debugger) hasBreakpoint(pos token.Position) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lines[lineBreakpoint{"", pos.Line}] || d.lines[lineBreakpoint{cleanPath(pos.Filename), pos.Line}]
+}
+
+func (d *Debugger) stepDone(depth int) bool {
+	switch d.action {
+	case StepInto:
+		return true
+	case StepOver:
+		return depth <= d.depth
+	case StepOut:
+		return depth < d.depth
+	}
+	return false
+}
+
+// FrameName 返回调用栈中第i帧的函数名，main帧为<main>
+func FrameName(i int, f *vm.Frame) string {
+	if i == 0 {
+		return "<main>"
+	}
+	return f.FunctionName()
+}
+
+// Globals 返回已经赋值的全局变量，按名字排序，不包括编译器生成的$开头的变量
+func (d *Debugger) Globals() []vm.Variable {
+	var vars []vm.Variable
+	for index, name := range d.globals {
+		if strings.HasPrefix(name, "$") {
+			continue
+		}
+		if value := d.vm.Global(index); value != nil {
+			vars = append(vars, vm.Variable{Name: name, Value: value})
+		}
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
+
+// Lookup 在帧f中按名字查找变量，依次查找局部变量、自由变量和全局变量
+func (d *Debugger) Lookup(f *vm.Frame, name string) (object.Object, bool) {
+	for _, v := range d.vm.Locals(f) {
+		if v.Name == name {
+			return v.Value, v.Value != nil
+		}
+	}
+	for _, v := range d.vm.FreeVariables(f) {
+		if v.Name == name {
+			return v.Value, true
+		}
+	}
+	for index, n := range d.globals {
+		if n == name {
+			value := d.vm.Global(index)
+			return value, value != nil
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,231 @@
+package debugger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"myinterpreter/compiler"
+	"myinterpreter/lexer"
+	"myinterpreter/parser"
+	"myinterpreter/vm"
+	"strings"
+	"testing"
+)
+
+const testProgram = `let counter = 0;
+let add = fn(a, b) {
+  let sum = a + b;
+  counter = counter + 1;
+  sum
+};
+let twice = fn(x) {
+  let y = add(x, x);
+  add(y, 1)
+};
+let result = twice(5);
+result;`
+
+func newDebugger(t *testing.T, input string, handler Handler) *Debugger {
+	t.Helper()
+	p := parser.New(lexer.NewWithFilename("test.mk", input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return New(vm.New(comp.Bytecode()), comp.SymbolTable().GlobalNames(), handler)
+}
+
+// script 返回依次执行actions的Handler，并记录每次暂停的行号和函数名
+func script(stops *[]string, actions ...Action) Handler {
+	return func(d *Debugger, stop Stop) Action {
+		*stops = append(*stops, fmt.Sprintf("%s:%d %s", FrameName(stop.Depth, stop.Frame), stop.Pos.Line, stop.Reason))
+		if len(actions) == 0 {
+			return Continue
+		}
+		action := actions[0]
+		actions = actions[1:]
+		return action
+	}
+}
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		name     string
+		actions  []Action
+		expected []string
+	}{
+		{
+			"step into",
+			[]Action{StepInto, StepInto, StepInto, StepInto, StepInto, Continue},
+			[]string{"<main>:1 entry", "<main>:2 step", "<main>:7 step", "<main>:11 step", "twice:8 step", "add:3 step"},
+		},
+		{
+			"step over",
+			[]Action{StepOver, StepOver, StepOver, StepOver, Continue},
+			[]string{"<main>:1 entry", "<main>:2 step", "<main>:7 step", "<main>:11 step", "<main>:12 step"},
+		},
+		{
+			"step out",
+			[]Action{StepOver, StepOver, StepOver, StepInto, StepInto, StepOut, StepOver, Continue},
+			[]string{"<main>:1 entry", "<main>:2 step", "<main>:7 step", "<main>:11 step", "twice:8 step", "add:3 step", "twice:8 step", "twice:9 step"},
+		},
+	}
+
+	for _, ts := range tests {
+		var stops []string
+		d := newDebugger(t, testProgram, script(&stops, ts.actions...))
+		d.StopOnEntry(true)
+		if err := d.VM().Run(); err != nil {
+			t.Fatalf("%s: vm error: %s", ts.name, err)
+		}
+		if strings.Join(stops, ", ") != strings.Join(ts.expected, ", ") {
+			t.Errorf("%s: wrong stops.\nwant=%v\ngot =%v", ts.name, ts.expected, stops)
+		}
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	var stops []string
+	d := newDebugger(t, testProgram, script(&stops))
+	d.SetBreakpoint("test.mk", 4)
+	d.SetBreakpoint("other.mk", 9)
+	d.SetFunctionBreakpoint("twice")
+	if err := d.VM().Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	expected := []string{"twice:8 function breakpoint", "add:4 breakpoint", "add:4 breakpoint"}
+	if strings.Join(stops, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("wrong stops.\nwant=%v\ngot =%v", expected, stops)
+	}
+
+	if !d.ClearBreakpoint("./test.mk", 4) || d.ClearBreakpoint("test.mk", 4) {
+		t.Errorf("ClearBreakpoint should remove the breakpoint exactly once")
+	}
+	if got := strings.Join(d.Breakpoints(), " "); got != "other.mk:9 twice" {
+		t.Errorf("wrong breakpoints. got=%q", got)
+	}
+}
+
+func TestInspectVariables(t *testing.T) {
+	input := `let g = 10;
+let outer = fn(a) {
+  let b = a * 2;
+  let inner = fn() {
+    let c = a + b;
+    c + g
+  };
+  inner()
+};
+outer(1);`
+
+	var locals, free, globals, lookup string
+	d := newDebugger(t, input, func(d *Debugger, stop Stop) Action {
+		machine := d.VM()
+		locals = variables(machine.Locals(stop.Frame))
+		free = variables(machine.FreeVariables(stop.Frame))
+		globals = variables(d.Globals())
+		if v, ok := d.Lookup(stop.Frame, "b"); ok {
+			lookup = v.Inspect()
+		}
+		return Continue
+	})
+	d.SetBreakpoint("", 6)
+	if err := d.VM().Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if locals != "c=3" {
+		t.Errorf("wrong locals. got=%q", locals)
+	}
+	if free != "a=1 b=2" {
+		t.Errorf("wrong free variables. got=%q", free)
+	}
+	if !strings.HasPrefix(globals, "g=10 outer=") {
+		t.Errorf("wrong globals. got=%q", globals)
+	}
+	if lookup != "2" {
+		t.Errorf("wrong lookup result. got=%q", lookup)
+	}
+}
+
+func TestHiddenLoopVariables(t *testing.T) {
+	input := `for (x in [1]) {
+  fn(arr) {
+    for (y in arr) {
+      y * 2
+    }
+  }([2])
+}`
+
+	var locals, globals string
+	d := newDebugger(t, input, func(d *Debugger, stop Stop) Action {
+		locals = variables(d.VM().Locals(stop.Frame))
+		globals = variables(d.Globals())
+		return Continue
+	})
+	d.SetBreakpoint("", 4)
+	if err := d.VM().Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if locals != "arr=[2] y=2" {
+		t.Errorf("wrong locals. got=%q", locals)
+	}
+	if globals != "x=1" {
+		t.Errorf("wrong globals. got=%q", globals)
+	}
+}
+
+func variables(vars []vm.Variable) string {
+	var parts []string
+	for _, v := range vars {
+		value := "<unset>"
+		if v.Value != nil {
+			value = v.Value.Inspect()
+		}
+		parts = append(parts, v.Name+"="+value)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestQuit(t *testing.T) {
+	var stops []string
+	d := newDebugger(t, testProgram, script(&stops, StepOver, Quit))
+	d.StopOnEntry(true)
+	err := d.VM().Run()
+	if !errors.Is(err, ErrQuit) {
+		t.Fatalf("expected ErrQuit, got=%v", err)
+	}
+	if len(stops) != 2 {
+		t.Errorf("expected 2 stops, got=%v", stops)
+	}
+}
+
+func TestConsole(t *testing.T) {
+	var out bytes.Buffer
+	console := NewConsole(strings.NewReader("b add\nc\nlocals\nbt\np counter\nclear add\nc\n"), &out)
+	console.AddSource("test.mk", testProgram)
+	d := newDebugger(t, testProgram, console.Stop)
+	d.StopOnEntry(true)
+	if err := d.VM().Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	expected := []string{
+		"stopped at test.mk:1:15 in <main> (entry)",
+		"breakpoint set at function add",
+		"stopped at test.mk:3:13 in add (function breakpoint)",
+		"=>    3 |   let sum = a + b;",
+		"a = 5\nb = 5\nsum = <unset>",
+		"* #2 add (test.mk:3:13)\n  #1 twice (test.mk:8:11)\n  #0 <main> (test.mk:11:14)",
+		"counter = 0",
+	}
+	for _, want := range expected {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("console output does not contain %q.\ngot:\n%s", want, out.String())
+		}
+	}
+}
@@ -11,4 +11,15 @@ var builtins = map[string]*object.Builtin{
 	"rest":  object.GetBuiltinByName("rest"),
 	"push":  object.GetBuiltinByName("push"),
 	"puts":  object.GetBuiltinByName("puts"),
+	"exit":  object.GetBuiltinByName("exit"),
+
+	"readline": object.GetBuiltinByName("readline"),
+
+	"map":     object.GetBuiltinByName("map"),
+	"filter":  object.GetBuiltinByName("filter"),
+	"reduce":  object.GetBuiltinByName("reduce"),
+	"each":    object.GetBuiltinByName("each"),
+	"any":     object.GetBuiltinByName("any"),
+	"all":     object.GetBuiltinByName("all"),
+	"sort_by": object.GetBuiltinByName("sort_by"),
 }
@@ -1,18 +1,61 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"myinterpreter/ast"
 	"myinterpreter/object"
+	"strings"
 )
 
 var (
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
-	NULL  = &object.Null{}
+	TRUE     = &object.Boolean{Value: true}
+	FALSE    = &object.Boolean{Value: false}
+	NULL     = &object.Null{}
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
+// EvalContext 在ctx和limits的限制下求值。超出限制时停止求值并返回*object.LimitError，
+// ctx取消或超时时返回ctx.Err()，脚本中的错误仍然作为错误对象返回
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits object.Limits) (result object.Object, err error) {
+	previous := env.Budget()
+	env.SetBudget(object.NewBudget(ctx, limits))
+	defer func() {
+		env.SetBudget(previous)
+		if r := recover(); r != nil {
+			exceeded, ok := r.(budgetExceeded)
+			if !ok {
+				panic(r)
+			}
+			result, err = nil, exceeded.err
+		}
+	}()
+	return Eval(node, env), nil
+}
+
+// budgetExceeded 是超出限额时的panic值，由EvalContext恢复。
+// 求值是深度递归的，用panic可以不必在每一层检查
+type budgetExceeded struct {
+	err error
+}
+
+func charge(err error) {
+	if err != nil {
+		panic(budgetExceeded{err})
+	}
+}
+
+// allocated 把新建的对象计入限额，和虚拟机一样只计数组、hash、字符串和函数
+func allocated(env *object.Environment, obj object.Object) object.Object {
+	charge(env.Budget().Allocate(obj))
+	return obj
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	charge(env.Budget().Step())
+
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node, env)
@@ -35,6 +78,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		env.Set(node.Name.Value, val)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
@@ -44,6 +89,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -52,17 +100,27 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return allocated(env, evalInfixExpression(node.Operator, left, right))
 
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return allocated(env, &object.Function{Parameters: params, Body: body, Env: env})
 	case *ast.CallExpression:
 		if node.Function.TokenLiteral() == "quote" {
 			return quote(node.Arguments[0], env)
@@ -75,7 +133,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		if _, ok := function.(*object.Builtin); ok {
+			return allocated(env, applyFunction(function, args, env))
+		}
+		return applyFunction(function, args, env)
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.ArrayLiteral:
@@ -83,7 +144,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(elems) == 1 && isError(elems[0]) {
 			return elems[0]
 		}
-		return &object.Array{Elements: elems}
+		return allocated(env, &object.Array{Elements: elems})
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -121,7 +182,7 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 		hashed := hashKey.HashKey()
 		pairs[hashed] = object.HashPair{Key: key, Value: value}
 	}
-	return &object.Hash{Pairs: pairs}
+	return allocated(env, &object.Hash{Pairs: pairs})
 }
 
 func evalIndexExpression(left, idx object.Object) object.Object {
@@ -161,22 +222,49 @@ func evalArrayIndexExpression(array, idx object.Object) object.Object {
 	return arrayObj.Elements[id]
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction 调用函数，env是调用处的环境，内置函数从中取得输入输出
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+		budget := fn.Env.Budget()
+		charge(budget.Enter())
+		defer budget.Leave()
 		extendedEnv := extendFunctionEnv(fn, args)
 		evaluated := Eval(fn.Body, extendedEnv)
+		if isLoopControl(evaluated) {
+			return newError("%s outside loop", evaluated.Inspect())
+		}
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		if res := fn.Fn(args...); res != nil {
+		switch res := fn.Fn(callContext{env: env}, args...).(type) {
+		case nil:
+			return NULL
+		case *object.Boolean:
+			return nativeBoolToBooleanObject(res.Value)
+		default:
 			return res
 		}
-		return NULL
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// callContext 让内置函数通过applyFunction回调Monkey函数
+type callContext struct {
+	env *object.Environment
+}
+
+func (c callContext) Call(fn object.Object, args ...object.Object) object.Object {
+	return applyFunction(fn, args, c.env)
+}
+
+func (c callContext) IO() object.IO {
+	return c.env.IO()
+}
+
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
 	env := object.NewEnclosedEnvironment(fn.Env)
 
@@ -216,6 +304,97 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 	return newError("identifier not found: " + node.Value)
 }
 
+// evalAssignExpression 修改已有的绑定，复合赋值先读取旧值再计算右边
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	var ident *ast.Identifier
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		ident = target
+	case *ast.IndexExpression:
+		return evalIndexAssignment(target, node, env)
+	default:
+		return newError("invalid assignment target %s", node.Target.String())
+	}
+	var old object.Object
+	if node.Operator != "=" {
+		old = evalIdentifier(ident, env)
+		if isError(old) {
+			return old
+		}
+	}
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+	if old != nil {
+		val = evalInfixExpression(strings.TrimSuffix(node.Operator, "="), old, val)
+		if isError(val) {
+			return val
+		}
+	}
+	if _, ok := env.Assign(ident.Value, val); !ok {
+		if _, ok := builtins[ident.Value]; ok {
+			return newError("cannot assign to builtin %s", ident.Value)
+		}
+		return newError("identifier not found: " + ident.Value)
+	}
+	return val
+}
+
+// evalIndexAssignment 原地修改数组元素或hash中的值，集合和索引只求值一次
+func evalIndexAssignment(target *ast.IndexExpression, node *ast.AssignExpression, env *object.Environment) object.Object {
+	left := Eval(target.Left, env)
+	if isError(left) {
+		return left
+	}
+	index := Eval(target.Index, env)
+	if isError(index) {
+		return index
+	}
+	var old object.Object
+	if node.Operator != "=" {
+		old = evalIndexExpression(left, index)
+		if isError(old) {
+			return old
+		}
+	}
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+	if old != nil {
+		val = evalInfixExpression(strings.TrimSuffix(node.Operator, "="), old, val)
+		if isError(val) {
+			return val
+		}
+	}
+
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d (length %d)", i.Value, len(left.Elements))
+		}
+		left.Elements[i.Value] = val
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		hashKey := key.HashKey()
+		if _, exists := left.Pairs[hashKey]; !exists {
+			charge(env.Budget().AddHashEntry())
+		}
+		left.Pairs[hashKey] = object.HashPair{Key: index, Value: val}
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+	return val
+}
+
 func evalBlockStatements(bs *ast.BlockStatement, env *object.Environment) object.Object {
 	var res object.Object
 
@@ -223,7 +402,7 @@ func evalBlockStatements(bs *ast.BlockStatement, env *object.Environment) object
 		res = Eval(stmt, env)
 		if res != nil {
 			rs := res.Type()
-			if rs == object.RETURN_VALUE_OBJ || rs == object.ERROR_OBJ {
+			if rs == object.RETURN_VALUE_OBJ || isError(res) || isLoopControl(res) {
 				return res
 			}
 		}
@@ -239,13 +418,60 @@ func evalProgram(p *ast.Program, env *object.Environment) object.Object {
 		switch res := res.(type) {
 		case *object.ReturnValue:
 			return res.Value
-		case *object.Error:
+		case *object.Error, *object.Exit:
 			return res
+		case *object.Break, *object.Continue:
+			return newError("%s outside loop", res.Inspect())
 		}
 	}
 	return res
 }
 
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		cond := Eval(ws.Condition, env)
+		if isError(cond) {
+			return cond
+		}
+		if !isTruthy(cond) {
+			return nil
+		}
+		res := Eval(ws.Body, env)
+		if res == BREAK {
+			return nil
+		}
+		if res != nil && (res.Type() == object.RETURN_VALUE_OBJ || isError(res)) {
+			return res
+		}
+	}
+}
+
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	iter, ok := object.NewIterator(iterable)
+	if !ok {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+	for elem, ok := iter.Next(); ok; elem, ok = iter.Next() {
+		env.Set(fs.Variable.Value, elem)
+		res := Eval(fs.Body, env)
+		if res == BREAK {
+			return nil
+		}
+		if res != nil && (res.Type() == object.RETURN_VALUE_OBJ || isError(res)) {
+			return res
+		}
+	}
+	return nil
+}
+
+func isLoopControl(obj object.Object) bool {
+	return obj == BREAK || obj == CONTINUE
+}
+
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	cond := Eval(ie.Condition, env)
 	if isError(cond) {
@@ -272,11 +498,14 @@ func evalPrefixExpression(op string, right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(obj object.Object) object.Object {
-	if obj.Type() != object.INTEGER_OBJ {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -obj.Value}
+	case *object.Float:
+		return &object.Float{Value: -obj.Value}
+	default:
 		return newError("unknown operator: -%s", obj.Type())
 	}
-	value := obj.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func evalBangOperatorExpression(obj object.Object) object.Object {
@@ -303,6 +532,8 @@ func evalInfixExpression(op string, left, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(op, left, right)
+	case isNumber(left) && isNumber(right): //int和float混合运算时按float计算
+		return evalFloatInfixExpression(op, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(op, left, right)
 	case op == "==": //==和!=直接进行指针比较
@@ -316,13 +547,39 @@ func evalInfixExpression(op string, left, right object.Object) object.Object {
 	}
 }
 
-func evalStringInfixExpression(op string, left, right object.Object) object.Object {
-	if op != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
+// evalLogicalExpression 对&&和||短路求值，不需要时不会计算右侧表达式
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
 	}
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
+// evalStringInfixExpression 字符串支持拼接，==和!=按值比较
+func evalStringInfixExpression(op string, left, right object.Object) object.Object {
 	lValue := left.(*object.String).Value
 	rValue := right.(*object.String).Value
-	return &object.String{Value: lValue + rValue}
+	switch op {
+	case "+":
+		return &object.String{Value: lValue + rValue}
+	case "==":
+		return nativeBoolToBooleanObject(lValue == rValue)
+	case "!=":
+		return nativeBoolToBooleanObject(lValue != rValue)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
+	}
 }
 
 func evalIntegerInfixExpression(op string, left, right object.Object) object.Object {
@@ -337,11 +594,23 @@ func evalIntegerInfixExpression(op string, left, right object.Object) object.Obj
 	case "*":
 		return &object.Integer{Value: lValue * rValue}
 	case "/":
+		if rValue == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: lValue / rValue}
+	case "%":
+		if rValue == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: lValue % rValue}
 	case "<":
 		return nativeBoolToBooleanObject(lValue < rValue)
 	case ">":
 		return nativeBoolToBooleanObject(lValue > rValue)
+	case "<=":
+		return nativeBoolToBooleanObject(lValue <= rValue)
+	case ">=":
+		return nativeBoolToBooleanObject(lValue >= rValue)
 	case "==":
 		return nativeBoolToBooleanObject(lValue == rValue)
 	case "!=":
@@ -351,13 +620,70 @@ func evalIntegerInfixExpression(op string, left, right object.Object) object.Obj
 	}
 }
 
+func evalFloatInfixExpression(op string, left, right object.Object) object.Object {
+	lValue := toFloat(left)
+	rValue := toFloat(right)
+
+	switch op {
+	case "+":
+		return &object.Float{Value: lValue + rValue}
+	case "-":
+		return &object.Float{Value: lValue - rValue}
+	case "*":
+		return &object.Float{Value: lValue * rValue}
+	case "/":
+		return &object.Float{Value: lValue / rValue}
+	case "%":
+		return &object.Float{Value: math.Mod(lValue, rValue)}
+	case "<", ">", "<=", ">=", "==", "!=":
+		return evalNumberComparison(op, left, right)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), op, right.Type())
+	}
+}
+
+// evalNumberComparison 用CompareNumbers精确比较，大整数和浮点数比较时不会丢失精度
+func evalNumberComparison(op string, left, right object.Object) object.Object {
+	c, ok := object.CompareNumbers(left, right)
+	switch op {
+	case "<":
+		return nativeBoolToBooleanObject(ok && c < 0)
+	case ">":
+		return nativeBoolToBooleanObject(ok && c > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(ok && c <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(ok && c >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(ok && c == 0)
+	default:
+		return nativeBoolToBooleanObject(!ok || c != 0)
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
 func newError(format string, a ...any) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// isError 判断求值是否需要中断，exit和错误一样会一直传递到最外层
 func isError(obj object.Object) bool {
 	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+		return obj.Type() == object.ERROR_OBJ || obj.Type() == object.EXIT_OBJ
 	}
 	return false
 }
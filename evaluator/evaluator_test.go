@@ -1,10 +1,13 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"myinterpreter/lexer"
 	"myinterpreter/object"
 	"myinterpreter/parser"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -80,6 +83,14 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`let s = "ab"; s == "a" + "b"`, true},
+		{`"a" == "b"`, false},
+		{"9007199254740993 == 9007199254740992.0", false},
+		{"9007199254740993 > 9007199254740992.0", true},
+		{"9007199254740992.0 < 9007199254740993", true},
+		{"9007199254740992.0 <= 9007199254740992", true},
 	}
 
 	for _, ts := range tests {
@@ -516,6 +527,14 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			`{2: 5}[2.0]`,
+			5,
+		},
+		{
+			`{2.0: 5}[2]`,
+			5,
+		},
 	}
 
 	for _, tt := range tests {
@@ -528,3 +547,309 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"1e-3 * 1000", 1.0},
+		{"1 < 1.5", true},
+		{"2.0 == 2", true},
+		{"2.5 != 2.5", false},
+		{"2.5 > 3", false},
+	}
+
+	for _, ts := range tests {
+		evaluated := testEval(ts.input)
+		switch expected := ts.expected.(type) {
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T(%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g,want=%g", result.Value, expected)
+		return false
+	}
+	return true
+}
+
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"7 % 3", 1},
+		{"7.5 % 2", 1.5},
+		{"1 <= 2", true},
+		{"3 <= 2", false},
+		{"2 >= 2", true},
+		{"2.5 >= 3", false},
+		{"true && false", false},
+		{"false || true", true},
+		{"1 && 2", true},
+		{"false && foobar", false},
+		{"true || foobar", true},
+		{"true && foobar", "identifier not found: foobar"},
+		{"1 % 0", "division by zero"},
+	}
+
+	for _, ts := range tests {
+		evaluated := testEval(ts.input)
+		switch expected := ts.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q,got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`fn() { while (true) { return 1; } }()`, 1},
+		{`fn() { while (true) { break; } 2 }()`, 2},
+		{`fn() { for (x in [1, 2, 3]) { if (x > 1) { return x; } } }()`, 2},
+		{`fn() { for (x in [1, 2, 3]) { if (x < 3) { continue; } return x; } }()`, 3},
+		{`fn() { for (k in {3: "c", 1: "a", 2: "b"}) { return k; } }()`, 1},
+		{`fn() { for (c in "monkey") { return c; } }()`, "m"},
+		{`for (x in 1) { x }`, "cannot iterate over INTEGER"},
+		{`break;`, "break outside loop"},
+		{`while (true) { fn() { continue; }() }`, "continue outside loop"},
+	}
+
+	for _, ts := range tests {
+		evaluated := testEval(ts.input)
+		switch expected := ts.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("String has wrong value. got=%q, want=%q", obj.Value, expected)
+				}
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("wrong error message. expected=%q,got=%q", expected, obj.Message)
+				}
+			default:
+				t.Errorf("object is not String or Error. got=%T(%+v)", evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`let x = 1; x = 2; x`, 2},
+		{`let a = 1; let b = 2; a = b = 3; a + b`, 6},
+		{`let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x %= 4; x`, 2},
+		{`
+let newCounter = fn() {
+  let count = 0;
+  fn() { count += 1; }
+};
+let counterA = newCounter();
+let counterB = newCounter();
+counterA(); counterA(); counterB();
+counterA()`, 3},
+		{`let sum = 0; let i = 0; while (i < 5) { i += 1; sum += i; } sum`, 15},
+		{`x = 1`, "identifier not found: x"},
+		{`len = 1`, "cannot assign to builtin len"},
+		{`let x = 1; x += "a"`, "type mismatch: INTEGER + STRING"},
+	}
+
+	for _, ts := range tests {
+		evaluated := testEval(ts.input)
+		switch expected := ts.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q,got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestIndexAssignments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`let a = [1, 2, 3]; a[1] = 5; a[1]`, 5},
+		{`let a = [1, 2, 3]; let b = a; b[0] = 7; a[0]`, 7},
+		{`let a = [[1], [2]]; a[1][0] += 40; a[1][0]`, 42},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 10; h["a"] + h["b"]`, 12},
+		{`let calls = 0; let f = fn() { calls += 1; [0] }; f()[0] += 1; calls`, 1},
+		{`let a = [1]; a[1] = 2`, "index out of range: 1 (length 1)"},
+		{`let a = [1]; a["x"] = 2`, "array index must be INTEGER, got STRING"},
+		{`let h = {}; h[fn() {}] = 1`, "unusable as hash key: FUNCTION"},
+		{`let s = "abc"; s[0] = "x"`, "index assignment not supported: STRING"},
+	}
+
+	for _, ts := range tests {
+		evaluated := testEval(ts.input)
+		switch expected := ts.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q,got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestExit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`exit(); 1`, 0},
+		{`exit(3); 1`, 3},
+		{`let f = fn() { for (x in [1, 2]) { if (x == 2) { exit(x) } } 5 }; f(); 1`, 2},
+		{`let i = 0; while (true) { i += 1; if (i > 3) { exit(i) } }`, 4},
+	}
+
+	for _, ts := range tests {
+		evaluated := testEval(ts.input)
+		exit, ok := evaluated.(*object.Exit)
+		if !ok {
+			t.Errorf("object is not Exit. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if exit.Code != ts.expected {
+			t.Errorf("wrong exit code. want=%d, got=%d", ts.expected, exit.Code)
+		}
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`len(map([1, 2, 3], fn(x) { x * 2 }))`, 3},
+		{`map([1, 2, 3], fn(x) { x * 2 })[2]`, 6},
+		{`reduce(filter([1, 2, 3, 4], fn(x) { x % 2 == 0 }), 0, fn(acc, x) { acc + x })`, 6},
+		{`let sum = 0; each([1, 2, 3], fn(x) { sum = sum + x }); sum`, 6},
+		{`any([1, 2, 3], fn(x) { x > 2 })`, true},
+		{`all([1, 2, 3], fn(x) { x > 1 })`, false},
+		{`sort_by([3, 1, 2], fn(x) { -x })[0]`, 3},
+		{`map([1], fn(x) { x + "!" })`, "type mismatch: INTEGER + STRING"},
+		{`map([1], fn(a, b) { a })`, "wrong number of arguments: want=2, got=1"},
+	}
+
+	for _, ts := range tests {
+		eval := testEval(ts.input)
+		switch expected := ts.expected.(type) {
+		case int:
+			testIntegerObject(t, eval, int64(expected))
+		case bool:
+			testBooleanObject(t, eval, expected)
+		case string:
+			errObj, ok := eval.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", eval, eval)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q,got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestEvalContextLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		expected object.LimitError
+	}{
+		{"let f = fn() { f() }; f()", object.Limits{MaxCallDepth: 100}, object.LimitError{Limit: object.LimitCallDepth, Max: 100}},
+		{"while (true) { }", object.Limits{MaxInstructions: 1000}, object.LimitError{Limit: object.LimitInstructions, Max: 1000}},
+		{`let s = ""; while (true) { s = s + "x" }`, object.Limits{MaxAllocations: 50}, object.LimitError{Limit: object.LimitAllocations, Max: 50}},
+		{"map([1, 2, 3], fn(x) { [x] })", object.Limits{MaxAllocations: 3}, object.LimitError{Limit: object.LimitAllocations, Max: 3}},
+		{"map([1], fn(x) { while (true) { } })", object.Limits{MaxInstructions: 1000}, object.LimitError{Limit: object.LimitInstructions, Max: 1000}},
+		{`let a = []; while (true) { a = push(a, "x") }`, object.Limits{MaxMemory: 1 << 16}, object.LimitError{Limit: object.LimitMemory, Max: 1 << 16}},
+		{`let h = {}; let i = 0; while (true) { h[i] = i; i = i + 1 }`, object.Limits{MaxMemory: 1 << 16}, object.LimitError{Limit: object.LimitMemory, Max: 1 << 16}},
+	}
+
+	for _, ts := range tests {
+		env := object.NewEnvironment()
+		_, err := EvalContext(context.Background(), testParseProgram(ts.input), env, ts.limits)
+		var limitErr *object.LimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%q: expected *object.LimitError. got=%T (%v)", ts.input, err, err)
+			continue
+		}
+		if *limitErr != ts.expected {
+			t.Errorf("%q: wrong limit error. want=%v, got=%v", ts.input, ts.expected, limitErr)
+		}
+		//限额只在EvalContext期间有效
+		if env.Budget() != nil {
+			t.Errorf("%q: budget left in environment", ts.input)
+		}
+	}
+
+	evaluated, err := EvalContext(context.Background(), testParseProgram("let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(10)"),
+		object.NewEnvironment(), object.Limits{MaxInstructions: 1000, MaxCallDepth: 11, MaxAllocations: 1})
+	if err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+	testIntegerObject(t, evaluated, 0)
+}
+
+func TestEvalContextCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := EvalContext(ctx, testParseProgram("while (true) { }"), object.NewEnvironment(), object.Limits{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded. got=%v", err)
+	}
+}
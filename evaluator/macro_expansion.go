@@ -1,10 +1,38 @@
 package evaluator
 
 import (
+	"context"
+	"fmt"
 	"myinterpreter/ast"
 	"myinterpreter/object"
 )
 
+// DefineAndExpandMacros 收集program中的宏定义并展开其中的宏调用，编译器和解释器共用这一步。
+// 宏体没有返回quote或者参数个数不对时返回error，而不是让调用者崩溃
+func DefineAndExpandMacros(program *ast.Program, env *object.Environment) (expanded *ast.Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if exceeded, ok := r.(budgetExceeded); ok {
+				err = fmt.Errorf("macro expansion failed: %w", exceeded.err)
+				return
+			}
+			err = fmt.Errorf("macro expansion failed: %v", r)
+		}
+	}()
+	Definemacros(program, env)
+	expanded, _ = ExpandMacro(program, env).(*ast.Program)
+	return expanded, nil
+}
+
+// DefineAndExpandMacrosContext 和DefineAndExpandMacros相同，但是和EvalContext一样
+// 执行宏体时受ctx和limits的限制，超出时返回包装了*object.LimitError或ctx.Err()的错误
+func DefineAndExpandMacrosContext(ctx context.Context, program *ast.Program, env *object.Environment, limits object.Limits) (*ast.Program, error) {
+	previous := env.Budget()
+	env.SetBudget(object.NewBudget(ctx, limits))
+	defer env.SetBudget(previous)
+	return DefineAndExpandMacros(program, env)
+}
+
 func Definemacros(program *ast.Program, env *object.Environment) {
 	definitions := []int{}
 
@@ -57,6 +85,10 @@ func ExpandMacro(program ast.Node, env *object.Environment) ast.Node {
 		}
 
 		args := quoteArgs(callExpression)
+		if len(args) != len(macro.Parameters) {
+			panic(fmt.Sprintf("wrong number of arguments to %s: want=%d, got=%d",
+				callExpression.Function.String(), len(macro.Parameters), len(args)))
+		}
 		evalEnv := extendMacroEnv(macro, args)
 		evaluated := Eval(macro.Body, evalEnv)
 
@@ -79,7 +111,7 @@ func isMacroCall(exp *ast.CallExpression, env *object.Environment) (*object.Macr
 	}
 	macro, ok := obj.(*object.Macro)
 	if !ok {
-		return nil, true
+		return nil, false
 	}
 	return macro, true
 }
@@ -98,6 +98,14 @@ unless(10 > 5, puts("not greater"), puts("greater"));
 `,
 			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`,
 		},
+		{
+			`
+let reverse = macro(a, b) { quote(unquote(b) - unquote(a)); };
+reverse(1, 2);
+reverse(3, 4);
+`,
+			`(2 - 1); (4 - 3)`,
+		},
 	}
 
 	for _, ts := range tests {
@@ -113,3 +121,30 @@ unless(10 > 5, puts("not greater"), puts("greater"));
 		}
 	}
 }
+
+func TestDefineAndExpandMacrosErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let m = macro() { 1 }; m();`,
+			"macro expansion failed: we only support returning AST-nodes from macros",
+		},
+		{
+			`let m = macro(a, b) { quote(a) }; m(1);`,
+			"macro expansion failed: wrong number of arguments to m: want=2, got=1",
+		},
+	}
+
+	for _, ts := range tests {
+		program := testParseProgram(ts.input)
+		_, err := DefineAndExpandMacros(program, object.NewEnvironment())
+		if err == nil {
+			t.Fatalf("expected error for %q", ts.input)
+		}
+		if err.Error() != ts.expected {
+			t.Errorf("wrong error. want=%q, got=%q", ts.expected, err)
+		}
+	}
+}
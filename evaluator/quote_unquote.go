@@ -8,7 +8,8 @@ import (
 )
 
 func quote(node ast.Node, env *object.Environment) object.Object {
-	node = evalUnquoteCalls(node, env)
+	//unquote会原地替换节点，拷贝一份以免宏第二次展开时看到第一次的结果
+	node = evalUnquoteCalls(ast.Clone(node), env)
 	return &object.Quote{Node: node}
 }
 
@@ -46,6 +47,12 @@ func convertObject2ASTNode(obj object.Object) ast.Node {
 			Literal: fmt.Sprintf("%d", obj.Value),
 		}
 		return &ast.IntegerLiteral{Token: t, Value: obj.Value}
+	case *object.Float:
+		t := token.Token{
+			Type:    token.FLOAT,
+			Literal: obj.Inspect(),
+		}
+		return &ast.FloatLiteral{Token: t, Value: obj.Value}
 	case *object.Boolean:
 		var t token.Token
 		if obj.Value {
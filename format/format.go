@@ -0,0 +1,281 @@
+// Package format 把Monkey源码重新排版成统一的风格：四个空格缩进，
+// 代码块总是换行，运算符两边有空格，只保留必要的括号，注释原样保留
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"myinterpreter/ast"
+	"myinterpreter/lexer"
+	"myinterpreter/parser"
+	"myinterpreter/token"
+	"sort"
+	"strings"
+)
+
+const indentUnit = "    "
+
+// Source 格式化一个源文件，有语法错误时返回全部错误
+func Source(filename string, src []byte) ([]byte, error) {
+	l := lexer.NewWithFilename(filename, string(src))
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(strings.TrimSuffix(parser.RenderDiagnostics(p.Diagnostics(), string(src)), "\n"))
+	}
+
+	pr := &printer{comments: l.Comments()}
+	pr.statements(program.Statements, false, len(src))
+	return pr.buf.Bytes(), nil
+}
+
+type printer struct {
+	buf      bytes.Buffer
+	indent   int
+	comments []token.Comment
+	lastLine int //最后输出的语句或注释在源码中结束的行，用于保留空行
+}
+
+// statements 输出一串语句以及它们之间和之后(end之前)的注释
+func (p *printer) statements(stmts []ast.Statement, valued bool, end int) {
+	for i, stmt := range stmts {
+		p.commentsBefore(stmt.Pos().Offset)
+		p.blankLineBefore(stmt.Pos().Line)
+		p.writeIndent()
+		p.statement(stmt, valued && i == len(stmts)-1)
+		p.lastLine = stmt.End().Line
+		p.trailingComment(stmt.End())
+		p.buf.WriteString("\n")
+	}
+	p.commentsBefore(end)
+}
+
+func (p *printer) statement(stmt ast.Statement, isBlockValue bool) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		p.buf.WriteString("let " + stmt.Name.Value + " = ")
+		p.expression(stmt.Value, parser.LOWEST)
+		p.buf.WriteString(";")
+	case *ast.ReturnStatement:
+		p.buf.WriteString("return")
+		if stmt.ReturnValue != nil {
+			p.buf.WriteString(" ")
+			p.expression(stmt.ReturnValue, parser.LOWEST)
+		}
+		p.buf.WriteString(";")
+	case *ast.ExpressionStatement:
+		p.expression(stmt.Expression, parser.LOWEST)
+		if _, ok := stmt.Expression.(*ast.IfExpression); !ok && !isBlockValue {
+			p.buf.WriteString(";")
+		}
+	case *ast.WhileStatement:
+		p.buf.WriteString("while (")
+		p.expression(stmt.Condition, parser.LOWEST)
+		p.buf.WriteString(") ")
+		p.block(stmt.Body, false)
+	case *ast.ForStatement:
+		p.buf.WriteString("for (" + stmt.Variable.Value + " in ")
+		p.expression(stmt.Iterable, parser.LOWEST)
+		p.buf.WriteString(") ")
+		p.block(stmt.Body, false)
+	case *ast.BreakStatement:
+		p.buf.WriteString("break;")
+	case *ast.ContinueStatement:
+		p.buf.WriteString("continue;")
+	case *ast.BlockStatement:
+		p.block(stmt, false)
+	}
+}
+
+// block 输出一个代码块，valued表示块的值会被使用(函数体和if的分支)，
+// 这时最后一个表达式语句后面不加分号
+func (p *printer) block(b *ast.BlockStatement, valued bool) {
+	end := b.Rbrace.Pos.Offset
+	if len(b.Statements) == 0 && !p.hasCommentBefore(end) {
+		p.buf.WriteString("{}")
+		return
+	}
+	p.buf.WriteString("{\n")
+	p.indent++
+	p.statements(b.Statements, valued, end)
+	p.indent--
+	p.writeIndent()
+	p.buf.WriteString("}")
+	p.lastLine = b.Rbrace.Pos.Line
+}
+
+// expression 输出表达式，表达式的优先级低于上下文要求的prec时加括号
+func (p *printer) expression(e ast.Expression, prec int) {
+	own := precedence(e)
+	if own < prec {
+		p.buf.WriteString("(")
+		defer p.buf.WriteString(")")
+	}
+
+	switch e := e.(type) {
+	case *ast.Identifier:
+		p.buf.WriteString(e.Value)
+	case *ast.IntegerLiteral:
+		p.buf.WriteString(e.Token.Literal)
+	case *ast.FloatLiteral:
+		p.buf.WriteString(e.Token.Literal)
+	case *ast.StringLiteral:
+		p.buf.WriteString(`"` + e.Value + `"`)
+	case *ast.Boolean:
+		fmt.Fprintf(&p.buf, "%t", e.Value)
+	case *ast.PrefixExpression:
+		p.buf.WriteString(e.Operator)
+		p.expression(e.Right, parser.PREFIX)
+	case *ast.InfixExpression:
+		//运算符都是左结合的，右边同一优先级的表达式需要括号
+		p.expression(e.Left, own)
+		p.buf.WriteString(" " + e.Operator + " ")
+		p.expression(e.Right, own+1)
+	case *ast.AssignExpression:
+		//赋值是右结合的
+		p.expression(e.Target, own+1)
+		p.buf.WriteString(" " + e.Operator + " ")
+		p.expression(e.Value, own)
+	case *ast.CallExpression:
+		p.expression(e.Function, parser.CALL)
+		p.buf.WriteString("(")
+		p.list(e.Arguments)
+		p.buf.WriteString(")")
+	case *ast.IndexExpression:
+		//调用和索引可以任意串联，左边是调用时不需要括号
+		p.expression(e.Left, parser.CALL)
+		p.buf.WriteString("[")
+		p.expression(e.Index, parser.LOWEST)
+		p.buf.WriteString("]")
+	case *ast.ArrayLiteral:
+		p.buf.WriteString("[")
+		p.list(e.Elements)
+		p.buf.WriteString("]")
+	case *ast.HashLiteral:
+		p.hash(e)
+	case *ast.FunctionLiteral:
+		p.buf.WriteString("fn(" + joinIdentifiers(e.Parameters) + ") ")
+		p.block(e.Body, true)
+	case *ast.MacroLiteral:
+		p.buf.WriteString("macro(" + joinIdentifiers(e.Parameters) + ") ")
+		p.block(e.Body, true)
+	case *ast.IfExpression:
+		p.buf.WriteString("if (")
+		p.expression(e.Condition, parser.LOWEST)
+		p.buf.WriteString(") ")
+		p.block(e.Consequence, true)
+		if e.Alternative != nil {
+			p.buf.WriteString(" else ")
+			p.block(e.Alternative, true)
+		}
+	}
+}
+
+func (p *printer) list(exps []ast.Expression) {
+	for i, e := range exps {
+		if i > 0 {
+			p.buf.WriteString(", ")
+		}
+		p.expression(e, parser.LOWEST)
+	}
+}
+
+// hash 按key在源码中出现的顺序输出，HashLiteral.Pairs本身是无序的
+func (p *printer) hash(h *ast.HashLiteral) {
+	keys := make([]ast.Expression, 0, len(h.Pairs))
+	for k := range h.Pairs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Pos().Offset < keys[j].Pos().Offset
+	})
+
+	p.buf.WriteString("{")
+	for i, k := range keys {
+		if i > 0 {
+			p.buf.WriteString(", ")
+		}
+		p.expression(k, parser.LOWEST)
+		p.buf.WriteString(": ")
+		p.expression(h.Pairs[k], parser.LOWEST)
+	}
+	p.buf.WriteString("}")
+}
+
+// commentsBefore 把offset之前还没有输出的注释各自输出为一行
+func (p *printer) commentsBefore(offset int) {
+	for p.hasCommentBefore(offset) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.blankLineBefore(c.Pos.Line)
+		p.writeIndent()
+		p.buf.WriteString(c.Text + "\n")
+		p.lastLine = c.End.Line
+	}
+}
+
+func (p *printer) hasCommentBefore(offset int) bool {
+	return len(p.comments) > 0 && p.comments[0].Pos.Offset < offset
+}
+
+// trailingComment 输出和语句结尾在同一行的注释
+func (p *printer) trailingComment(end token.Position) {
+	if len(p.comments) == 0 {
+		return
+	}
+	c := p.comments[0]
+	if c.Pos.Line == end.Line && c.Pos.Offset >= end.Offset {
+		p.comments = p.comments[1:]
+		p.buf.WriteString(" " + c.Text)
+		p.lastLine = c.End.Line
+	}
+}
+
+// blankLineBefore 源码中两段内容之间有空行时保留一个空行
+func (p *printer) blankLineBefore(line int) {
+	if p.buf.Len() > 0 && p.lastLine > 0 && line > p.lastLine+1 && !bytes.HasSuffix(p.buf.Bytes(), []byte("{\n")) {
+		p.buf.WriteString("\n")
+	}
+}
+
+func (p *printer) writeIndent() {
+	p.buf.WriteString(strings.Repeat(indentUnit, p.indent))
+}
+
+func precedence(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.AssignExpression:
+		return parser.ASSIGN
+	case *ast.InfixExpression:
+		switch e.Operator {
+		case "||":
+			return parser.LOGICALOR
+		case "&&":
+			return parser.LOGICALAND
+		case "==", "!=":
+			return parser.EQUALS
+		case "<", ">", "<=", ">=":
+			return parser.LESSGREATER
+		case "+", "-":
+			return parser.SUM
+		default:
+			return parser.PRODUCT
+		}
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression:
+		return parser.CALL
+	case *ast.IndexExpression:
+		return parser.INDEX
+	}
+	return parser.INDEX + 1
+}
+
+func joinIdentifiers(idents []*ast.Identifier) string {
+	names := make([]string, len(idents))
+	for i, ident := range idents {
+		names[i] = ident.Value
+	}
+	return strings.Join(names, ", ")
+}
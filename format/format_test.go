@@ -0,0 +1,90 @@
+package format
+
+import "testing"
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let x=1+2*3;let y=(1+2)*3;x-(y-1)`,
+			"let x = 1 + 2 * 3;\nlet y = (1 + 2) * 3;\nx - (y - 1);\n",
+		},
+		{
+			`let f=fn(a,b){if(a>b){a}else{b}};f(1,2)[0]`,
+			"let f = fn(a, b) {\n    if (a > b) {\n        a\n    } else {\n        b\n    }\n};\nf(1, 2)[0];\n",
+		},
+		{
+			`-(a+b); !-x; a = b += 2; (a || b) && c`,
+			"-(a + b);\n!-x;\na = b += 2;\n(a || b) && c;\n",
+		},
+		{
+			`let h = {"b": 1, "a": [1.50, 2]}; fn() {}`,
+			"let h = {\"b\": 1, \"a\": [1.50, 2]};\nfn() {};\n",
+		},
+		{
+			`for (x in xs) { if (x) { break; } puts(x) } while (true) { continue; }`,
+			"for (x in xs) {\n    if (x) {\n        break;\n    }\n    puts(x);\n}\nwhile (true) {\n    continue;\n}\n",
+		},
+	}
+
+	for _, ts := range tests {
+		out, err := Source("", []byte(ts.input))
+		if err != nil {
+			t.Fatalf("format error: %s", err)
+		}
+		if string(out) != ts.expected {
+			t.Errorf("wrong output for %q.\nwant=%q\ngot =%q", ts.input, ts.expected, out)
+		}
+	}
+}
+
+func TestSourceComments(t *testing.T) {
+	input := `#!/usr/bin/env monkey
+// leading
+let x = 1;   // trailing
+
+
+let f = fn() {
+  /* inside */
+  x
+  // before brace
+};
+// end of file`
+
+	expected := `#!/usr/bin/env monkey
+// leading
+let x = 1; // trailing
+
+let f = fn() {
+    /* inside */
+    x
+    // before brace
+};
+// end of file
+`
+
+	out, err := Source("script.mk", []byte(input))
+	if err != nil {
+		t.Fatalf("format error: %s", err)
+	}
+	if string(out) != expected {
+		t.Fatalf("wrong output.\nwant=%q\ngot =%q", expected, out)
+	}
+
+	again, err := Source("script.mk", out)
+	if err != nil {
+		t.Fatalf("format error: %s", err)
+	}
+	if string(again) != string(out) {
+		t.Errorf("formatting is not idempotent.\nfirst =%q\nsecond=%q", out, again)
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	_, err := Source("bad.mk", []byte(`let = 1;`))
+	if err == nil {
+		t.Fatalf("expected parser error")
+	}
+}
@@ -1,3 +1,7 @@
 module myinterpreter
 
 go 1.20
+
+require golang.org/x/term v0.15.0
+
+require golang.org/x/sys v0.15.0 // indirect
@@ -9,46 +9,79 @@ type Lexer struct {
 	position     int  //当前读input的位置
 	readposition int  //position的下一个位置
 	ch           byte //input[readposition]
+	filename     string
+	line         int //ch所在的行
+	column       int //ch所在的列
+	comments     []token.Comment
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFilename("", input)
+}
+
+func NewWithFilename(filename, input string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readChar()
+	if l.ch == '#' && l.peekChar() == '!' {
+		//脚本第一行的 #!/usr/bin/env monkey 当作注释跳过
+		l.readLineComment()
+	}
 	return l
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
 	l.consumeWhiteSpace()
+	pos := l.pos()
+	if l.ch == '/' && l.peekChar() == '*' {
+		//只有未闭合的块注释会走到这里
+		return token.Token{Type: token.ILLEGAL, Literal: l.input[l.position:], Pos: pos, End: l.skipToEnd()}
+	}
+	tok := l.nextToken()
+	tok.Pos = pos
+	tok.End = l.pos()
+	if tok.Type == token.EOF {
+		tok.End = pos
+	}
+	return tok
+}
+
+func (l *Lexer) nextToken() token.Token {
+	var tok token.Token
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
 			lit := string(ch) + string(l.ch)
-			tok = token.Token{token.EQ, lit}
+			tok = token.Token{Type: token.EQ, Literal: lit}
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.newTwoCharToken('=', token.MINUS_ASSIGN, token.MINUS)
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
 			lit := string(ch) + string(l.ch)
-			tok = token.Token{token.NOT_EQ, lit}
+			tok = token.Token{Type: token.NOT_EQ, Literal: lit}
 		} else {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		tok = l.newTwoCharToken('=', token.ASTERISK_ASSIGN, token.ASTERISK)
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		tok = l.newTwoCharToken('=', token.SLASH_ASSIGN, token.SLASH)
+	case '%':
+		tok = l.newTwoCharToken('=', token.PERCENT_ASSIGN, token.PERCENT)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		tok = l.newTwoCharToken('=', token.LT_EQ, token.LT)
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		tok = l.newTwoCharToken('=', token.GT_EQ, token.GT)
+	case '&':
+		tok = l.newTwoCharToken('&', token.AND, token.ILLEGAL)
+	case '|':
+		tok = l.newTwoCharToken('|', token.OR, token.ILLEGAL)
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case '(':
@@ -58,7 +91,7 @@ func (l *Lexer) NextToken() token.Token {
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.newTwoCharToken('=', token.PLUS_ASSIGN, token.PLUS)
 	case '{':
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
@@ -81,9 +114,7 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.LookupIdent(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readDigit()
-			return tok
+			return l.readNumber()
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
 		}
@@ -111,6 +142,30 @@ func (l *Lexer) readDigit() string {
 	return l.input[p:l.position]
 }
 
+// readNumber 读取整数或浮点数，浮点数形如 3.14、1e-9、2.5E+3
+func (l *Lexer) readNumber() token.Token {
+	p := l.position
+	tokenType := token.TokenType(token.INT)
+	l.readDigit()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigit()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if isDigit(next) || (next == '+' || next == '-') && l.readposition+1 < len(l.input) && isDigit(l.input[l.readposition+1]) {
+			tokenType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigit()
+		}
+	}
+	return token.Token{Type: tokenType, Literal: l.input[p:l.position]}
+}
+
 func isDigit(ch byte) bool {
 	if ch >= '0' && ch <= '9' {
 		return true
@@ -133,13 +188,84 @@ func isLetter(ch byte) bool {
 	return false
 }
 
+// Comments 返回目前为止跳过的所有注释，供格式化等工具保留注释
+func (l *Lexer) Comments() []token.Comment {
+	return l.comments
+}
+
+// consumeWhiteSpace 跳过空白和注释
 func (l *Lexer) consumeWhiteSpace() {
-	for l.ch == ' ' || l.ch == '\n' || l.ch == '\r' || l.ch == '\t' {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\n' || l.ch == '\r' || l.ch == '\t':
+			l.readChar()
+		case l.ch == '/' && l.peekChar() == '/':
+			l.readLineComment()
+		case l.ch == '/' && l.peekChar() == '*':
+			if !l.readBlockComment() {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *Lexer) readLineComment() {
+	pos := l.pos()
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	l.addComment(pos)
+}
+
+// readBlockComment 读取可以嵌套的块注释，未闭合时回到注释开头并返回false
+func (l *Lexer) readBlockComment() bool {
+	start := *l
+	pos := l.pos()
+	depth := 0
+	for {
+		switch {
+		case l.ch == 0:
+			*l = start
+			return false
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+		}
+		l.readChar()
+		if depth == 0 {
+			l.addComment(pos)
+			return true
+		}
+	}
+}
+
+func (l *Lexer) addComment(pos token.Position) {
+	comment := token.Comment{Text: l.input[pos.Offset:l.position], Pos: pos, End: l.pos()}
+	l.comments = append(l.comments, comment)
+}
+
+func (l *Lexer) skipToEnd() token.Position {
+	for l.ch != 0 {
 		l.readChar()
 	}
+	return l.pos()
+}
+
+func (l *Lexer) pos() token.Position {
+	return token.Position{Filename: l.filename, Offset: l.position, Line: l.line, Column: l.column}
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
 	if l.readposition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -157,6 +283,16 @@ func (l *Lexer) peekChar() byte {
 	}
 }
 
+// newTwoCharToken 当下一个字符是next时返回两个字符的twoChar token，否则返回单字符的oneChar token
+func (l *Lexer) newTwoCharToken(next byte, twoChar, oneChar token.TokenType) token.Token {
+	if l.peekChar() == next {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: twoChar, Literal: string(ch) + string(l.ch)}
+	}
+	return newToken(oneChar, l.ch)
+}
+
 func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
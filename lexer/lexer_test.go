@@ -14,7 +14,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 if (5 < 10) {
 	return true;
@@ -146,3 +146,209 @@ macro(x, y) { x + y; };
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  "ab" == x;
+`
+	tests := []struct {
+		expectedLiteral string
+		expectedPos     string
+		expectedEnd     string
+	}{
+		{"let", "test.mk:1:1", "test.mk:1:4"},
+		{"x", "test.mk:1:5", "test.mk:1:6"},
+		{"=", "test.mk:1:7", "test.mk:1:8"},
+		{"5", "test.mk:1:9", "test.mk:1:10"},
+		{";", "test.mk:1:10", "test.mk:1:11"},
+		{"ab", "test.mk:2:3", "test.mk:2:7"},
+		{"==", "test.mk:2:8", "test.mk:2:10"},
+		{"x", "test.mk:2:11", "test.mk:2:12"},
+		{";", "test.mk:2:12", "test.mk:2:13"},
+		{"", "test.mk:3:1", "test.mk:3:1"},
+	}
+	l := NewWithFilename("test.mk", input)
+
+	for i, ts := range tests {
+		tok := l.NextToken()
+		if tok.Literal != ts.expectedLiteral {
+			t.Fatalf("test{%d} tokenLiteral wrong,want[%q],get[%q]", i, ts.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.String() != ts.expectedPos {
+			t.Errorf("test{%d} token pos wrong,want[%q],get[%q]", i, ts.expectedPos, tok.Pos)
+		}
+		if tok.End.String() != ts.expectedEnd {
+			t.Errorf("test{%d} token end wrong,want[%q],get[%q]", i, ts.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let x = 1; // trailing
+/* block
+   comment */ x /* nested /* inner */ still comment */ / 2;
+/* unterminated /* nested */`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.ILLEGAL, "/* unterminated /* nested */"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+
+	for i, ts := range tests {
+		tok := l.NextToken()
+		if tok.Type != ts.expectedType {
+			t.Fatalf("test{%d} tokenType wrong,want[%q],get[%q]", i, ts.expectedType, tok.Type)
+		}
+		if tok.Literal != ts.expectedLiteral {
+			t.Fatalf("test{%d} tokenLiteral wrong,want[%q],get[%q]", i, ts.expectedLiteral, tok.Literal)
+		}
+	}
+
+	expectedComments := []struct {
+		text string
+		pos  string
+	}{
+		{"// leading comment", "1:1"},
+		{"// trailing", "2:12"},
+		{"/* block\n   comment */", "3:1"},
+		{"/* nested /* inner */ still comment */", "4:17"},
+	}
+	comments := l.Comments()
+	if len(comments) != len(expectedComments) {
+		t.Fatalf("wrong number of comments. want=%d, got=%d", len(expectedComments), len(comments))
+	}
+	for i, ec := range expectedComments {
+		if comments[i].Text != ec.text {
+			t.Errorf("comment %d text wrong. want=%q, got=%q", i, ec.text, comments[i].Text)
+		}
+		if comments[i].Pos.String() != ec.pos {
+			t.Errorf("comment %d pos wrong. want=%q, got=%q", i, ec.pos, comments[i].Pos)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `3.14 10 1e-9 2.5E+3 7e2 1.x 4e`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.FLOAT, "3.14"},
+		{token.INT, "10"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E+3"},
+		{token.FLOAT, "7e2"},
+		{token.INT, "1"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "x"},
+		{token.INT, "4"},
+		{token.IDENT, "e"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+
+	for i, ts := range tests {
+		tok := l.NextToken()
+		if tok.Type != ts.expectedType {
+			t.Fatalf("test{%d} tokenType wrong,want[%q],get[%q]", i, ts.expectedType, tok.Type)
+		}
+		if tok.Literal != ts.expectedLiteral {
+			t.Fatalf("test{%d} tokenLiteral wrong,want[%q],get[%q]", i, ts.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	input := `a <= b >= c % d && e || f & |`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "d"},
+		{token.AND, "&&"},
+		{token.IDENT, "e"},
+		{token.OR, "||"},
+		{token.IDENT, "f"},
+		{token.ILLEGAL, "&"},
+		{token.ILLEGAL, "|"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+
+	for i, ts := range tests {
+		tok := l.NextToken()
+		if tok.Type != ts.expectedType {
+			t.Fatalf("test{%d} tokenType wrong,want[%q],get[%q]", i, ts.expectedType, tok.Type)
+		}
+		if tok.Literal != ts.expectedLiteral {
+			t.Fatalf("test{%d} tokenLiteral wrong,want[%q],get[%q]", i, ts.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestAssignmentOperators(t *testing.T) {
+	input := `x = 1; x += 2; x -= 3; x *= 4; x /= 5; x %= 6;`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "x"}, {token.ASSIGN, "="}, {token.INT, "1"}, {token.SEMICOLON, ";"},
+		{token.IDENT, "x"}, {token.PLUS_ASSIGN, "+="}, {token.INT, "2"}, {token.SEMICOLON, ";"},
+		{token.IDENT, "x"}, {token.MINUS_ASSIGN, "-="}, {token.INT, "3"}, {token.SEMICOLON, ";"},
+		{token.IDENT, "x"}, {token.ASTERISK_ASSIGN, "*="}, {token.INT, "4"}, {token.SEMICOLON, ";"},
+		{token.IDENT, "x"}, {token.SLASH_ASSIGN, "/="}, {token.INT, "5"}, {token.SEMICOLON, ";"},
+		{token.IDENT, "x"}, {token.PERCENT_ASSIGN, "%="}, {token.INT, "6"}, {token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+
+	for i, ts := range tests {
+		tok := l.NextToken()
+		if tok.Type != ts.expectedType {
+			t.Fatalf("test{%d} tokenType wrong,want[%q],get[%q]", i, ts.expectedType, tok.Type)
+		}
+		if tok.Literal != ts.expectedLiteral {
+			t.Fatalf("test{%d} tokenLiteral wrong,want[%q],get[%q]", i, ts.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestShebang(t *testing.T) {
+	input := "#!/usr/bin/env monkey\nlet x = 1;"
+
+	l := NewWithFilename("script.mk", input)
+	tok := l.NextToken()
+	if tok.Type != token.LET {
+		t.Fatalf("tokenType wrong,want[%q],get[%q]", token.LET, tok.Type)
+	}
+	if tok.Pos.String() != "script.mk:2:1" {
+		t.Errorf("token pos wrong,want[%q],get[%q]", "script.mk:2:1", tok.Pos)
+	}
+	comments := l.Comments()
+	if len(comments) != 1 || comments[0].Text != "#!/usr/bin/env monkey" {
+		t.Errorf("shebang not recorded as comment, got=%+v", comments)
+	}
+}
@@ -0,0 +1,296 @@
+package lsp
+
+import (
+	"context"
+	"errors"
+	"myinterpreter/ast"
+	"myinterpreter/compiler"
+	"myinterpreter/evaluator"
+	"myinterpreter/lexer"
+	"myinterpreter/object"
+	"myinterpreter/parser"
+	"myinterpreter/token"
+	"reflect"
+	"sort"
+)
+
+// 定义的种类
+const (
+	KindLet       = "let"
+	KindParameter = "parameter"
+	KindLoop      = "loop variable"
+)
+
+// Definition 是源码中定义一个名字的地方：let、函数或宏的参数、for的循环变量
+type Definition struct {
+	Name  *ast.Identifier
+	Kind  string
+	Scope compiler.SymbolScope //定义处的作用域，GLOBAL或LOCAL
+	Let   *ast.LetStatement    //Kind为KindLet时是所在的let语句
+}
+
+// Reference 是源码中出现的一个标识符，包括定义处的标识符本身。
+// Def为nil时名字是内置函数或者args，或者没有定义
+type Reference struct {
+	Ident *ast.Identifier
+	Def   *Definition
+	Scope compiler.SymbolScope //按编译器的规则解析出的作用域，没有定义时为空
+}
+
+// Problem 是解析或编译发现的一个错误
+type Problem struct {
+	Pos, End token.Position
+	Message  string
+	Source   string //parser或compiler
+}
+
+// scope 对应一个函数体，和编译器一样只有函数会开始新的作用域，块不会
+type scope struct {
+	table *compiler.SymbolTable
+	defs  map[string]*Definition
+	order []*Definition //按出现顺序，用于补全
+	node  ast.Node      //函数或宏字面量，全局作用域为nil
+	outer *scope
+}
+
+// Analysis 是一个文件的分析结果
+type Analysis struct {
+	Program    *ast.Program
+	Problems   []Problem
+	References []*Reference //按位置排序
+	Globals    []*Definition
+
+	scopes []*scope
+}
+
+// Analyze 解析源码并按编译器的作用域规则解析每个标识符，
+// 然后展开宏并编译一遍，收集解析和编译的错误
+func Analyze(filename, src string) *Analysis {
+	p := parser.New(lexer.NewWithFilename(filename, src))
+	a := &Analysis{Program: p.ParseProgram()}
+	for _, d := range p.Diagnostics() {
+		a.Problems = append(a.Problems, Problem{Pos: d.Pos, End: d.End, Message: d.Message, Source: "parser"})
+	}
+
+	r := &resolver{a: a}
+	r.scope = r.newScope(nil, newSymbolTable())
+	r.statements(a.Program.Statements)
+	a.Globals = r.scope.order
+	sort.SliceStable(a.References, func(i, j int) bool {
+		return a.References[i].Ident.Pos().Offset < a.References[j].Ident.Pos().Offset
+	})
+
+	if len(p.Diagnostics()) == 0 {
+		if problem, ok := compileProblem(filename, src); ok {
+			a.Problems = append(a.Problems, problem)
+		}
+	}
+	return a
+}
+
+// newSymbolTable 返回和monkey run相同的全局符号表，内置函数之后定义args
+func newSymbolTable() *compiler.SymbolTable {
+	symbols := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbols.DefineBuiltin(i, v.Name)
+	}
+	symbols.Define("args")
+	return symbols
+}
+
+// macroLimits 限制分析时展开宏可以执行的代码，宏中的死循环不能让服务器卡住
+var macroLimits = object.Limits{MaxInstructions: 1000000, MaxCallDepth: 1000, MaxMemory: 64 << 20}
+
+// compileProblem 重新解析源码、展开宏并编译，宏展开会修改语法树，所以不能用分析过的那一棵。
+// 展开宏要执行用户的代码，所以限制资源，并且不给puts和readline输入输出，标准输入输出是协议占用的
+func compileProblem(filename, src string) (Problem, bool) {
+	program := parser.New(lexer.NewWithFilename(filename, src)).ParseProgram()
+	env := object.NewEnvironment()
+	env.SetIO(object.IO{})
+	program, err := evaluator.DefineAndExpandMacrosContext(context.Background(), program, env, macroLimits)
+	if err != nil {
+		return Problem{Message: err.Error(), Source: "compiler"}, true
+	}
+	comp := compiler.NewWithState(newSymbolTable(), []object.Object{})
+	err = comp.Compile(program)
+	if err == nil {
+		return Problem{}, false
+	}
+	var compErr *compiler.Error
+	if errors.As(err, &compErr) {
+		return Problem{Pos: compErr.Pos, End: compErr.Pos, Message: compErr.Message, Source: "compiler"}, true
+	}
+	return Problem{Message: err.Error(), Source: "compiler"}, true
+}
+
+// ReferenceAt 返回覆盖offset的标识符，offset位于标识符末尾时也算
+func (a *Analysis) ReferenceAt(offset int) *Reference {
+	i := sort.Search(len(a.References), func(i int) bool {
+		return a.References[i].Ident.End().Offset >= offset
+	})
+	if i < len(a.References) && a.References[i].Ident.Pos().Offset <= offset {
+		return a.References[i]
+	}
+	return nil
+}
+
+// Visible 返回在offset处可以使用的定义，内层的定义覆盖外层的同名定义。
+// 和编译器一样，只有在offset之前定义的名字可见
+func (a *Analysis) Visible(offset int) []*Definition {
+	inner := a.scopes[0]
+	for _, s := range a.scopes[1:] {
+		if s.node.Pos().Offset <= offset && offset <= s.node.End().Offset && contains(s, inner) {
+			inner = s
+		}
+	}
+	seen := make(map[string]bool)
+	var defs []*Definition
+	for s := inner; s != nil; s = s.outer {
+		for i := len(s.order) - 1; i >= 0; i-- {
+			def := s.order[i]
+			if seen[def.Name.Value] || def.Name.Pos().Offset >= offset {
+				continue
+			}
+			seen[def.Name.Value] = true
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+// contains 判断outer是否是s本身或者s外层的作用域
+func contains(s, outer *scope) bool {
+	for ; s != nil; s = s.outer {
+		if s == outer {
+			return true
+		}
+	}
+	return false
+}
+
+// resolver 按编译器编译的顺序遍历语法树，用SymbolTable解析每个标识符的作用域
+type resolver struct {
+	a     *Analysis
+	scope *scope
+}
+
+func (r *resolver) newScope(node ast.Node, table *compiler.SymbolTable) *scope {
+	s := &scope{table: table, defs: make(map[string]*Definition), node: node, outer: r.scope}
+	r.a.scopes = append(r.a.scopes, s)
+	return s
+}
+
+func (r *resolver) define(ident *ast.Identifier, kind string, let *ast.LetStatement) {
+	if isNil(ident) {
+		return
+	}
+	symbol := r.scope.table.Define(ident.Value)
+	def := &Definition{Name: ident, Kind: kind, Scope: symbol.Scope, Let: let}
+	r.scope.defs[ident.Value] = def
+	r.scope.order = append(r.scope.order, def)
+	r.a.References = append(r.a.References, &Reference{Ident: ident, Def: def, Scope: symbol.Scope})
+}
+
+func (r *resolver) resolve(ident *ast.Identifier) {
+	ref := &Reference{Ident: ident}
+	if symbol, ok := r.scope.table.Resolve(ident.Value); ok {
+		ref.Scope = symbol.Scope
+		for s := r.scope; s != nil && ref.Def == nil; s = s.outer {
+			ref.Def = s.defs[ident.Value]
+		}
+	}
+	r.a.References = append(r.a.References, ref)
+}
+
+func (r *resolver) statements(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		r.node(stmt)
+	}
+}
+
+func (r *resolver) node(node ast.Node) {
+	if isNil(node) {
+		return
+	}
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		r.define(node.Name, KindLet, node)
+		r.node(node.Value)
+	case *ast.Identifier:
+		r.resolve(node)
+	case *ast.ExpressionStatement:
+		r.node(node.Expression)
+	case *ast.ReturnStatement:
+		r.node(node.ReturnValue)
+	case *ast.BlockStatement:
+		r.statements(node.Statements)
+	case *ast.IfExpression:
+		r.node(node.Condition)
+		r.node(node.Consequence)
+		r.node(node.Alternative)
+	case *ast.WhileStatement:
+		r.node(node.Condition)
+		r.node(node.Body)
+	case *ast.ForStatement:
+		r.node(node.Iterable)
+		r.define(node.Variable, KindLoop, nil)
+		r.node(node.Body)
+	case *ast.InfixExpression:
+		r.node(node.Left)
+		r.node(node.Right)
+	case *ast.PrefixExpression:
+		r.node(node.Right)
+	case *ast.AssignExpression:
+		r.node(node.Target)
+		r.node(node.Value)
+	case *ast.CallExpression:
+		r.node(node.Function)
+		for _, arg := range node.Arguments {
+			r.node(arg)
+		}
+	case *ast.IndexExpression:
+		r.node(node.Left)
+		r.node(node.Index)
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			r.node(el)
+		}
+	case *ast.HashLiteral:
+		//map没有顺序，按源码位置遍历
+		keys := make([]ast.Expression, 0, len(node.Pairs))
+		for key := range node.Pairs {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Pos().Offset < keys[j].Pos().Offset })
+		for _, key := range keys {
+			r.node(key)
+			r.node(node.Pairs[key])
+		}
+	case *ast.FunctionLiteral:
+		r.function(node, node.Name, node.Parameters, node.Body)
+	case *ast.MacroLiteral:
+		r.function(node, "", node.Parameters, node.Body)
+	}
+}
+
+// function 在新的作用域中定义参数并解析函数体，有名字的函数可以通过名字引用自己
+func (r *resolver) function(node ast.Node, name string, params []*ast.Identifier, body *ast.BlockStatement) {
+	outer := r.scope
+	r.scope = r.newScope(node, compiler.NewEnclosedSymbolTable(outer.table))
+	if name != "" {
+		r.scope.table.DefineFunctionName(name)
+		if def, ok := outer.defs[name]; ok {
+			r.scope.defs[name] = def
+		}
+	}
+	for _, p := range params {
+		r.define(p, KindParameter, nil)
+	}
+	r.node(body)
+	r.scope = outer
+}
+
+// isNil 判断节点是否为nil，解析出错时语法树中可能有值为nil的指针
+func isNil(node ast.Node) bool {
+	return node == nil || reflect.ValueOf(node).IsNil()
+}
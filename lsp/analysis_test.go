@@ -0,0 +1,110 @@
+package lsp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeScopes(t *testing.T) {
+	input := `let g = 1;
+let outer = fn(a) {
+  let b = a + g;
+  let inner = fn() { a + b + len([]) };
+  for (x in [1]) { b = x; }
+  outer(inner());
+};`
+
+	a := Analyze("", input)
+	if len(a.Problems) != 0 {
+		t.Fatalf("unexpected problems: %+v", a.Problems)
+	}
+
+	var got []string
+	for _, ref := range a.References {
+		def := "-"
+		if ref.Def != nil {
+			def = ref.Def.Kind + "@" + ref.Def.Name.Pos().String()
+		}
+		got = append(got, ref.Ident.Value+" "+string(ref.Scope)+" "+def)
+	}
+	expected := []string{
+		"g GLOBAL let@1:5",
+		"outer GLOBAL let@2:5",
+		"a LOCAL parameter@2:16",
+		"b LOCAL let@3:7",
+		"a LOCAL parameter@2:16",
+		"g GLOBAL let@1:5",
+		"inner LOCAL let@4:7",
+		"a FREE parameter@2:16",
+		"b FREE let@3:7",
+		"len BUILTIN -",
+		"x LOCAL loop variable@5:8",
+		"b LOCAL let@3:7",
+		"x LOCAL loop variable@5:8",
+		"outer FUNCTION let@2:5",
+		"inner LOCAL let@4:7",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong references.\nwant:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestAnalyzeProblems(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = ;", "parser 1:9: no prefix parse function for ; found"},
+		{"let x = 1;\ny + x;", "compiler 2:1: undefined variable y"},
+		{"len = 1;", "compiler 1:1: cannot assign to builtin len"},
+	}
+
+	for _, ts := range tests {
+		a := Analyze("", ts.input)
+		if len(a.Problems) == 0 {
+			t.Errorf("%q: expected a problem", ts.input)
+			continue
+		}
+		p := a.Problems[0]
+		if got := p.Source + " " + p.Pos.String() + ": " + p.Message; got != ts.expected {
+			t.Errorf("%q: wrong problem. want=%q, got=%q", ts.input, ts.expected, got)
+		}
+	}
+}
+
+func TestAnalyzeMacros(t *testing.T) {
+	a := Analyze("", "let m = macro() { while (true) { }; quote(1) };\nm() + missing;")
+	if len(a.Problems) != 1 || !strings.Contains(a.Problems[0].Message, "instruction limit exceeded") {
+		t.Errorf("an endless macro should stop at the limit, got %+v", a.Problems)
+	}
+
+	a = Analyze("", "let m = macro(x) { puts(readline()); quote(unquote(x) + 1) };\nm(1) + missing;")
+	if len(a.Problems) != 1 || a.Problems[0].Message != "undefined variable missing" {
+		t.Errorf("macros should expand before compiling, got %+v", a.Problems)
+	}
+}
+
+func TestVisible(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x) {
+  let y = 2;
+
+};
+let b = 3;`
+
+	a := Analyze("", input)
+	names := func(offset int) string {
+		var list []string
+		for _, def := range a.Visible(offset) {
+			list = append(list, def.Name.Value+":"+strings.ToLower(string(def.Scope)))
+		}
+		return strings.Join(list, " ")
+	}
+	inside := strings.Index(input, "\n\n") + 1
+	if got := names(inside); got != "y:local x:local f:global a:global" {
+		t.Errorf("wrong names inside f: %s", got)
+	}
+	if got := names(len(input)); got != "b:global f:global a:global" {
+		t.Errorf("wrong names at the end: %s", got)
+	}
+}
@@ -0,0 +1,236 @@
+package lsp
+
+import (
+	"myinterpreter/ast"
+	"myinterpreter/compiler"
+	"myinterpreter/object"
+	"myinterpreter/token"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// document 是一个打开的文件，每次修改后重新分析
+type document struct {
+	uri      string
+	text     string
+	lines    []int //每一行开头的字节偏移
+	analysis *Analysis
+}
+
+func newDocument(uri, text string) *document {
+	d := &document{uri: uri, text: text, lines: []int{0}}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+	d.analysis = Analyze(uriToPath(uri), text)
+	return d
+}
+
+// uriToPath 把file://形式的URI转换成路径，其他URI原样返回
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return u.Path
+}
+
+// position 把token中按字节计数的位置转换成协议中按UTF-16计数的位置
+func (d *document) position(p token.Position) Position {
+	if !p.IsValid() {
+		return Position{}
+	}
+	line := p.Line - 1
+	if line >= len(d.lines) {
+		line = len(d.lines) - 1
+	}
+	start := d.lines[line]
+	end := p.Offset
+	if end < start {
+		end = start
+	}
+	if end > len(d.text) {
+		end = len(d.text)
+	}
+	return Position{Line: line, Character: utf16Len(d.text[start:end])}
+}
+
+// offset 把协议中的位置转换成字节偏移，超出行尾时返回行尾
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	i, n := d.lines[pos.Line], 0
+	for i < len(d.text) && d.text[i] != '\n' && n < pos.Character {
+		r, size := utf8.DecodeRuneInString(d.text[i:])
+		n += utf16Units(r)
+		i += size
+	}
+	return i
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16Units(r)
+	}
+	return n
+}
+
+func utf16Units(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+func (d *document) rangeOf(pos, end token.Position) Range {
+	return Range{Start: d.position(pos), End: d.position(end)}
+}
+
+// diagnostics 转换分析出的错误。编译错误只有开始位置，范围扩展到那里的标识符末尾
+func (d *document) diagnostics() []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for _, problem := range d.analysis.Problems {
+		end := problem.End
+		if problem.Pos.IsValid() && end.Offset <= problem.Pos.Offset {
+			end = problem.Pos
+			for end.Offset < len(d.text) && isIdentChar(d.text[end.Offset]) {
+				end.Offset++
+			}
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    d.rangeOf(problem.Pos, end),
+			Severity: SeverityError,
+			Source:   "monkey " + problem.Source,
+			Message:  problem.Message,
+		})
+	}
+	return diagnostics
+}
+
+func isIdentChar(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' || ch == '_'
+}
+
+func (d *document) hover(pos Position) *Hover {
+	ref := d.analysis.ReferenceAt(d.offset(pos))
+	if ref == nil || ref.Scope == "" {
+		return nil
+	}
+	signature := "builtin " + ref.Ident.Value
+	if ref.Def != nil {
+		signature = describe(ref.Def)
+	} else if ref.Scope == compiler.GlobalScope {
+		signature = "let " + ref.Ident.Value
+	}
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: "```monkey\n" + signature + "\n```\n" + scopeText[ref.Scope]},
+		Range:    d.rangeOf(ref.Ident.Pos(), ref.Ident.End()),
+	}
+}
+
+// scopeText 说明标识符在引用处是哪一种变量
+var scopeText = map[compiler.SymbolScope]string{
+	compiler.GlobalScope:   "global variable",
+	compiler.LocalScope:    "local variable",
+	compiler.FreeScope:     "free variable, captured from an enclosing function",
+	compiler.BuiltinScope:  "builtin function",
+	compiler.FunctionScope: "the enclosing function itself",
+}
+
+// describe 返回定义的简短描述，函数和宏带上参数列表
+func describe(def *Definition) string {
+	name := def.Name.Value
+	if def.Kind != KindLet {
+		return def.Kind + " " + name
+	}
+	var params []*ast.Identifier
+	keyword := ""
+	switch value := def.Let.Value.(type) {
+	case *ast.FunctionLiteral:
+		keyword, params = "fn", value.Parameters
+	case *ast.MacroLiteral:
+		keyword, params = "macro", value.Parameters
+	default:
+		return "let " + name
+	}
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = p.Value
+	}
+	return "let " + name + " = " + keyword + "(" + strings.Join(names, ", ") + ")"
+}
+
+func (d *document) definition(pos Position) *Location {
+	ref := d.analysis.ReferenceAt(d.offset(pos))
+	if ref == nil || ref.Def == nil {
+		return nil
+	}
+	return &Location{URI: d.uri, Range: d.rangeOf(ref.Def.Name.Pos(), ref.Def.Name.End())}
+}
+
+// completion 返回光标处可见的变量和没有被覆盖的内置函数
+func (d *document) completion(pos Position) []CompletionItem {
+	items := []CompletionItem{}
+	seen := make(map[string]bool)
+	for _, def := range d.analysis.Visible(d.offset(pos)) {
+		seen[def.Name.Value] = true
+		items = append(items, CompletionItem{Label: def.Name.Value, Kind: completionKind(def), Detail: describe(def)})
+	}
+	if !seen["args"] {
+		items = append(items, CompletionItem{Label: "args", Kind: CompletionVariable, Detail: "let args"})
+	}
+	for _, b := range object.Builtins {
+		if !seen[b.Name] {
+			items = append(items, CompletionItem{Label: b.Name, Kind: CompletionFunction, Detail: "builtin " + b.Name})
+		}
+	}
+	return items
+}
+
+func completionKind(def *Definition) int {
+	if isFunction(def) {
+		return CompletionFunction
+	}
+	return CompletionVariable
+}
+
+func isFunction(def *Definition) bool {
+	if def.Let == nil {
+		return false
+	}
+	switch def.Let.Value.(type) {
+	case *ast.FunctionLiteral, *ast.MacroLiteral:
+		return true
+	}
+	return false
+}
+
+// symbols 返回全局作用域中的let，包括顶层的块和循环中的let
+func (d *document) symbols() []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	for _, def := range d.analysis.Globals {
+		if def.Kind != KindLet {
+			continue
+		}
+		kind := SymbolVariable
+		if isFunction(def) {
+			kind = SymbolFunction
+		}
+		symbols = append(symbols, DocumentSymbol{
+			Name:           def.Name.Value,
+			Detail:         describe(def),
+			Kind:           kind,
+			Range:          d.rangeOf(def.Let.Pos(), def.Let.End()),
+			SelectionRange: d.rangeOf(def.Name.Pos(), def.Name.End()),
+		})
+	}
+	return symbols
+}
@@ -0,0 +1,192 @@
+// Package lsp 实现语言服务器协议(Language Server Protocol)，为编辑器提供诊断、跳转到定义、
+// 悬停提示、补全和文档大纲。分析基于lexer、parser和编译器的SymbolTable
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Server 在一个连接上处理请求，请求按顺序处理，文件内容只支持全量同步
+type Server struct {
+	in          *bufio.Reader
+	out         io.Writer
+	docs        map[string]*document
+	initialized bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*document),
+	}
+}
+
+// Serve 处理消息直到收到exit通知或者输入结束
+func (s *Server) Serve() error {
+	for {
+		data, err := ReadMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			err = s.reply(json.RawMessage("null"), nil, &ResponseError{Code: CodeParseError, Message: err.Error()})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if msg.Method == "" {
+			continue //客户端对请求的响应，服务器不发请求
+		}
+		result, err := s.handle(&msg)
+		if msg.ID == nil {
+			continue //通知没有响应
+		}
+		if err := s.reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg *Message) (any, error) {
+	if msg.Method == "initialize" {
+		s.initialized = true
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:       1, //全量同步
+				HoverProvider:          true,
+				DefinitionProvider:     true,
+				CompletionProvider:     map[string]any{},
+				DocumentSymbolProvider: true,
+			},
+			ServerInfo: map[string]string{"name": "monkey-lsp"},
+		}, nil
+	}
+	if !s.initialized {
+		return nil, &ResponseError{Code: CodeServerNotInitialized, Message: "server not initialized"}
+	}
+
+	switch msg.Method {
+	case "initialized", "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			return nil, s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+	case "textDocument/hover":
+		doc, params, err := s.positionParams(msg)
+		if err != nil {
+			return nil, err
+		}
+		return doc.hover(params.Position), nil
+	case "textDocument/definition":
+		doc, params, err := s.positionParams(msg)
+		if err != nil {
+			return nil, err
+		}
+		return doc.definition(params.Position), nil
+	case "textDocument/completion":
+		doc, params, err := s.positionParams(msg)
+		if err != nil {
+			return nil, err
+		}
+		return doc.completion(params.Position), nil
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := decode(msg, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return doc.symbols(), nil
+	}
+	return nil, &ResponseError{Code: CodeMethodNotFound, Message: fmt.Sprintf("method %q not supported", msg.Method)}
+}
+
+// update 重新分析文件并发布诊断
+func (s *Server) update(uri, text string) error {
+	doc := newDocument(uri, text)
+	s.docs[uri] = doc
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: doc.diagnostics()})
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, &ResponseError{Code: CodeInvalidParams, Message: fmt.Sprintf("document %s is not open", uri)}
+	}
+	return doc, nil
+}
+
+func (s *Server) positionParams(msg *Message) (*document, TextDocumentPositionParams, error) {
+	var params TextDocumentPositionParams
+	if err := decode(msg, &params); err != nil {
+		return nil, params, err
+	}
+	doc, err := s.document(params.TextDocument.URI)
+	return doc, params, err
+}
+
+func decode(msg *Message, v any) error {
+	if err := json.Unmarshal(msg.Params, v); err != nil {
+		return &ResponseError{Code: CodeInvalidParams, Message: fmt.Sprintf("bad params for %s: %s", msg.Method, err)}
+	}
+	return nil
+}
+
+func (s *Server) reply(id json.RawMessage, result any, err error) error {
+	msg := Message{JSONRPC: "2.0", ID: id}
+	if err != nil {
+		respErr, ok := err.(*ResponseError)
+		if !ok {
+			respErr = &ResponseError{Code: CodeInvalidParams, Message: err.Error()}
+		}
+		msg.Error = respErr
+		return WriteMessage(s.out, msg)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	msg.Result = data
+	return WriteMessage(s.out, msg)
+}
+
+func (s *Server) notify(method string, params any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return WriteMessage(s.out, Message{JSONRPC: "2.0", Method: method, Params: data})
+}
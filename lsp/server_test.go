@@ -0,0 +1,237 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+const testURI = "file:///tmp/test.mk"
+
+const testSource = `let s = "😀"; let add = fn(a, b) {
+  let sum = a + b;
+  fn() { sum + len(s) }
+};
+add(1, 2);`
+
+// client 是测试用的编辑器，同步地发送请求和读取消息
+type client struct {
+	t  *testing.T
+	w  io.WriteCloser
+	r  *bufio.Reader
+	id int
+}
+
+func newClient(t *testing.T) (*client, chan error) {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(serverR, serverW).Serve()
+		serverW.Close()
+	}()
+	c := &client{t: t, w: clientW, r: bufio.NewReader(clientR)}
+	c.call("initialize", map[string]any{"capabilities": map[string]any{}}, nil)
+	c.notify("initialized", map[string]any{})
+	return c, done
+}
+
+func (c *client) read() Message {
+	c.t.Helper()
+	data, err := ReadMessage(c.r)
+	if err != nil {
+		c.t.Fatalf("read: %s", err)
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.t.Fatalf("bad message %s: %s", data, err)
+	}
+	return msg
+}
+
+func (c *client) notify(method string, params any) {
+	c.t.Helper()
+	data, _ := json.Marshal(params)
+	if err := WriteMessage(c.w, Message{JSONRPC: "2.0", Method: method, Params: data}); err != nil {
+		c.t.Fatalf("notify %s: %s", method, err)
+	}
+}
+
+// call 发送请求并把结果解码到result，返回响应中的错误
+func (c *client) call(method string, params any, result any) *ResponseError {
+	c.t.Helper()
+	c.id++
+	data, _ := json.Marshal(params)
+	id, _ := json.Marshal(c.id)
+	if err := WriteMessage(c.w, Message{JSONRPC: "2.0", ID: id, Method: method, Params: data}); err != nil {
+		c.t.Fatalf("call %s: %s", method, err)
+	}
+	resp := c.read()
+	if string(resp.ID) != string(id) {
+		c.t.Fatalf("expected response to %s, got %+v", id, resp)
+	}
+	if resp.Error == nil && result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			c.t.Fatalf("bad %s result %s: %s", method, resp.Result, err)
+		}
+	}
+	return resp.Error
+}
+
+// diagnostics 读取下一条publishDiagnostics通知
+func (c *client) diagnostics() []Diagnostic {
+	c.t.Helper()
+	msg := c.read()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected diagnostics, got %+v", msg)
+	}
+	var params PublishDiagnosticsParams
+	json.Unmarshal(msg.Params, &params)
+	if params.URI != testURI {
+		c.t.Fatalf("diagnostics for wrong document %s", params.URI)
+	}
+	return params.Diagnostics
+}
+
+func (c *client) open(text string) []Diagnostic {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{URI: testURI, Version: 1, Text: text}})
+	return c.diagnostics()
+}
+
+func (c *client) at(line, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: testURI}, Position: Position{Line: line, Character: character}}
+}
+
+func (c *client) close(done chan error) {
+	c.t.Helper()
+	c.call("shutdown", nil, nil)
+	c.notify("exit", nil)
+	if err := <-done; err != nil {
+		c.t.Fatalf("serve error: %s", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c, done := newClient(t)
+	if diags := c.open(testSource); len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %+v", diags)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: testURI},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "let s = \"😀\"; s + missing;"}},
+	})
+	diags := c.diagnostics()
+	if len(diags) != 1 || diags[0].Message != "undefined variable missing" || diags[0].Source != "monkey compiler" {
+		t.Fatalf("wrong diagnostics: %+v", diags)
+	}
+	// 😀在UTF-16中占两个单元
+	if r := diags[0].Range; r.Start != (Position{0, 18}) || r.End != (Position{0, 25}) {
+		t.Errorf("wrong diagnostic range: %+v", r)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: testURI},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "let x = (1;"}},
+	})
+	diags = c.diagnostics()
+	if len(diags) == 0 || diags[0].Source != "monkey parser" || diags[0].Severity != SeverityError {
+		t.Errorf("expected a parse error, got %+v", diags)
+	}
+
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: testURI}})
+	if diags := c.diagnostics(); len(diags) != 0 {
+		t.Errorf("closing a document should clear its diagnostics, got %+v", diags)
+	}
+	if err := c.call("textDocument/hover", c.at(0, 0), nil); err == nil || err.Code != CodeInvalidParams {
+		t.Errorf("hover on a closed document should fail, got %+v", err)
+	}
+	c.close(done)
+}
+
+func TestDefinitionAndHover(t *testing.T) {
+	c, done := newClient(t)
+	c.open(testSource)
+
+	tests := []struct {
+		line, character int
+		definition      *Range
+		hover           string
+	}{
+		// sum + len(s) 中的sum是自由变量
+		{2, 9, &Range{Position{1, 6}, Position{1, 9}}, "let sum\n```\nfree variable, captured from an enclosing function"},
+		{2, 15, nil, "builtin len\n```\nbuiltin function"},
+		{2, 19, &Range{Position{0, 4}, Position{0, 5}}, "let s\n```\nglobal variable"},
+		{1, 12, &Range{Position{0, 27}, Position{0, 28}}, "parameter a\n```\nlocal variable"},
+		{4, 1, &Range{Position{0, 18}, Position{0, 21}}, "let add = fn(a, b)\n```\nglobal variable"},
+		{4, 7, nil, ""},
+	}
+
+	for _, ts := range tests {
+		var loc *Location
+		c.call("textDocument/definition", c.at(ts.line, ts.character), &loc)
+		switch {
+		case ts.definition == nil && loc != nil:
+			t.Errorf("%d:%d: expected no definition, got %+v", ts.line, ts.character, loc)
+		case ts.definition != nil && (loc == nil || loc.URI != testURI || loc.Range != *ts.definition):
+			t.Errorf("%d:%d: wrong definition. want=%+v, got=%+v", ts.line, ts.character, ts.definition, loc)
+		}
+
+		var hover *Hover
+		c.call("textDocument/hover", c.at(ts.line, ts.character), &hover)
+		switch {
+		case ts.hover == "" && hover != nil:
+			t.Errorf("%d:%d: expected no hover, got %+v", ts.line, ts.character, hover)
+		case ts.hover != "" && (hover == nil || hover.Contents.Value != "```monkey\n"+ts.hover):
+			t.Errorf("%d:%d: wrong hover. want=%q, got=%+v", ts.line, ts.character, ts.hover, hover)
+		}
+	}
+	c.close(done)
+}
+
+func TestCompletionAndSymbols(t *testing.T) {
+	c, done := newClient(t)
+	c.open(testSource)
+
+	var items []CompletionItem
+	c.call("textDocument/completion", c.at(2, 9), &items)
+	labels := make(map[string]CompletionItem)
+	for _, item := range items {
+		labels[item.Label] = item
+	}
+	for _, name := range []string{"sum", "a", "b", "add", "s", "args", "len", "puts", "map"} {
+		if _, ok := labels[name]; !ok {
+			t.Errorf("completion is missing %s", name)
+		}
+	}
+	if labels["add"].Kind != CompletionFunction || labels["sum"].Kind != CompletionVariable {
+		t.Errorf("wrong completion kinds: add=%+v sum=%+v", labels["add"], labels["sum"])
+	}
+	c.call("textDocument/completion", c.at(0, 0), &items)
+	for _, item := range items {
+		if item.Label == "s" || item.Label == "sum" {
+			t.Errorf("%s should not be visible before its definition", item.Label)
+		}
+	}
+
+	var symbols []DocumentSymbol
+	c.call("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: testURI}}, &symbols)
+	var got []string
+	for _, s := range symbols {
+		got = append(got, s.Name+" "+s.Detail)
+	}
+	if strings.Join(got, ", ") != "s let s, add let add = fn(a, b)" {
+		t.Errorf("wrong symbols: %v", got)
+	}
+	if symbols[1].Kind != SymbolFunction || symbols[1].Range != (Range{Position{0, 14}, Position{3, 1}}) {
+		t.Errorf("wrong symbol for add: %+v", symbols[1])
+	}
+
+	if err := c.call("textDocument/formatting", map[string]any{}, nil); err == nil || err.Code != CodeMethodNotFound {
+		t.Errorf("unsupported methods should fail with MethodNotFound, got %+v", err)
+	}
+	c.close(done)
+}
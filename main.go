@@ -1,21 +1,447 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"myinterpreter/ast"
+	"myinterpreter/compiler"
+	"myinterpreter/dap"
+	"myinterpreter/debugger"
+	"myinterpreter/evaluator"
+	"myinterpreter/format"
+	"myinterpreter/lexer"
+	"myinterpreter/lsp"
+	"myinterpreter/object"
+	"myinterpreter/parser"
 	"myinterpreter/repl"
+	"myinterpreter/vm"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+const usage = `usage:
+  monkey [-engine vm|eval] [-O n] script.mk [args...]  run a script, same as 'monkey run'
+  monkey run [-engine vm|eval] [-O n] file [args...]   run a script or a .mkc bytecode file, '-' reads stdin
+  monkey repl [-engine vm|eval]                        start the REPL (default without arguments)
+  monkey build [-O n] [-o out.mkc] file.mk             compile a script to bytecode
+  monkey disasm [-O n] file.mk|file.mkc                print the bytecode of a script
+  monkey debug [-b LINE|FUNC] file.mk [args...]        run a script in the debugger, type help at the prompt
+  monkey dap                                           serve the Debug Adapter Protocol on stdin and stdout
+  monkey lsp                                           serve the Language Server Protocol on stdin and stdout
+  monkey fmt [-w] [files...]                           format scripts, stdin to stdout without files
+-O sets the optimization level: 0 none, 1 constant folding, 2 peephole (default)
+exit status: 0 ok, 1 runtime error, 2 usage error, 3 parse or compile error, n for exit(n)
+`
+
+// 进程退出码，脚本中调用exit(n)时以n退出
+const (
+	exitOK      = 0
+	exitRuntime = 1
+	exitUsage   = 2
+	exitCompile = 3
+)
+
+// usageError 表示命令行参数有误
+type usageError struct{ msg string }
+
+func (e *usageError) Error() string { return e.msg }
+
+func usagef(format string, a ...any) error {
+	return &usageError{msg: fmt.Sprintf(format, a...)}
+}
+
+// compileError 表示脚本没能通过解析、宏展开或编译，还没有开始运行
+type compileError struct{ err error }
+
+func (e *compileError) Error() string { return e.err.Error() }
+func (e *compileError) Unwrap() error { return e.err }
+
+var (
+	engine   = flag.String("engine", "vm", "execution engine, 'vm' or 'eval'")
+	optLevel = flag.Int("O", compiler.OptPeephole, "optimization level")
 )
 
 func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	args := flag.Args()
+
+	var err error
+	if len(args) < 1 {
+		err = startRepl(*engine)
+	} else {
+		switch args[0] {
+		case "run":
+			err = runCommand(args[1:])
+		case "repl":
+			err = replCommand(args[1:])
+		case "build":
+			err = buildCommand(args[1:])
+		case "disasm":
+			err = disasmCommand(args[1:])
+		case "fmt":
+			err = fmtCommand(args[1:])
+		case "debug":
+			err = debugCommand(args[1:])
+		case "dap":
+			err = dapCommand(args[1:])
+		case "lsp":
+			err = lspCommand(args[1:])
+		default:
+			err = runScript(args[0], args[1:], *engine, *optLevel)
+		}
+	}
+	os.Exit(report(err))
+}
+
+// report 把错误打印到标准错误并返回对应的退出码
+func report(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var exitErr *vm.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	var rtErr *vm.RuntimeError
+	if errors.As(err, &rtErr) {
+		fmt.Fprint(os.Stderr, rtErr.Traceback())
+		return exitRuntime
+	}
+	fmt.Fprintln(os.Stderr, err)
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	var compileErr *compileError
+	if errors.As(err, &compileErr) {
+		return exitCompile
+	}
+	return exitRuntime
+}
+
+func replCommand(args []string) error {
+	fs := flag.NewFlagSet("repl", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	engine := fs.String("engine", *engine, "execution engine, 'vm' or 'eval'")
+	if err := fs.Parse(args); err != nil {
+		return usagef("repl: %s", err)
+	}
+	if fs.NArg() != 0 {
+		return usagef("repl: unexpected arguments %s", strings.Join(fs.Args(), " "))
+	}
+	return startRepl(*engine)
+}
+
+func startRepl(engine string) error {
+	if engine != string(repl.EngineVM) && engine != string(repl.EngineEval) {
+		return usagef("unknown engine %s, use vm or eval", engine)
+	}
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
 	fmt.Printf("Hello %v! This is the Monkey programming language!\n", user.Username)
 	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	repl.StartWithEngine(os.Stdin, os.Stdout, repl.Engine(engine))
+	return nil
 }
 
-//let unless = macro(condition,consequence,alternative){quote(if(!(unquote(condition))){unquote(consequence);}else{unquote(alternative);});};
-//unless(10 > 5, puts("not greater"), puts("greater"));
+func runCommand(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	engine := fs.String("engine", *engine, "execution engine, 'vm' or 'eval'")
+	level := fs.Int("O", *optLevel, "optimization level")
+	if err := fs.Parse(args); err != nil {
+		return usagef("run: %s", err)
+	}
+	if fs.NArg() < 1 {
+		return usagef("run: expected a script")
+	}
+	return runScript(fs.Arg(0), fs.Args()[1:], *engine, *level)
+}
+
+// runScript 运行一个脚本，filename为"-"时从标准输入读取脚本。
+// 脚本的参数以字符串数组的形式放在全局变量args中
+func runScript(filename string, scriptArgs []string, engine string, level int) error {
+	argsArray := &object.Array{Elements: make([]object.Object, len(scriptArgs))}
+	for i, arg := range scriptArgs {
+		argsArray.Elements[i] = &object.String{Value: arg}
+	}
+
+	if filepath.Ext(filename) == ".mkc" {
+		bytecode, err := loadBytecode(filename)
+		if err != nil {
+			return err
+		}
+		return runBytecode(bytecode, argsArray)
+	}
+
+	switch engine {
+	case string(repl.EngineVM):
+		comp, err := compileFile(filename, level)
+		if err != nil {
+			return err
+		}
+		return runBytecode(comp.Bytecode(), argsArray)
+	case string(repl.EngineEval):
+		program, err := parseFile(filename)
+		if err != nil {
+			return err
+		}
+		env := object.NewEnvironment()
+		env.Set("args", argsArray)
+		switch res := evaluator.Eval(program, env).(type) {
+		case *object.Exit:
+			return &vm.ExitError{Code: int(res.Code)}
+		case *object.Error:
+			return errors.New(res.Message)
+		}
+		return nil
+	default:
+		return usagef("unknown engine %s, use vm or eval", engine)
+	}
+}
+
+// runBytecode 运行compileFile编译出的字节码，args总是第0个全局变量
+func runBytecode(bytecode *compiler.Bytecode, args *object.Array) error {
+	globals := make([]object.Object, vm.GlobalsSize)
+	globals[0] = args
+	return vm.NewWithGlobalsStore(bytecode, globals).Run()
+}
+
+// breakpointFlags 收集debug命令中多次出现的-b
+type breakpointFlags []string
+
+func (b *breakpointFlags) String() string { return strings.Join(*b, ",") }
+
+func (b *breakpointFlags) Set(v string) error {
+	*b = append(*b, v)
+	return nil
+}
+
+// debugCommand 在调试器中运行脚本，不做优化以便指令和源码行一一对应。
+// 没有用-b设置断点时在第一行暂停
+func debugCommand(args []string) error {
+	fs := flag.NewFlagSet("debug", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var breakpoints breakpointFlags
+	fs.Var(&breakpoints, "b", "breakpoint, LINE, FILE:LINE or a function name")
+	if err := fs.Parse(args); err != nil {
+		return usagef("debug: %s", err)
+	}
+	if fs.NArg() < 1 {
+		return usagef("debug: expected a script")
+	}
+	filename := fs.Arg(0)
+	comp, err := compileFile(filename, compiler.OptNone)
+	if err != nil {
+		return err
+	}
+
+	argsArray := &object.Array{}
+	for _, arg := range fs.Args()[1:] {
+		argsArray.Elements = append(argsArray.Elements, &object.String{Value: arg})
+	}
+	globals := make([]object.Object, vm.GlobalsSize)
+	globals[0] = argsArray
+	machine := vm.NewWithGlobalsStore(comp.Bytecode(), globals)
+
+	console := debugger.NewConsole(os.Stdin, os.Stdout)
+	d := debugger.New(machine, comp.SymbolTable().GlobalNames(), console.Stop)
+	d.StopOnEntry(len(breakpoints) == 0)
+	for _, bp := range breakpoints {
+		if file, line, ok := debugger.ParseLocation(bp); ok {
+			d.SetBreakpoint(file, line)
+		} else {
+			d.SetFunctionBreakpoint(bp)
+		}
+	}
+
+	err = machine.Run()
+	if errors.Is(err, debugger.ErrQuit) {
+		return nil
+	}
+	if err == nil {
+		fmt.Println("program finished")
+	}
+	return err
+}
+
+// dapCommand 通过标准输入输出和编辑器交换调试协议消息，要调试的脚本由launch请求指定
+func dapCommand(args []string) error {
+	if len(args) != 0 {
+		return usagef("dap: unexpected arguments %s", strings.Join(args, " "))
+	}
+	return dap.NewServer(os.Stdin, os.Stdout).Serve()
+}
+
+// lspCommand 通过标准输入输出为编辑器提供语言服务
+func lspCommand(args []string) error {
+	if len(args) != 0 {
+		return usagef("lsp: unexpected arguments %s", strings.Join(args, " "))
+	}
+	return lsp.NewServer(os.Stdin, os.Stdout).Serve()
+}
+
+func buildCommand(args []string) error {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	output := fs.String("o", "", "output file, defaults to the script name with a .mkc extension")
+	level := fs.Int("O", *optLevel, "optimization level")
+	if err := fs.Parse(args); err != nil {
+		return usagef("build: %s", err)
+	}
+	if fs.NArg() != 1 {
+		return usagef("build: expected exactly one script")
+	}
+	filename := fs.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".mkc"
+	}
+
+	comp, err := compileFile(filename, *level)
+	if err != nil {
+		return err
+	}
+	data, err := compiler.Marshal(comp.Bytecode())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*output, data, 0644)
+}
+
+func disasmCommand(args []string) error {
+	fs := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	level := fs.Int("O", *optLevel, "optimization level")
+	if err := fs.Parse(args); err != nil {
+		return usagef("disasm: %s", err)
+	}
+	if fs.NArg() != 1 {
+		return usagef("disasm: expected exactly one file")
+	}
+	filename := fs.Arg(0)
+
+	if filepath.Ext(filename) == ".mkc" {
+		bytecode, err := loadBytecode(filename)
+		if err != nil {
+			return err
+		}
+		compiler.Disassemble(os.Stdout, bytecode, nil)
+		return nil
+	}
+	comp, err := compileFile(filename, *level)
+	if err != nil {
+		return err
+	}
+	compiler.Disassemble(os.Stdout, comp.Bytecode(), comp.SymbolTable())
+	return nil
+}
+
+// fmtCommand 格式化脚本并输出到标准输出，-w时写回原文件。没有文件参数时格式化标准输入
+func fmtCommand(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	write := fs.Bool("w", false, "write the result back to the source files")
+	if err := fs.Parse(args); err != nil {
+		return usagef("fmt: %s", err)
+	}
+	if fs.NArg() == 0 {
+		if *write {
+			return usagef("fmt: -w needs files")
+		}
+		return formatFile("-", false)
+	}
+	for _, filename := range fs.Args() {
+		if err := formatFile(filename, *write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatFile(filename string, write bool) error {
+	src, err := readSource(filename)
+	if err != nil {
+		return err
+	}
+	formatted, err := format.Source(filename, src)
+	if err != nil {
+		return &compileError{err}
+	}
+	if write {
+		return os.WriteFile(filename, formatted, 0644)
+	}
+	_, err = os.Stdout.Write(formatted)
+	return err
+}
+
+// readSource 读取源文件，"-"表示标准输入
+func readSource(filename string) ([]byte, error) {
+	var src []byte
+	var err error
+	if filename == "-" {
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		src, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, usagef("%s", err)
+	}
+	return src, nil
+}
+
+// parseFile 解析一个源文件并展开其中的宏，语法错误会一次全部返回
+func parseFile(filename string) (*ast.Program, error) {
+	src, err := readSource(filename)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.New(lexer.NewWithFilename(filename, string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &compileError{errors.New(strings.TrimSuffix(parser.RenderDiagnostics(p.Diagnostics(), string(src)), "\n"))}
+	}
+	program, err = evaluator.DefineAndExpandMacros(program, object.NewEnvironment())
+	if err != nil {
+		return nil, &compileError{fmt.Errorf("%s: %w", filename, err)}
+	}
+	return program, nil
+}
+
+// compileFile 解析并编译一个源文件。args在编译前定义，总是第0个全局变量
+func compileFile(filename string, level int) (*compiler.Compiler, error) {
+	program, err := parseFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	symbols := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbols.DefineBuiltin(i, v.Name)
+	}
+	symbols.Define("args")
+	comp := compiler.NewWithState(symbols, []object.Object{}, compiler.WithOptimization(level))
+	err = comp.Compile(program)
+	if err != nil {
+		return nil, &compileError{err}
+	}
+	return comp, nil
+}
+
+func loadBytecode(filename string) (*compiler.Bytecode, error) {
+	data, err := readSource(filename)
+	if err != nil {
+		return nil, err
+	}
+	bytecode, err := compiler.Unmarshal(data)
+	if err != nil {
+		return nil, &compileError{fmt.Errorf("%s: %w", filename, err)}
+	}
+	return bytecode, nil
+}
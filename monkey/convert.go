@@ -0,0 +1,113 @@
+package monkey
+
+import (
+	"fmt"
+	"math"
+	"myinterpreter/object"
+	"myinterpreter/vm"
+	"reflect"
+)
+
+// FromGo 把Go值转换成Monkey对象:
+//
+//   - nil为null，bool、各种整数、浮点数和string转换成对应的对象
+//   - slice和数组转换成Monkey数组，map转换成hash，key必须能作为hash的key
+//   - object.Object原样返回
+//
+// 布尔值和null使用虚拟机中的单例，脚本中的==比较的是对象本身
+func FromGo(value any) (object.Object, error) {
+	if value == nil {
+		return vm.Null, nil
+	}
+	if obj, ok := value.(object.Object); ok {
+		return obj, nil
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return vm.True, nil
+		}
+		return vm.False, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("cannot convert %s %d to a Monkey integer", v.Type(), v.Uint())
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			elem, err := FromGo(v.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = elem
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		pairs := make(map[object.HashKey]object.HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := FromGo(iter.Key().Interface())
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := key.(object.Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			value, err := FromGo(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+			pairs[hashable.HashKey()] = object.HashPair{Key: key, Value: value}
+		}
+		return &object.Hash{Pairs: pairs}, nil
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return vm.Null, nil
+		}
+		return FromGo(v.Elem().Interface())
+	}
+	return nil, fmt.Errorf("cannot convert %T to a Monkey value", value)
+}
+
+// ToGo 把Monkey对象转换成Go值，是FromGo的逆过程:
+// 整数为int64，浮点数为float64，数组为[]any，hash为map[any]any，null为nil。
+// 函数等没有对应Go类型的对象原样返回
+func ToGo(obj object.Object) any {
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return nil
+	case *object.Integer:
+		return obj.Value
+	case *object.Float:
+		return obj.Value
+	case *object.Boolean:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Array:
+		elements := make([]any, len(obj.Elements))
+		for i, elem := range obj.Elements {
+			elements[i] = ToGo(elem)
+		}
+		return elements
+	case *object.Hash:
+		m := make(map[any]any, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			m[ToGo(pair.Key)] = ToGo(pair.Value)
+		}
+		return m
+	case *object.Cell:
+		return ToGo(obj.Value)
+	}
+	return obj
+}
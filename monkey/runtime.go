@@ -0,0 +1,249 @@
+// Package monkey 是在Go程序中嵌入Monkey的入口。
+//
+// 每个Runtime有自己的符号表、全局变量和内置函数表，多个Runtime可以在同一个进程中共存:
+//
+//	rt := monkey.New()
+//	rt.RegisterFunc("double", func(args ...any) (any, error) {
+//		return args[0].(int64) * 2, nil
+//	})
+//	rt.SetValue("limit", 10)
+//	result, err := rt.Run("double(limit)") // int64(20)
+package monkey
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"myinterpreter/ast"
+	"myinterpreter/compiler"
+	"myinterpreter/evaluator"
+	"myinterpreter/lexer"
+	"myinterpreter/object"
+	"myinterpreter/parser"
+	"myinterpreter/vm"
+	"strings"
+)
+
+// Function 是宿主程序注册给脚本调用的Go函数，参数和返回值按ToGo和FromGo转换。
+// 返回的error在脚本中是一个错误对象
+type Function func(args ...any) (any, error)
+
+// Runtime 保存一次嵌入会话的全部状态，多次Run之间共享全局变量，类似REPL。
+// Runtime不能被多个goroutine同时使用
+type Runtime struct {
+	symbols   *compiler.SymbolTable
+	constants []object.Object
+	globals   []object.Object
+	builtins  []*object.Builtin
+	macroEnv  *object.Environment
+	opts      []compiler.Option
+	limits    object.Limits
+	stdio     object.IO
+}
+
+// Option 配置Runtime
+type Option func(*Runtime)
+
+// WithOptimization 设置编译时的优化级别，取值见compiler.OptNone等常量
+func WithOptimization(level int) Option {
+	return func(r *Runtime) {
+		r.opts = append(r.opts, compiler.WithOptimization(level))
+	}
+}
+
+// WithLimits 限制每次Run和Call可以使用的资源
+func WithLimits(limits object.Limits) Option {
+	return func(r *Runtime) {
+		r.limits = limits
+	}
+}
+
+// WithIO 设置脚本中puts的输出和readline的输入，默认是进程的标准输入输出。
+// in或out为nil时readline总是返回null，puts的输出被丢弃
+func WithIO(in io.Reader, out io.Writer) Option {
+	return func(r *Runtime) {
+		r.stdio = object.IO{Out: out}
+		if in != nil {
+			reader, ok := in.(*bufio.Reader)
+			if !ok {
+				reader = bufio.NewReader(in)
+			}
+			r.stdio.In = reader
+		}
+	}
+}
+
+func New(opts ...Option) *Runtime {
+	r := &Runtime{
+		symbols:   compiler.NewSymbolTable(),
+		constants: []object.Object{},
+		globals:   make([]object.Object, vm.GlobalsSize),
+		builtins:  vm.DefaultBuiltins(),
+		macroEnv:  object.NewEnvironment(),
+		stdio:     object.StdIO(),
+	}
+	for i, def := range object.Builtins {
+		r.symbols.DefineBuiltin(i, def.Name)
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.macroEnv.SetIO(r.stdio)
+	return r
+}
+
+// RegisterBuiltin 注册一个内置函数，和标准内置函数一样可以通过ctx回调脚本中的函数。
+// 已经存在的同名函数或变量会被遮蔽
+func (r *Runtime) RegisterBuiltin(name string, fn object.BuiltinFunction) {
+	r.builtins = append(r.builtins, &object.Builtin{Fn: fn})
+	r.symbols.DefineBuiltin(len(r.builtins)-1, name)
+}
+
+// RegisterFunc 注册一个参数和返回值都是Go值的函数
+func (r *Runtime) RegisterFunc(name string, fn Function) {
+	r.RegisterBuiltin(name, func(ctx object.CallContext, args ...object.Object) object.Object {
+		goArgs := make([]any, len(args))
+		for i, arg := range args {
+			goArgs[i] = ToGo(arg)
+		}
+		res, err := fn(goArgs...)
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("%s: %s", name, err)}
+		}
+		obj, err := FromGo(res)
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("%s: %s", name, err)}
+		}
+		return obj
+	})
+}
+
+// SetValue 把一个Go值定义成全局变量
+func (r *Runtime) SetValue(name string, value any) error {
+	obj, err := FromGo(value)
+	if err != nil {
+		return err
+	}
+	symbol := r.symbols.Define(name)
+	r.globals[symbol.Index] = obj
+	return nil
+}
+
+// Get 读取全局变量或内置函数，结果按ToGo转换
+func (r *Runtime) Get(name string) (any, bool) {
+	obj, ok := r.lookup(name)
+	if !ok {
+		return nil, false
+	}
+	return ToGo(obj), true
+}
+
+func (r *Runtime) lookup(name string) (object.Object, bool) {
+	symbol, ok := r.symbols.Resolve(name)
+	if !ok {
+		return nil, false
+	}
+	switch symbol.Scope {
+	case compiler.GlobalScope:
+		return r.globals[symbol.Index], true
+	case compiler.BuiltinScope:
+		return r.builtins[symbol.Index], true
+	}
+	return nil, false
+}
+
+// Run 编译并执行一段源码，返回最后一个表达式语句的值。
+// 脚本调用exit时返回*vm.ExitError，最后的值是错误对象时返回error
+func (r *Runtime) Run(src string) (any, error) {
+	return r.RunContext(context.Background(), "", src)
+}
+
+// RunFile 和Run相同，filename只用在错误信息中
+func (r *Runtime) RunFile(filename, src string) (any, error) {
+	return r.RunContext(context.Background(), filename, src)
+}
+
+// RunContext 和RunFile相同，ctx取消或超时时停止执行
+func (r *Runtime) RunContext(ctx context.Context, filename, src string) (any, error) {
+	p := parser.New(lexer.NewWithFilename(filename, src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(strings.TrimSuffix(parser.RenderDiagnostics(p.Diagnostics(), src), "\n"))
+	}
+	//宏在展开时执行，和脚本一样受ctx和limits的限制
+	program, err := evaluator.DefineAndExpandMacrosContext(ctx, program, r.macroEnv, r.limits)
+	if err != nil {
+		return nil, err
+	}
+
+	//在符号表的副本上编译，编译或运行失败时新定义的变量不会留下来，
+	//否则它们的全局变量槽没有赋值，之后使用时虚拟机会读到nil
+	symbols := r.symbols.Clone()
+	comp := compiler.NewWithState(symbols, r.constants, r.opts...)
+	err = comp.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+	bytecode := comp.Bytecode()
+	r.constants = bytecode.Constants
+
+	machine := vm.NewWithState(bytecode, r.globals, r.builtins)
+	machine.SetLimits(r.limits)
+	machine.SetIO(r.stdio)
+	err = machine.RunContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.symbols = symbols
+	if !endsWithExpression(program) {
+		return nil, nil
+	}
+	result := machine.LastPoppedStackElem()
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, errors.New(errObj.Message)
+	}
+	return ToGo(result), nil
+}
+
+// endsWithExpression 判断程序最后是不是表达式语句，只有这时栈上最后弹出的才是程序的值
+func endsWithExpression(program *ast.Program) bool {
+	if len(program.Statements) == 0 {
+		return false
+	}
+	_, ok := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+// Call 调用脚本中定义的全局函数或者内置函数
+func (r *Runtime) Call(name string, args ...any) (any, error) {
+	fn, ok := r.lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("undefined: %s", name)
+	}
+	objs := make([]object.Object, len(args))
+	for i, arg := range args {
+		obj, err := FromGo(arg)
+		if err != nil {
+			return nil, err
+		}
+		objs[i] = obj
+	}
+
+	machine := vm.NewWithState(&compiler.Bytecode{Constants: r.constants}, r.globals, r.builtins)
+	machine.SetLimits(r.limits)
+	machine.SetIO(r.stdio)
+	err := machine.RunContext(context.Background()) //没有指令要执行，只是按限制创建额度
+	if err != nil {
+		return nil, err
+	}
+	result, err := machine.Call(fn, objs...)
+	if err != nil {
+		return nil, err
+	}
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, errors.New(errObj.Message)
+	}
+	return ToGo(result), nil
+}
@@ -0,0 +1,267 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"math"
+	"myinterpreter/object"
+	"myinterpreter/vm"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"1 + 2", int64(3)},
+		{"1.5 * 2", 3.0},
+		{`"a" + "b"`, "ab"},
+		{"1 < 2", true},
+		{"[1, [2, 3]]", []any{int64(1), []any{int64(2), int64(3)}}},
+		{`{"a": 1, 2: first([])}`, map[any]any{"a": int64(1), int64(2): nil}},
+		{"let x = 1;", nil},
+		{"map([1, 2], fn(x) { x * 10 })", []any{int64(10), int64(20)}},
+	}
+
+	for _, ts := range tests {
+		res, err := New().Run(ts.input)
+		if err != nil {
+			t.Fatalf("run %q: %s", ts.input, err)
+		}
+		if !reflect.DeepEqual(res, ts.expected) {
+			t.Errorf("run %q: want=%#v, got=%#v", ts.input, ts.expected, res)
+		}
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	rt := New()
+	rt.RegisterFunc("join", func(args ...any) (any, error) {
+		parts := make([]string, len(args))
+		for i, arg := range args {
+			s, ok := arg.(string)
+			if !ok {
+				return nil, errors.New("arguments must be strings")
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, ","), nil
+	})
+	rt.RegisterFunc("pair", func(args ...any) (any, error) {
+		return []any{args[0], true}, nil
+	})
+
+	res, err := rt.Run(`join("a", "b", "c")`)
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if res != "a,b,c" {
+		t.Errorf("wrong result. got=%#v", res)
+	}
+
+	//宿主函数返回的布尔值和脚本中的true是同一个对象
+	res, err = rt.Run(`pair(1)[1] == true`)
+	if err != nil || res != true {
+		t.Errorf("wrong result. got=%#v, err=%v", res, err)
+	}
+
+	//可以在脚本中定义的函数里调用，也可以作为参数传给高阶函数
+	res, err = rt.Run(`let f = fn(x) { join(x, "!") }; map(["x", "y"], f)`)
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if !reflect.DeepEqual(res, []any{"x,!", "y,!"}) {
+		t.Errorf("wrong result. got=%#v", res)
+	}
+
+	_, err = rt.Run(`join(1)`)
+	if err == nil || err.Error() != "join: arguments must be strings" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	rt := New()
+	rt.RegisterBuiltin("twice", func(ctx object.CallContext, args ...object.Object) object.Object {
+		return ctx.Call(args[0], ctx.Call(args[0], args[1]))
+	})
+	res, err := rt.Run(`twice(fn(x) { x * 3 }, 2)`)
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if res != int64(18) {
+		t.Errorf("wrong result. got=%#v", res)
+	}
+}
+
+func TestValuesAndCall(t *testing.T) {
+	rt := New()
+	err := rt.SetValue("config", map[string]any{"scale": 3, "names": []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("SetValue error: %s", err)
+	}
+	_, err = rt.Run(`let scale = fn(x) { x * config["scale"] }; let count = len(config["names"]);`)
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+
+	count, ok := rt.Get("count")
+	if !ok || count != int64(2) {
+		t.Errorf("wrong count. got=%#v", count)
+	}
+	if _, ok := rt.Get("missing"); ok {
+		t.Errorf("expected missing to be undefined")
+	}
+
+	res, err := rt.Call("scale", 5)
+	if err != nil {
+		t.Fatalf("call error: %s", err)
+	}
+	if res != int64(15) {
+		t.Errorf("wrong result. got=%#v", res)
+	}
+	res, err = rt.Call("len", "abcd")
+	if err != nil || res != int64(4) {
+		t.Errorf("wrong result. got=%#v, err=%v", res, err)
+	}
+	if _, err := rt.Call("scale", "x"); err == nil {
+		t.Errorf("expected runtime error")
+	}
+	if _, err := rt.Call("nothing"); err == nil || err.Error() != "undefined: nothing" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+	if err := rt.SetValue("bad", func() {}); err == nil {
+		t.Errorf("expected conversion error")
+	}
+	if err := rt.SetValue("big", uint64(math.MaxUint64)); err == nil || err.Error() != "cannot convert uint64 18446744073709551615 to a Monkey integer" {
+		t.Errorf("wrong conversion error. got=%v", err)
+	}
+	if err := rt.SetValue("max", uint64(math.MaxInt64)); err != nil {
+		t.Errorf("SetValue error: %s", err)
+	}
+	if max, _ := rt.Get("max"); max != int64(math.MaxInt64) {
+		t.Errorf("wrong max. got=%#v", max)
+	}
+}
+
+func TestRuntimesAreIndependent(t *testing.T) {
+	a, b := New(), New()
+	a.RegisterFunc("name", func(args ...any) (any, error) { return "a", nil })
+	b.RegisterFunc("name", func(args ...any) (any, error) { return "b", nil })
+	a.SetValue("x", 1)
+
+	res, err := a.Run("name()")
+	if err != nil || res != "a" {
+		t.Errorf("wrong result from a. got=%#v, err=%v", res, err)
+	}
+	res, err = b.Run("name()")
+	if err != nil || res != "b" {
+		t.Errorf("wrong result from b. got=%#v, err=%v", res, err)
+	}
+	if _, err := b.Run("x"); err == nil {
+		t.Errorf("x should not be defined in b")
+	}
+	//标准内置函数不受影响
+	if _, err := New().Run("name()"); err == nil {
+		t.Errorf("name should not be defined in a new runtime")
+	}
+}
+
+func TestWithIO(t *testing.T) {
+	var outA, outB strings.Builder
+	a := New(WithIO(strings.NewReader("first\nsecond\n"), &outA))
+	b := New(WithIO(strings.NewReader("other"), &outB))
+
+	res, err := a.Run(`let m = macro() { puts("expanding"); quote(1) }; puts(readline()); m()`)
+	if err != nil || res != int64(1) {
+		t.Fatalf("wrong result from a. got=%#v, err=%v", res, err)
+	}
+	if _, err := b.Run(`let echo = fn() { puts(readline(), readline()) }`); err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if _, err := b.Call("echo"); err != nil {
+		t.Fatalf("call error: %s", err)
+	}
+	res, err = a.Run("readline()")
+	if err != nil || res != "second" {
+		t.Errorf("readline should continue from the same input. got=%#v, err=%v", res, err)
+	}
+	if outA.String() != "expanding\nfirst\n" {
+		t.Errorf("wrong output from a: %q", outA.String())
+	}
+	if outB.String() != "other\nnull\n" {
+		t.Errorf("wrong output from b: %q", outB.String())
+	}
+
+	res, err = New(WithIO(nil, nil)).Run(`puts("dropped"); readline()`)
+	if err != nil || res != nil {
+		t.Errorf("readline without input should return null. got=%#v, err=%v", res, err)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	rt := New()
+	_, err := rt.Run("let = 1;")
+	if err == nil || !strings.Contains(err.Error(), "error[P001]") {
+		t.Errorf("expected parser diagnostics. got=%v", err)
+	}
+	_, err = rt.RunFile("calc.mk", "1 + true")
+	var rtErr *vm.RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Pos.Filename != "calc.mk" {
+		t.Errorf("expected runtime error in calc.mk. got=%v", err)
+	}
+	//编译或运行失败的let不会留下没有赋值的变量
+	if _, err := rt.Run("let y = undefinedthing;"); err == nil {
+		t.Errorf("expected compiler error")
+	}
+	if _, err := rt.Run("let z = 1 + true;"); err == nil {
+		t.Errorf("expected runtime error")
+	}
+	for _, name := range []string{"y", "z"} {
+		_, err = rt.Run(name + " + 1")
+		if err == nil || err.Error() != "1:1: undefined variable "+name {
+			t.Errorf("wrong error for %s. got=%v", name, err)
+		}
+	}
+	_, err = rt.Run("exit(4)")
+	var exit *vm.ExitError
+	if !errors.As(err, &exit) || exit.Code != 4 {
+		t.Errorf("expected exit status 4. got=%v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	rt := New(WithLimits(object.Limits{MaxInstructions: 10000}))
+	_, err := rt.Run("let spin = fn() { while (true) { } }; 1")
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	var limitErr *object.LimitError
+	if _, err := rt.Run("spin()"); !errors.As(err, &limitErr) || limitErr.Limit != object.LimitInstructions {
+		t.Errorf("expected instruction limit error. got=%v", err)
+	}
+	if _, err := rt.Call("spin"); !errors.As(err, &limitErr) {
+		t.Errorf("expected instruction limit error from Call. got=%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := New().RunContext(ctx, "", "while (true) { }"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context canceled. got=%v", err)
+	}
+
+	//宏展开时执行的代码也受限制
+	spinMacro := "let m = macro() { while (true) { }; quote(1) }; m();"
+	if _, err := rt.Run(spinMacro); !errors.As(err, &limitErr) || limitErr.Limit != object.LimitInstructions {
+		t.Errorf("expected instruction limit error from macro expansion. got=%v", err)
+	}
+	if _, err := New().RunContext(ctx, "", spinMacro); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context canceled from macro expansion. got=%v", err)
+	}
+	if res, err := rt.Run("let one = macro() { quote(1) }; one() + 1"); err != nil || res != int64(2) {
+		t.Errorf("macros should still expand. got=%#v, err=%v", res, err)
+	}
+}
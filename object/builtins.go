@@ -1,6 +1,27 @@
 package object
 
-import "fmt"
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// IO 是puts和readline使用的输出和输入，由执行内置函数的虚拟机或解释器通过CallContext提供。
+// Out为nil时丢弃输出，In为nil时readline返回null
+type IO struct {
+	In  *bufio.Reader
+	Out io.Writer
+}
+
+// stdio 是进程的标准输入输出。os.Stdin只能用一个bufio.Reader读取，否则缓冲的输入会丢失
+var stdio = IO{In: bufio.NewReader(os.Stdin), Out: os.Stdout}
+
+// StdIO 返回进程的标准输入输出，没有设置IO的虚拟机和解释器使用它
+func StdIO() IO {
+	return stdio
+}
 
 var Builtins = []struct {
 	Name    string
@@ -9,7 +30,7 @@ var Builtins = []struct {
 	{
 		"len",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx CallContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1", len(args))
 				}
@@ -28,9 +49,13 @@ var Builtins = []struct {
 	{
 		"puts",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(ctx CallContext, args ...Object) Object {
+				out := ctx.IO().Out
+				if out == nil {
+					return nil
+				}
 				for _, arg := range args {
-					fmt.Println(arg.Inspect())
+					fmt.Fprintln(out, arg.Inspect())
 				}
 				return nil
 			},
@@ -39,7 +64,7 @@ var Builtins = []struct {
 	{
 		"first",
 		&Builtin{
-			func(args ...Object) Object {
+			func(ctx CallContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1",
 						len(args))
@@ -58,7 +83,7 @@ var Builtins = []struct {
 	{
 		"last",
 		&Builtin{
-			func(args ...Object) Object {
+			func(ctx CallContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1",
 						len(args))
@@ -78,7 +103,7 @@ var Builtins = []struct {
 	{
 		"rest",
 		&Builtin{
-			func(args ...Object) Object {
+			func(ctx CallContext, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1",
 						len(args))
@@ -100,7 +125,7 @@ var Builtins = []struct {
 	{
 		"push",
 		&Builtin{
-			func(args ...Object) Object {
+			func(ctx CallContext, args ...Object) Object {
 				if len(args) != 2 {
 					return newError("wrong number of arguments. got=%d, want=2",
 						len(args))
@@ -117,6 +142,53 @@ var Builtins = []struct {
 			},
 		},
 	},
+	{
+		"exit",
+		&Builtin{
+			func(ctx CallContext, args ...Object) Object {
+				if len(args) > 1 {
+					return newError("wrong number of arguments. got=%d, want=0 or 1",
+						len(args))
+				}
+				if len(args) == 0 {
+					return &Exit{Code: 0}
+				}
+				code, ok := args[0].(*Integer)
+				if !ok {
+					return newError("argument to `exit` must be INTEGER, got %s", args[0].Type())
+				}
+				return &Exit{Code: code.Value}
+			},
+		},
+	},
+	{
+		"readline",
+		&Builtin{
+			func(ctx CallContext, args ...Object) Object {
+				if len(args) != 0 {
+					return newError("wrong number of arguments. got=%d, want=0",
+						len(args))
+				}
+				in := ctx.IO().In
+				if in == nil {
+					return nil
+				}
+				line, err := in.ReadString('\n')
+				if err != nil && (err != io.EOF || line == "") {
+					return nil //输入结束时返回null
+				}
+				line = strings.TrimSuffix(line, "\n")
+				return &String{Value: strings.TrimSuffix(line, "\r")}
+			},
+		},
+	},
+	{"map", &Builtin{Fn: builtinMap}},
+	{"filter", &Builtin{Fn: builtinFilter}},
+	{"reduce", &Builtin{Fn: builtinReduce}},
+	{"each", &Builtin{Fn: builtinEach}},
+	{"any", &Builtin{Fn: builtinAny}},
+	{"all", &Builtin{Fn: builtinAll}},
+	{"sort_by", &Builtin{Fn: builtinSortBy}},
 }
 
 func GetBuiltinByName(name string) *Builtin {
@@ -1,8 +1,12 @@
 package object
 
+import "sort"
+
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store  map[string]Object
+	outer  *Environment
+	budget *Budget //只在最外层的环境中设置
+	io     *IO     //只在最外层的环境中设置，nil表示使用StdIO
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
@@ -28,3 +32,60 @@ func (e *Environment) Set(name string, value Object) Object {
 	e.store[name] = value
 	return value
 }
+
+// Assign 修改已经存在的绑定，沿着外层环境查找name，找不到时返回false
+func (e *Environment) Assign(name string, value Object) (Object, bool) {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = value
+		return value, true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, value)
+	}
+	return nil, false
+}
+
+// Names 返回当前环境(不包括外层环境)中按字母排序的全部名字
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Budget 返回最外层环境中设置的执行限额，没有设置时返回nil
+func (e *Environment) Budget() *Budget {
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e.budget
+}
+
+// SetBudget 在最外层环境中设置执行限额，所有内层环境共用
+func (e *Environment) SetBudget(b *Budget) {
+	for e.outer != nil {
+		e = e.outer
+	}
+	e.budget = b
+}
+
+// IO 返回最外层环境中设置的输入输出，没有设置时返回StdIO
+func (e *Environment) IO() IO {
+	for e.outer != nil {
+		e = e.outer
+	}
+	if e.io == nil {
+		return StdIO()
+	}
+	return *e.io
+}
+
+// SetIO 在最外层环境中设置puts和readline使用的输入输出，所有内层环境共用
+func (e *Environment) SetIO(io IO) {
+	for e.outer != nil {
+		e = e.outer
+	}
+	e.io = &io
+}
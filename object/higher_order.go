@@ -0,0 +1,201 @@
+package object
+
+import (
+	"sort"
+)
+
+// 高阶内置函数，通过CallContext回调作为参数传入的函数
+
+// builtinMap map(arr, fn) 返回由fn(elem)组成的新数组
+func builtinMap(ctx CallContext, args ...Object) Object {
+	arr, fn, err := arrayAndFunction("map", args)
+	if err != nil {
+		return err
+	}
+	result := make([]Object, len(arr.Elements))
+	for i, elem := range arr.Elements {
+		res := ctx.Call(fn, elem)
+		if isError(res) {
+			return res
+		}
+		result[i] = res
+	}
+	return &Array{Elements: result}
+}
+
+// builtinFilter filter(arr, fn) 返回fn(elem)为真的元素
+func builtinFilter(ctx CallContext, args ...Object) Object {
+	arr, fn, err := arrayAndFunction("filter", args)
+	if err != nil {
+		return err
+	}
+	result := []Object{}
+	for _, elem := range arr.Elements {
+		res := ctx.Call(fn, elem)
+		if isError(res) {
+			return res
+		}
+		if isTruthy(res) {
+			result = append(result, elem)
+		}
+	}
+	return &Array{Elements: result}
+}
+
+// builtinReduce reduce(arr, initial, fn) 从initial开始依次计算acc = fn(acc, elem)
+func builtinReduce(ctx CallContext, args ...Object) Object {
+	if len(args) != 3 {
+		return newError("wrong number of arguments. got=%d, want=3", len(args))
+	}
+	arr, fn, err := arrayAndFunction("reduce", []Object{args[0], args[2]})
+	if err != nil {
+		return err
+	}
+	acc := args[1]
+	for _, elem := range arr.Elements {
+		acc = ctx.Call(fn, acc, elem)
+		if isError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
+
+// builtinEach each(arr, fn) 对每个元素调用fn，返回null
+func builtinEach(ctx CallContext, args ...Object) Object {
+	arr, fn, err := arrayAndFunction("each", args)
+	if err != nil {
+		return err
+	}
+	for _, elem := range arr.Elements {
+		res := ctx.Call(fn, elem)
+		if isError(res) {
+			return res
+		}
+	}
+	return nil
+}
+
+// builtinAny any(arr, fn) 有一个元素使fn(elem)为真时返回true，找到后不再调用fn
+func builtinAny(ctx CallContext, args ...Object) Object {
+	arr, fn, err := arrayAndFunction("any", args)
+	if err != nil {
+		return err
+	}
+	for _, elem := range arr.Elements {
+		res := ctx.Call(fn, elem)
+		if isError(res) {
+			return res
+		}
+		if isTruthy(res) {
+			return &Boolean{Value: true}
+		}
+	}
+	return &Boolean{Value: false}
+}
+
+// builtinAll all(arr, fn) 所有元素都使fn(elem)为真时返回true，空数组返回true
+func builtinAll(ctx CallContext, args ...Object) Object {
+	arr, fn, err := arrayAndFunction("all", args)
+	if err != nil {
+		return err
+	}
+	for _, elem := range arr.Elements {
+		res := ctx.Call(fn, elem)
+		if isError(res) {
+			return res
+		}
+		if !isTruthy(res) {
+			return &Boolean{Value: false}
+		}
+	}
+	return &Boolean{Value: true}
+}
+
+// builtinSortBy sort_by(arr, fn) 按fn(elem)的结果稳定排序，返回新数组。
+// 先算出全部排序键再排序，每个元素只调用一次fn。排序键必须都是数字或者都是字符串
+func builtinSortBy(ctx CallContext, args ...Object) Object {
+	arr, fn, err := arrayAndFunction("sort_by", args)
+	if err != nil {
+		return err
+	}
+	keys := make([]Object, len(arr.Elements))
+	for i, elem := range arr.Elements {
+		key := ctx.Call(fn, elem)
+		if isError(key) {
+			return key
+		}
+		keys[i] = key
+	}
+
+	var less func(a, b Object) bool
+	switch {
+	case allOf(keys, isNumeric):
+		less = func(a, b Object) bool {
+			c, ok := CompareNumbers(a, b)
+			return ok && c < 0
+		}
+	case allOf(keys, func(obj Object) bool { return obj.Type() == STRING_OBJ }):
+		less = func(a, b Object) bool { return a.(*String).Value < b.(*String).Value }
+	default:
+		return newError("sort_by keys must be all numbers or all strings")
+	}
+
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return less(keys[order[i]], keys[order[j]])
+	})
+	result := make([]Object, len(order))
+	for i, idx := range order {
+		result[i] = arr.Elements[idx]
+	}
+	return &Array{Elements: result}
+}
+
+// arrayAndFunction 检查高阶函数的(数组, 函数)参数
+func arrayAndFunction(name string, args []Object) (*Array, Object, *Error) {
+	if len(args) != 2 {
+		return nil, nil, newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return nil, nil, newError("first argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+	switch args[1].Type() {
+	case CLOSURE_OBJ, FUNCTION_OBJ, BUILTIN_OBJ:
+	default:
+		return nil, nil, newError("second argument to `%s` must be a function, got %s", name, args[1].Type())
+	}
+	return arr, args[1], nil
+}
+
+func isError(obj Object) bool {
+	return obj != nil && (obj.Type() == ERROR_OBJ || obj.Type() == EXIT_OBJ)
+}
+
+// isTruthy 和虚拟机、解释器一致，只有false和null为假。内置函数返回的nil也表示null
+func isTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case nil, *Null:
+		return false
+	case *Boolean:
+		return obj.Value
+	}
+	return true
+}
+
+func isNumeric(obj Object) bool {
+	return obj.Type() == INTEGER_OBJ || obj.Type() == FLOAT_OBJ
+}
+
+func allOf(objs []Object, pred func(Object) bool) bool {
+	for _, obj := range objs {
+		if !pred(obj) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package object
+
+import "sort"
+
+// Iterator 用于for-in循环，依次产出数组元素、hash的key(按key排序)或字符串中的字符
+type Iterator struct {
+	Elements []Object
+	index    int
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+
+func (it *Iterator) Inspect() string { return "iterator" }
+
+func (it *Iterator) Next() (Object, bool) {
+	if it.index >= len(it.Elements) {
+		return nil, false
+	}
+	elem := it.Elements[it.index]
+	it.index++
+	return elem, true
+}
+
+func NewIterator(obj Object) (*Iterator, bool) {
+	switch obj := obj.(type) {
+	case *Array:
+		return &Iterator{Elements: obj.Elements}, true
+	case *Hash:
+		return &Iterator{Elements: obj.SortedKeys()}, true
+	case *String:
+		chars := []Object{}
+		for _, ch := range obj.Value {
+			chars = append(chars, &String{Value: string(ch)})
+		}
+		return &Iterator{Elements: chars}, true
+	default:
+		return nil, false
+	}
+}
+
+// SortedKeys 返回排序后的key，保证遍历hash的顺序是确定的
+func (h *Hash) SortedKeys() []Object {
+	keys := make([]Object, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		keys = append(keys, pair.Key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return lessKey(keys[i], keys[j])
+	})
+	return keys
+}
+
+func lessKey(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		if b, ok := b.(*Integer); ok {
+			return a.Value < b.Value
+		}
+	case *Float:
+		if b, ok := b.(*Float); ok {
+			return a.Value < b.Value
+		}
+	case *String:
+		if b, ok := b.(*String); ok {
+			return a.Value < b.Value
+		}
+	}
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+	return a.Inspect() < b.Inspect()
+}
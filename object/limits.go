@@ -0,0 +1,159 @@
+package object
+
+import (
+	"context"
+	"fmt"
+)
+
+// Limits 限制一次执行可以使用的资源，虚拟机和解释器的含义相同。为0的字段表示不限制。
+// MaxMemory限制的是累计分配的字节数而不是存活对象占用的内存：没有垃圾回收的统计，
+// 已经不再使用的对象也一直计入，所以循环中反复创建临时对象也会超出限制
+type Limits struct {
+	MaxInstructions int64 // 虚拟机执行的指令数，解释器求值的语法树节点数
+	MaxCallDepth    int   // 函数调用嵌套的层数，内置函数不算
+	MaxAllocations  int64 // 运行时创建的数组、hash、字符串和函数的个数，常量不算
+	MaxMemory       int64 // 这些对象和给hash新增的键累计分配的字节数，按SizeOf估算，不扣除已经回收的
+}
+
+// 超出的是哪一种限制
+const (
+	LimitInstructions = "instruction"
+	LimitCallDepth    = "call depth"
+	LimitAllocations  = "allocation"
+	LimitMemory       = "cumulative memory" // 累计分配的字节数，见Limits
+)
+
+// LimitError 是执行超出Limits时返回的错误，可以用errors.As从运行时错误中取出
+type LimitError struct {
+	Limit string
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded (max %d)", e.Limit, e.Max)
+}
+
+// checkInterval 每执行这么多步检查一次ctx是否已经取消，每一步都检查太慢
+const checkInterval = 1024
+
+// Budget 记录一次执行已经用掉的资源。nil的*Budget表示不做任何限制
+type Budget struct {
+	limits      Limits
+	ctx         context.Context
+	done        <-chan struct{}
+	steps       int64
+	depth       int
+	allocations int64
+	memory      int64
+}
+
+func NewBudget(ctx context.Context, limits Limits) *Budget {
+	return &Budget{limits: limits, ctx: ctx, done: ctx.Done()}
+}
+
+// Step 记录执行了一步。超出指令数时返回*LimitError，ctx取消或超时时返回ctx.Err()
+func (b *Budget) Step() error {
+	if b == nil {
+		return nil
+	}
+	b.steps++
+	if b.limits.MaxInstructions > 0 && b.steps > b.limits.MaxInstructions {
+		return &LimitError{Limit: LimitInstructions, Max: b.limits.MaxInstructions}
+	}
+	if b.done != nil && b.steps%checkInterval == 0 {
+		select {
+		case <-b.done:
+			return b.ctx.Err()
+		default:
+		}
+	}
+	return nil
+}
+
+// CheckDepth 检查调用深度为depth的函数是否允许执行
+func (b *Budget) CheckDepth(depth int) error {
+	if b == nil || b.limits.MaxCallDepth <= 0 || depth <= b.limits.MaxCallDepth {
+		return nil
+	}
+	return &LimitError{Limit: LimitCallDepth, Max: int64(b.limits.MaxCallDepth)}
+}
+
+// Enter 和Leave 供递归执行的解释器记录调用深度
+func (b *Budget) Enter() error {
+	if b == nil {
+		return nil
+	}
+	b.depth++
+	return b.CheckDepth(b.depth)
+}
+
+func (b *Budget) Leave() {
+	if b != nil {
+		b.depth--
+	}
+}
+
+// Allocate 记录运行时新建了obj，只计数组、hash、字符串和函数，其他对象直接忽略
+func (b *Budget) Allocate(obj Object) error {
+	if b == nil {
+		return nil
+	}
+	size, ok := SizeOf(obj)
+	if !ok {
+		return nil
+	}
+	b.allocations++
+	if b.limits.MaxAllocations > 0 && b.allocations > b.limits.MaxAllocations {
+		return &LimitError{Limit: LimitAllocations, Max: b.limits.MaxAllocations}
+	}
+	return b.grow(size)
+}
+
+// AddHashEntry 记录给已有的hash新增了一个键，hash变大但没有新建对象，所以只计入内存
+func (b *Budget) AddHashEntry() error {
+	if b == nil {
+		return nil
+	}
+	return b.grow(mapEntry)
+}
+
+func (b *Budget) grow(size int64) error {
+	b.memory += size
+	if b.limits.MaxMemory > 0 && b.memory > b.limits.MaxMemory {
+		return &LimitError{Limit: LimitMemory, Max: b.limits.MaxMemory}
+	}
+	return nil
+}
+
+// Memory 返回已经计入的字节数
+func (b *Budget) Memory() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.memory
+}
+
+// 估算对象大小用到的字节数，按64位平台上的结构体和切片头计算
+const (
+	objectHeader = 16 // 对象本身加上引用它的接口值
+	sliceHeader  = 24
+	mapEntry     = 64 // HashKey加HashPair，再加上map的额外开销
+)
+
+// SizeOf 估算运行时对象占用的内存，只包括对象自身，不包括元素引用的其他对象。
+// 第二个返回值表示obj是不是计入限额的类型
+func SizeOf(obj Object) (int64, bool) {
+	switch obj := obj.(type) {
+	case *String:
+		return objectHeader + int64(len(obj.Value)), true
+	case *Array:
+		return objectHeader + sliceHeader + objectHeader*int64(len(obj.Elements)), true
+	case *Hash:
+		return objectHeader + mapEntry*int64(len(obj.Pairs)), true
+	case *Closure:
+		return objectHeader + sliceHeader + 8*int64(len(obj.Free)), true
+	case *Function:
+		return objectHeader + sliceHeader + 8, true
+	}
+	return 0, false
+}
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"myinterpreter/ast"
 	"myinterpreter/code"
+	"strconv"
 	"strings"
 )
 
@@ -13,6 +15,7 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ           = "INTEGER"
+	FLOAT_OBJ             = "FLOAT"
 	BOOLEAN_OBJ           = "BOOLEAN"
 	NULL_OBJ              = "NULL"
 	RETURN_VALUE_OBJ      = "RETURN_VALUE"
@@ -26,6 +29,11 @@ const (
 	MACRO_OBJ             = "MACRO"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
 	CLOSURE_OBJ           = "CLOSURE"
+	BREAK_OBJ             = "BREAK"
+	CONTINUE_OBJ          = "CONTINUE"
+	ITERATOR_OBJ          = "ITERATOR"
+	CELL_OBJ              = "CELL"
+	EXIT_OBJ              = "EXIT"
 )
 
 type Object interface {
@@ -35,7 +43,7 @@ type Object interface {
 
 type Closure struct {
 	Fn   *CompiledFunction
-	Free []Object
+	Free []*Cell //捕获的自由变量，和定义闭包的作用域共享
 }
 
 func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
@@ -44,10 +52,38 @@ func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", c)
 }
 
+// Exit 是exit内置函数的返回值，解释器和虚拟机遇到它时停止执行并以Code退出
+type Exit struct {
+	Code int64
+}
+
+func (e *Exit) Type() ObjectType { return EXIT_OBJ }
+
+func (e *Exit) Inspect() string {
+	return fmt.Sprintf("exit(%d)", e.Code)
+}
+
+// Cell 保存被闭包捕获的变量，闭包和定义它的作用域持有同一个Cell，
+// 因此任何一方的赋值对另一方都可见
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+
+func (c *Cell) Inspect() string {
+	return "Cell[" + c.Value.Inspect() + "]"
+}
+
 type CompiledFunction struct {
 	NumLocals     int //统计的局部变量的数目，用于虚拟机栈上预分配空间
 	NumParameters int
 	Instructions  code.Instructions
+	LineTable     code.LineTable //指令偏移到源码行列的映射，用于报错
+	Filename      string
+	Name          string   //let绑定的函数名，匿名函数为空
+	LocalNames    []string //局部变量槽位对应的变量名，参数在最前面，用于调试
+	FreeNames     []string //自由变量对应的变量名，顺序和Closure.Free相同
 }
 
 func (c *CompiledFunction) Type() ObjectType {
@@ -70,6 +106,87 @@ func (i *Integer) Type() ObjectType {
 	return INTEGER_OBJ
 }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") { //整数值的浮点数也带上小数点，和Integer区分开
+		s += ".0"
+	}
+	return s
+}
+
+func (f *Float) Type() ObjectType {
+	return FLOAT_OBJ
+}
+
+// CompareNumbers 比较两个整数或浮点数，a小于、等于、大于b时分别返回-1、0、1，有NaN时第二个返回值为false。
+// 整数和浮点数比较时不把整数转换成float64，超过2^53的整数转换后会丢失精度
+func CompareNumbers(a, b Object) (int, bool) {
+	switch a := a.(type) {
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return compareInts(a.Value, b.Value), true
+		case *Float:
+			return compareIntFloat(a.Value, b.Value)
+		}
+	case *Float:
+		switch b := b.(type) {
+		case *Integer:
+			c, ok := compareIntFloat(b.Value, a.Value)
+			return -c, ok
+		case *Float:
+			if math.IsNaN(a.Value) || math.IsNaN(b.Value) {
+				return 0, false
+			}
+			switch {
+			case a.Value < b.Value:
+				return -1, true
+			case a.Value > b.Value:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareIntFloat 先比较i和f的整数部分，整数部分相等时再看f的小数部分
+func compareIntFloat(i int64, f float64) (int, bool) {
+	switch {
+	case math.IsNaN(f):
+		return 0, false
+	case f >= float64(1<<63):
+		return -1, true
+	case f < -float64(1<<63):
+		return 1, true
+	}
+	t := math.Trunc(f)
+	if c := compareInts(i, int64(t)); c != 0 {
+		return c, true
+	}
+	switch {
+	case f > t:
+		return -1, true
+	case f < t:
+		return 1, true
+	}
+	return 0, true
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -104,6 +221,19 @@ func (rv *ReturnValue) Inspect() string {
 	return rv.Value.Inspect()
 }
 
+// Break和Continue只在解释执行时使用，沿着语句块向外传递直到所在的循环
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+func (b *Break) Inspect() string { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
+func (c *Continue) Inspect() string { return "continue" }
+
 type Error struct {
 	Message string
 }
@@ -156,7 +286,15 @@ func (s *String) Inspect() string {
 	return s.Value
 }
 
-type BuiltinFunction func(args ...Object) Object
+// CallContext 是内置函数回调Monkey函数的入口，由正在执行这个内置函数的虚拟机或解释器提供。
+// 回调出错时返回*Error或*Exit，内置函数应该原样返回它。
+// IO返回puts和readline使用的输入输出，每个虚拟机或解释器可以不同
+type CallContext interface {
+	Call(fn Object, args ...Object) Object
+	IO() IO
+}
+
+type BuiltinFunction func(ctx CallContext, args ...Object) Object
 
 type Builtin struct {
 	Fn BuiltinFunction
@@ -206,6 +344,14 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey 整数值的浮点数和对应的整数相等，所以使用整数的HashKey，{2: "a"}[2.0]能找到"a"
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+		return HashKey{Type: INTEGER_OBJ, Value: uint64(int64(f.Value))}
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 // TODO:字符串hash可能冲突，拉链法
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
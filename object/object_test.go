@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"context"
+	"math"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -16,3 +20,100 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with same content has different hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	if (&Float{Value: 2}).HashKey() != (&Integer{Value: 2}).HashKey() {
+		t.Errorf("integral float and integer have different hash keys")
+	}
+	if (&Float{Value: -0.0}).HashKey() != (&Integer{Value: 0}).HashKey() {
+		t.Errorf("-0.0 and 0 have different hash keys")
+	}
+	if (&Float{Value: 2.5}).HashKey() == (&Float{Value: 2}).HashKey() {
+		t.Errorf("different floats have the same hash key")
+	}
+	if (&Float{Value: 1e30}).HashKey().Type != FLOAT_OBJ {
+		t.Errorf("floats outside the integer range should keep float hash keys")
+	}
+}
+
+func TestCompareNumbers(t *testing.T) {
+	nan := &Float{Value: math.NaN()}
+	tests := []struct {
+		a, b     Object
+		expected int
+		ok       bool
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 2}, -1, true},
+		{&Integer{Value: 2}, &Float{Value: 2}, 0, true},
+		{&Float{Value: 2.5}, &Integer{Value: 2}, 1, true},
+		{&Integer{Value: -3}, &Float{Value: -2.5}, -1, true},
+		{&Integer{Value: 1<<53 + 1}, &Float{Value: 1 << 53}, 1, true},
+		{&Float{Value: 1 << 53}, &Integer{Value: 1<<53 + 1}, -1, true},
+		{&Integer{Value: math.MaxInt64}, &Float{Value: 1 << 63}, -1, true},
+		{&Integer{Value: math.MinInt64}, &Float{Value: -(1 << 63)}, 0, true},
+		{&Integer{Value: math.MinInt64}, &Float{Value: -1e19}, 1, true},
+		{&Integer{Value: 1}, nan, 0, false},
+		{nan, nan, 0, false},
+	}
+
+	for _, ts := range tests {
+		c, ok := CompareNumbers(ts.a, ts.b)
+		if c != ts.expected || ok != ts.ok {
+			t.Errorf("CompareNumbers(%s, %s): want=%d %t, got=%d %t", ts.a.Inspect(), ts.b.Inspect(), ts.expected, ts.ok, c, ok)
+		}
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{3.14, "3.14"},
+		{2, "2.0"},
+		{-0.5, "-0.5"},
+		{1e-9, "1e-09"},
+		{1e21, "1e+21"},
+	}
+
+	for _, ts := range tests {
+		f := &Float{Value: ts.value}
+		if f.Inspect() != ts.expected {
+			t.Errorf("wrong Inspect. want=%q, got=%q", ts.expected, f.Inspect())
+		}
+	}
+}
+
+func TestBudgetMemory(t *testing.T) {
+	budget := NewBudget(context.Background(), Limits{MaxMemory: 100})
+
+	if err := budget.Allocate(&Integer{Value: 1}); err != nil || budget.Memory() != 0 {
+		t.Errorf("integers should not be accounted. memory=%d, err=%v", budget.Memory(), err)
+	}
+	if err := budget.Allocate(&String{Value: "hello"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if budget.Memory() != 21 {
+		t.Errorf("wrong string size. got=%d", budget.Memory())
+	}
+	err := budget.Allocate(&Array{Elements: make([]Object, 10)})
+	if err == nil || err.Error() != "cumulative memory limit exceeded (max 100)" {
+		t.Errorf("expected memory limit error. got=%v", err)
+	}
+
+	budget = NewBudget(context.Background(), Limits{MaxMemory: 100})
+	if err := budget.AddHashEntry(); err != nil || budget.Memory() != mapEntry {
+		t.Errorf("new hash keys should be accounted. memory=%d, err=%v", budget.Memory(), err)
+	}
+	if err := budget.AddHashEntry(); err == nil {
+		t.Errorf("expected memory limit error for the second key")
+	}
+
+	var unlimited *Budget
+	if err := unlimited.AddHashEntry(); err != nil {
+		t.Errorf("nil budget should not limit. got=%v", err)
+	}
+	if err := unlimited.Allocate(&String{Value: "x"}); err != nil {
+		t.Errorf("nil budget should not limit. got=%v", err)
+	}
+}
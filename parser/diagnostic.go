@@ -0,0 +1,122 @@
+package parser
+
+import (
+	"fmt"
+	"myinterpreter/token"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// 诊断代码，工具可以根据代码而不是错误信息的文字来区分错误
+const (
+	CodeUnexpectedToken         = "P001" // 不是期望的下一个token
+	CodeNoPrefixParseFn         = "P002" // 这个token不能开始一个表达式
+	CodeIllegalToken            = "P003"
+	CodeUnterminatedComment     = "P004"
+	CodeInvalidNumber           = "P005"
+	CodeInvalidAssignTarget     = "P006"
+	CodeLoopControlInExpression = "P007" // break或continue出现在表达式中
+)
+
+// Diagnostic 是解析时发现的一个问题，Pos和End是出问题的源码范围
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Pos      token.Position
+	End      token.Position
+	Message  string
+}
+
+// String 返回 line:col: message，和以前Errors()返回的格式相同
+func (d Diagnostic) String() string {
+	if d.Pos.IsValid() {
+		return d.Pos.String() + ": " + d.Message
+	}
+	return d.Message
+}
+
+// Render 返回带源码行和下划线的诊断信息，例如
+//
+//	error[P001]: expected next token to be =, got INT instead
+//	 --> script.mk:1:7
+//	  |
+//	1 | let x 5;
+//	  |       ^
+func (d Diagnostic) Render(source string) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s[%s]: %s\n", d.Severity, d.Code, d.Message)
+	if !d.Pos.IsValid() {
+		return out.String()
+	}
+
+	lines := strings.Split(source, "\n")
+	if d.Pos.Line > len(lines) {
+		fmt.Fprintf(&out, " --> %s\n", d.Pos)
+		return out.String()
+	}
+	line := strings.TrimRight(lines[d.Pos.Line-1], "\r")
+	lineNo := fmt.Sprintf("%d", d.Pos.Line)
+	gutter := strings.Repeat(" ", len(lineNo))
+
+	fmt.Fprintf(&out, "%s--> %s\n", gutter, d.Pos)
+	fmt.Fprintf(&out, "%s |\n", gutter)
+	fmt.Fprintf(&out, "%s | %s\n", lineNo, line)
+	fmt.Fprintf(&out, "%s | %s\n", gutter, underline(line, d.Pos, d.End))
+	return out.String()
+}
+
+// underline 在出错范围下面画^，跨行的范围画到行尾为止。
+// 前面的空白保留源码里的tab，这样在终端上能对齐
+func underline(line string, pos, end token.Position) string {
+	start := pos.Column - 1
+	if start > len(line) {
+		start = len(line)
+	}
+	stop := len(line)
+	if end.IsValid() && end.Line == pos.Line && end.Column-1 < stop {
+		stop = end.Column - 1
+	}
+
+	var b strings.Builder
+	for i := 0; i < start; i++ {
+		if line[i] == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteString(strings.Repeat("^", max(stop-start, 1)))
+	return b.String()
+}
+
+// RenderDiagnostics 依次渲染全部诊断
+func RenderDiagnostics(diagnostics []Diagnostic, source string) string {
+	var out strings.Builder
+	for _, d := range diagnostics {
+		out.WriteString(d.Render(source))
+	}
+	return out.String()
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
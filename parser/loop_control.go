@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"myinterpreter/ast"
+	"myinterpreter/token"
+)
+
+// loopControlChecker 报告出现在表达式中的break和continue。
+// 它们只能作为语句使用：所在的if是let的值、运算数或参数等表达式的一部分时，
+// 跳出循环时这个表达式只算了一半，虚拟机的栈上会留下算了一半的值，解释器也会把它当成普通的值
+type loopControlChecker struct {
+	p *Parser
+}
+
+func (c loopControlChecker) statements(stmts []ast.Statement, inExpr bool) {
+	for _, stmt := range stmts {
+		c.statement(stmt, inExpr)
+	}
+}
+
+func (c loopControlChecker) statement(stmt ast.Statement, inExpr bool) {
+	switch stmt := stmt.(type) {
+	case *ast.BreakStatement:
+		if inExpr {
+			c.report(stmt.Token.Pos, stmt.Token.End, "break")
+		}
+	case *ast.ContinueStatement:
+		if inExpr {
+			c.report(stmt.Token.Pos, stmt.Token.End, "continue")
+		}
+	case *ast.ExpressionStatement:
+		//单独作为语句的if，它的块和外面的块一样
+		if ie, ok := stmt.Expression.(*ast.IfExpression); ok {
+			c.ifExpression(ie, inExpr)
+		} else {
+			c.expression(stmt.Expression)
+		}
+	case *ast.LetStatement:
+		c.expression(stmt.Value)
+	case *ast.ReturnStatement:
+		c.expression(stmt.ReturnValue)
+	case *ast.BlockStatement:
+		c.statements(stmt.Statements, inExpr)
+	case *ast.WhileStatement:
+		c.expression(stmt.Condition)
+		c.statements(stmt.Body.Statements, false)
+	case *ast.ForStatement:
+		c.expression(stmt.Iterable)
+		c.statements(stmt.Body.Statements, false)
+	}
+}
+
+func (c loopControlChecker) ifExpression(ie *ast.IfExpression, inExpr bool) {
+	c.expression(ie.Condition)
+	c.statements(ie.Consequence.Statements, inExpr)
+	if ie.Alternative != nil {
+		c.statements(ie.Alternative.Statements, inExpr)
+	}
+}
+
+func (c loopControlChecker) expression(expr ast.Expression) {
+	switch expr := expr.(type) {
+	case *ast.IfExpression:
+		c.ifExpression(expr, true)
+	case *ast.FunctionLiteral:
+		c.statements(expr.Body.Statements, false)
+	case *ast.MacroLiteral:
+		c.statements(expr.Body.Statements, false)
+	case *ast.InfixExpression:
+		c.expression(expr.Left)
+		c.expression(expr.Right)
+	case *ast.PrefixExpression:
+		c.expression(expr.Right)
+	case *ast.AssignExpression:
+		c.expression(expr.Target)
+		c.expression(expr.Value)
+	case *ast.CallExpression:
+		c.expression(expr.Function)
+		for _, arg := range expr.Arguments {
+			c.expression(arg)
+		}
+	case *ast.IndexExpression:
+		c.expression(expr.Left)
+		c.expression(expr.Index)
+	case *ast.ArrayLiteral:
+		for _, el := range expr.Elements {
+			c.expression(el)
+		}
+	case *ast.HashLiteral:
+		for k, v := range expr.Pairs {
+			c.expression(k)
+			c.expression(v)
+		}
+	}
+}
+
+func (c loopControlChecker) report(pos, end token.Position, keyword string) {
+	c.p.diagnostics = append(c.p.diagnostics, Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeLoopControlInExpression,
+		Pos:      pos,
+		End:      end,
+		Message:  keyword + " cannot be used inside an expression",
+	})
+}
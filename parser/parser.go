@@ -6,11 +6,15 @@ import (
 	"myinterpreter/lexer"
 	"myinterpreter/token"
 	"strconv"
+	"strings"
 )
 
 const (
 	_ = iota
 	LOWEST
+	ASSIGN      // = or +=
+	LOGICALOR   // ||
+	LOGICALAND  // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         // +
@@ -21,16 +25,27 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.PERCENT_ASSIGN:  ASSIGN,
+	token.OR:              LOGICALOR,
+	token.AND:             LOGICALAND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 type (
@@ -46,7 +61,8 @@ type Parser struct {
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 
-	errors []string
+	diagnostics []Diagnostic
+	panicking   bool //当前语句已经出错，在恢复之前不再报告后续的连锁错误
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -74,29 +90,108 @@ func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// Errors 返回 line:col: message 形式的全部错误
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := []string{}
+	for _, d := range p.diagnostics {
+		if d.Severity == SeverityError {
+			errors = append(errors, d.String())
+		}
+	}
+	return errors
+}
+
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
+	p.addError(p.peekToken, CodeUnexpectedToken, "expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+}
+
+// addError 报告tok处的错误。同一条语句中第一个错误之后的错误通常是它引起的，不再报告
+func (p *Parser) addError(tok token.Token, code string, format string, a ...any) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	p.diagnostics = append(p.diagnostics, Diagnostic{
+		Severity: SeverityError,
+		Code:     code,
+		Pos:      tok.Pos,
+		End:      tok.End,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+// synchronize 出错之后跳过当前语句剩下的token，停在语句的分号上，
+// 或者下一个token是语句的开头、所在代码块的}、EOF时停下
+func (p *Parser) synchronize() {
+	depth := 0
+	for !p.curTokenIs(token.EOF) {
+		switch p.curToken.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			if depth > 0 {
+				depth--
+			}
+		case token.SEMICOLON:
+			if depth == 0 {
+				return
+			}
+		}
+		if depth == 0 {
+			switch p.peekToken.Type {
+			case token.LET, token.RETURN, token.WHILE, token.FOR, token.BREAK, token.CONTINUE,
+				token.RBRACE, token.EOF:
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
+
+// parseStatements 解析语句直到遇到end或EOF，出错的语句被丢弃，解析从下一条语句继续
+func (p *Parser) parseStatements(end token.TokenType) []ast.Statement {
+	stmts := []ast.Statement{}
+	for !p.curTokenIs(end) && !p.curTokenIs(token.EOF) {
+		p.panicking = false
+		stmt := p.parseStatement()
+		if p.panicking {
+			p.synchronize()
+			p.panicking = false
+		} else if stmt != nil {
+			stmts = append(stmts, stmt)
+		}
+		p.nextToken()
+	}
+	return stmts
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l}
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
+	for _, tt := range []token.TokenType{token.ASSIGN, token.PLUS_ASSIGN, token.MINUS_ASSIGN,
+		token.ASTERISK_ASSIGN, token.SLASH_ASSIGN, token.PERCENT_ASSIGN} {
+		p.registerInfix(tt, p.parseAssignExpression)
+	}
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
@@ -104,6 +199,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
@@ -158,6 +254,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.Rbrace = p.curToken
 	return hash
 }
 
@@ -168,12 +265,14 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.Rbracket = p.curToken
 	return exp
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.Rbracket = p.curToken
 	return array
 }
 
@@ -205,6 +304,7 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Rparen = p.curToken
 	return exp
 }
 
@@ -302,16 +402,9 @@ func (p *Parser) parseIfExpression() ast.Expression {
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
-	block.Statements = []ast.Statement{}
 	p.nextToken()
-
-	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
-		}
-		p.nextToken()
-	}
+	block.Statements = p.parseStatements(token.RBRACE)
+	block.Rbrace = p.curToken
 
 	return block
 }
@@ -341,6 +434,23 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// parseAssignExpression 解析赋值，赋值是右结合的：a = b = c 等价于 a = (b = c)
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Target:   left,
+	}
+	switch left.(type) {
+	case *ast.Identifier, *ast.IndexExpression, nil:
+	default:
+		p.addError(p.curToken, CodeInvalidAssignTarget, "invalid assignment target %s", left.String())
+	}
+	p.nextToken()
+	expression.Value = p.parseExpression(ASSIGN - 1)
+	return expression
+}
+
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	expression := &ast.PrefixExpression{
 		Token:    p.curToken,
@@ -358,13 +468,10 @@ func (p *Parser) nextToken() {
 
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
-	program.Statements = []ast.Statement{}
-	for !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			program.Statements = append(program.Statements, stmt)
-		}
-		p.nextToken()
+	program.Statements = p.parseStatements(token.EOF)
+	//有语法错误时语法树不完整，只在没有错误时检查
+	if len(p.Errors()) == 0 {
+		loopControlChecker{p}.statements(program.Statements, false)
 	}
 	return program
 }
@@ -375,6 +482,14 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -394,8 +509,18 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 	v, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken, CodeInvalidNumber, "could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+	lit.Value = v
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+	v, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.addError(p.curToken, CodeInvalidNumber, "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 	lit.Value = v
@@ -403,6 +528,10 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	if p.curTokenIs(token.ILLEGAL) {
+		p.illegalTokenError(p.curToken)
+		return nil
+	}
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
@@ -434,12 +563,79 @@ func (p *Parser) parseReturnStatement() ast.Statement {
 	return stmt
 }
 
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseBreakStatement() ast.Statement {
+	stmt := &ast.BreakStatement{Token: p.curToken}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() ast.Statement {
+	stmt := &ast.ContinueStatement{Token: p.curToken}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseLetStatement() ast.Statement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
-	stmt.Name = &ast.Identifier{p.curToken, p.curToken.Literal}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -475,7 +671,14 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	}
 }
 
+func (p *Parser) illegalTokenError(tok token.Token) {
+	if strings.HasPrefix(tok.Literal, "/*") {
+		p.addError(tok, CodeUnterminatedComment, "unterminated block comment")
+		return
+	}
+	p.addError(tok, CodeIllegalToken, "illegal token %q", tok.Literal)
+}
+
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(p.curToken, CodeNoPrefixParseFn, "no prefix parse function for %s found", t)
 }
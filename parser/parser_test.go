@@ -341,6 +341,22 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"-a * b",
 			"((-a) * b)",
 		},
+		{
+			"a + b % c",
+			"(a + (b % c))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
 		{
 			"!-a",
 			"(!(-a))",
@@ -872,3 +888,293 @@ func TestFunctionLiteralWithName(t *testing.T) {
 			function.Name)
 	}
 }
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+add(1, [2, 3][0]);`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	let := program.Statements[0].(*ast.LetStatement)
+	fn := let.Value.(*ast.FunctionLiteral)
+	body := fn.Body.Statements[0].(*ast.ExpressionStatement)
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	index := call.Arguments[1]
+
+	tests := []struct {
+		node        ast.Node
+		expectedPos string
+		expectedEnd string
+	}{
+		{let, "1:1", "3:2"},
+		{fn, "1:11", "3:2"},
+		{body, "2:3", "2:8"},
+		{call, "4:1", "4:18"},
+		{index, "4:8", "4:17"},
+		{program, "1:1", "4:18"},
+	}
+
+	for _, ts := range tests {
+		if ts.node.Pos().String() != ts.expectedPos {
+			t.Errorf("%T.Pos() wrong. want=%q, got=%q", ts.node, ts.expectedPos, ts.node.Pos())
+		}
+		if ts.node.End().String() != ts.expectedEnd {
+			t.Errorf("%T.End() wrong. want=%q, got=%q", ts.node, ts.expectedEnd, ts.node.End())
+		}
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x 5;", "1:7: expected next token to be =, got INT instead"},
+		{"let x = 1;\nlet = 2;", "2:5: expected next token to be IDENT, got = instead"},
+		{"1 +\n  ;", "2:3: no prefix parse function for ; found"},
+		{"let x = 1;\n/* never closed", "2:1: unterminated block comment"},
+	}
+
+	for _, ts := range tests {
+		p := New(lexer.New(ts.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q", ts.input)
+		}
+		if errors[0] != ts.expected {
+			t.Errorf("wrong parser error. want=%q, got=%q", ts.expected, errors[0])
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9", 1e-9},
+		{"2.5E+3", 2500},
+	}
+
+	for _, ts := range tests {
+		l := lexer.New(ts.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != ts.expected {
+			t.Errorf("literal.Value not %g. got=%g", ts.expected, literal.Value)
+		}
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; continue; }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T", program.Statements[0])
+	}
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+	if len(stmt.Body.Statements) != 3 {
+		t.Fatalf("body is not 3 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("Statements[1] is not ast.BreakStatement. got=%T", stmt.Body.Statements[1])
+	}
+	if _, ok := stmt.Body.Statements[2].(*ast.ContinueStatement); !ok {
+		t.Errorf("Statements[2] is not ast.ContinueStatement. got=%T", stmt.Body.Statements[2])
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (item in [1, 2]) { item }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Variable.Value != "item" {
+		t.Errorf("stmt.Variable is not 'item'. got=%s", stmt.Variable.Value)
+	}
+	if stmt.Iterable.String() != "[1, 2]" {
+		t.Errorf("stmt.Iterable wrong. got=%s", stmt.Iterable.String())
+	}
+	if stmt.String() != "for(item in [1, 2]) item" {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+}
+
+func TestAssignExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5;", "x = 5"},
+		{"x += y * 2;", "x += (y * 2)"},
+		{"a = b = c;", "a = b = c"},
+		{"x %= 2 || y;", "x %= (2 || y)"},
+		{"a[i + 1] = b[0];", "(a[(i + 1)]) = (b[0])"},
+	}
+
+	for _, ts := range tests {
+		l := lexer.New(ts.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+		}
+		if _, ok := stmt.Expression.(*ast.AssignExpression); !ok {
+			t.Fatalf("exp is not ast.AssignExpression. got=%T", stmt.Expression)
+		}
+		if stmt.Expression.String() != ts.expected {
+			t.Errorf("expected=%q, got=%q", ts.expected, stmt.Expression.String())
+		}
+	}
+
+	l := lexer.New("1 = 2;")
+	p := New(l)
+	p.ParseProgram()
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != "1:3: invalid assignment target 1" {
+		t.Errorf("wrong parser errors. got=%q", errors)
+	}
+}
+
+func TestLoopControlInExpression(t *testing.T) {
+	valid := []string{
+		"while (true) { break; }",
+		"while (true) { if (x) { continue } else { break } }",
+		"for (x in a) { if (x) { if (y) { break } } }",
+		"let f = fn() { while (true) { break } };",
+		"while (true) { let f = fn() { while (true) { break } }; break; }",
+	}
+	for _, input := range valid {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		checkParserErrors(t, p)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (true) { let y = if (true) { break }; }", "1:36: break cannot be used inside an expression"},
+		{"while (true) { 1 + if (true) { continue } else { 2 }; }", "1:32: continue cannot be used inside an expression"},
+		{"while (true) { puts(if (x) { 1 } else { break }); }", "1:41: break cannot be used inside an expression"},
+		{"while (true) { return if (x) { break }; }", "1:32: break cannot be used inside an expression"},
+		{"for (x in [if (y) { continue }]) { }", "1:21: continue cannot be used inside an expression"},
+	}
+	for _, ts := range tests {
+		p := New(lexer.New(ts.input))
+		p.ParseProgram()
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Fatalf("wrong number of diagnostics for %q. got=%q", ts.input, p.Errors())
+		}
+		if diagnostics[0].Code != CodeLoopControlInExpression {
+			t.Errorf("wrong code. want=%s, got=%s", CodeLoopControlInExpression, diagnostics[0].Code)
+		}
+		if diagnostics[0].String() != ts.expected {
+			t.Errorf("wrong diagnostic. want=%q, got=%q", ts.expected, diagnostics[0].String())
+		}
+	}
+}
+
+func TestParserRecovery(t *testing.T) {
+	input := `let x 5;
+let y = 1 +;
+let f = fn(a) {
+    let = 3;
+    a + 1
+};
+puts(y);`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+
+	expected := []struct {
+		code string
+		msg  string
+	}{
+		{CodeUnexpectedToken, "1:7: expected next token to be =, got INT instead"},
+		{CodeNoPrefixParseFn, "2:12: no prefix parse function for ; found"},
+		{CodeUnexpectedToken, "4:9: expected next token to be IDENT, got = instead"},
+	}
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("wrong number of diagnostics. want=%d, got=%d: %q", len(expected), len(diagnostics), p.Errors())
+	}
+	for i, e := range expected {
+		if diagnostics[i].Code != e.code {
+			t.Errorf("diagnostic %d code wrong. want=%s, got=%s", i, e.code, diagnostics[i].Code)
+		}
+		if diagnostics[i].String() != e.msg {
+			t.Errorf("diagnostic %d wrong. want=%q, got=%q", i, e.msg, diagnostics[i].String())
+		}
+	}
+
+	//出错的语句被丢弃，之后的语句照常解析
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	if program.Statements[0].String() != "let f = fn<f>(a) (a + 1);" {
+		t.Errorf("wrong first statement. got=%q", program.Statements[0].String())
+	}
+	if program.Statements[1].String() != "puts(y)" {
+		t.Errorf("wrong second statement. got=%q", program.Statements[1].String())
+	}
+}
+
+func TestDiagnosticRender(t *testing.T) {
+	input := "let x = 1;\n\tlet y == 2;"
+
+	p := New(lexer.NewWithFilename("script.mk", input))
+	p.ParseProgram()
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got=%q", p.Errors())
+	}
+
+	expected := "error[P001]: expected next token to be =, got == instead\n" +
+		" --> script.mk:2:8\n" +
+		"  |\n" +
+		"2 | \tlet y == 2;\n" +
+		"  | \t      ^^\n"
+	if got := diagnostics[0].Render(input); got != expected {
+		t.Errorf("wrong rendering.\nwant=%q\ngot =%q", expected, got)
+	}
+}
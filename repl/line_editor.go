@@ -0,0 +1,281 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"myinterpreter/object"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// errInterrupted 表示用户按了Ctrl-C，放弃当前输入
+var errInterrupted = errors.New("interrupted")
+
+const maxHistory = 1000
+
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+	AddHistory(line string)
+}
+
+// newLineReader 在终端上返回支持行编辑和历史记录的lineEditor，否则逐行读取输入。
+// 两者都从reader读取，reader是in的缓冲，和脚本中的readline共用
+func newLineReader(in io.Reader, reader *bufio.Reader, out io.Writer) lineReader {
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		e := &lineEditor{in: f, reader: reader, out: out, historyFile: historyPath()}
+		e.loadHistory()
+		return e
+	}
+	return &plainReader{reader: reader, out: out}
+}
+
+// sharedReader 返回REPL读取输入和readline共用的bufio.Reader。
+// 两边各自缓冲同一个输入时，一边预读走的内容另一边就读不到了
+func sharedReader(in io.Reader) *bufio.Reader {
+	if in == io.Reader(os.Stdin) {
+		return object.StdIO().In
+	}
+	if r, ok := in.(*bufio.Reader); ok {
+		return r
+	}
+	return bufio.NewReader(in)
+}
+
+type plainReader struct {
+	reader *bufio.Reader
+	out    io.Writer
+}
+
+func (r *plainReader) ReadLine(prompt string) (string, error) {
+	io.WriteString(r.out, prompt)
+	line, err := r.reader.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+func (r *plainReader) AddHistory(string) {}
+
+// historyPath 返回历史记录文件，可以用MONKEY_HISTORY环境变量指定，为空字符串时不保存
+func historyPath() string {
+	if path, ok := os.LookupEnv("MONKEY_HISTORY"); ok {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".monkey_history")
+}
+
+// lineEditor 是一个简单的终端行编辑器，支持光标移动、常用的Emacs快捷键和上下翻历史
+type lineEditor struct {
+	in          *os.File
+	reader      *bufio.Reader
+	out         io.Writer
+	history     []string
+	historyFile string
+}
+
+func (e *lineEditor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(e.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+func (e *lineEditor) AddHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if e.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+func (e *lineEditor) ReadLine(prompt string) (string, error) {
+	fd := int(e.in.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(fd, state)
+
+	var buf []rune
+	pos := 0
+	histIdx := len(e.history)
+	saved := "" //翻历史之前正在编辑的内容
+
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(buf))
+		if back := len(buf) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	setLine := func(s string) {
+		buf = []rune(s)
+		pos = len(buf)
+		redraw()
+	}
+	redraw()
+
+	for {
+		r, _, err := e.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			io.WriteString(e.out, "\r\n")
+			return string(buf), nil
+		case 3: // Ctrl-C
+			io.WriteString(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 2: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl-F
+			if pos < len(buf) {
+				pos++
+			}
+		case 11: // Ctrl-K
+			buf = buf[:pos]
+		case 21: // Ctrl-U
+			buf = buf[pos:]
+			pos = 0
+		case 23: // Ctrl-W
+			start := pos
+			for start > 0 && buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && buf[start-1] != ' ' {
+				start--
+			}
+			buf = append(buf[:start], buf[pos:]...)
+			pos = start
+		case 12: // Ctrl-L
+			io.WriteString(e.out, "\x1b[H\x1b[2J")
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 16, 14: // Ctrl-P, Ctrl-N
+			histIdx, saved = e.moveHistory(r == 16, histIdx, saved, string(buf), setLine)
+		case 27: // 转义序列
+			switch e.readEscape() {
+			case "[A", "OA":
+				histIdx, saved = e.moveHistory(true, histIdx, saved, string(buf), setLine)
+			case "[B", "OB":
+				histIdx, saved = e.moveHistory(false, histIdx, saved, string(buf), setLine)
+			case "[C", "OC":
+				if pos < len(buf) {
+					pos++
+				}
+			case "[D", "OD":
+				if pos > 0 {
+					pos--
+				}
+			case "[H", "OH", "[1~":
+				pos = 0
+			case "[F", "OF", "[4~":
+				pos = len(buf)
+			case "[3~":
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		case '\t':
+			buf = append(buf[:pos], append([]rune("  "), buf[pos:]...)...)
+			pos += 2
+		default:
+			if r < 32 {
+				continue
+			}
+			buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+			pos++
+		}
+		redraw()
+	}
+}
+
+// moveHistory 向上或向下翻一条历史，翻回最底部时恢复翻历史前正在编辑的内容
+func (e *lineEditor) moveHistory(up bool, idx int, saved, current string, setLine func(string)) (int, string) {
+	if idx == len(e.history) {
+		saved = current
+	}
+	switch {
+	case up && idx > 0:
+		idx--
+	case !up && idx < len(e.history):
+		idx++
+	default:
+		return idx, saved
+	}
+	if idx == len(e.history) {
+		setLine(saved)
+	} else {
+		setLine(e.history[idx])
+	}
+	return idx, saved
+}
+
+// readEscape 读取ESC之后的序列，例如方向键上是"[A"
+func (e *lineEditor) readEscape() string {
+	first, err := e.reader.ReadByte()
+	if err != nil || (first != '[' && first != 'O') {
+		return ""
+	}
+	seq := []byte{first}
+	for {
+		b, err := e.reader.ReadByte()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, b)
+		if b >= 0x40 && b <= 0x7e { //CSI序列的结束字节
+			return string(seq)
+		}
+	}
+}
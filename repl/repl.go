@@ -1,76 +1,340 @@
 package repl
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"myinterpreter/ast"
 	"myinterpreter/compiler"
+	"myinterpreter/evaluator"
 	"myinterpreter/lexer"
 	"myinterpreter/object"
 	"myinterpreter/parser"
+	"myinterpreter/token"
 	"myinterpreter/vm"
+	"os"
+	"sort"
+	"strings"
 )
 
-const PROMPT = ">> "
+const (
+	PROMPT       = ">> "
+	CONTINUATION = ".. "
+)
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
+// Engine 选择执行输入的方式：编译成字节码在虚拟机上运行，或者直接遍历AST求值
+type Engine string
+
+const (
+	EngineVM   Engine = "vm"
+	EngineEval Engine = "eval"
+)
+
+const help = `meta-commands:
+  :engine [vm|eval] show or switch the execution engine
+  :ast <code>       print the AST after macro expansion without running it
+  :bytecode <code>  print the compiled bytecode without running it
+  :globals          list global bindings and their values
+  :load <file>      run a script in the current session
+  :reset            forget all bindings
+  :help             show this message
+  :quit             leave the REPL
+an input continues on the next line while brackets are unbalanced;
+an empty line submits it anyway.
+`
+
+// session 保存REPL多次输入之间共享的状态。两个引擎各自保存自己的绑定，
+// 宏定义对两个引擎都可见
+type session struct {
+	out    io.Writer
+	stdio  object.IO //puts和readline使用的输入输出，输入和REPL共用一个缓冲
+	engine Engine
+
+	constants   []object.Object
+	globals     []object.Object
+	symbolTable *compiler.SymbolTable
+
+	env      *object.Environment
+	macroEnv *object.Environment
+
+	exited bool //输入中调用了exit()，REPL随之结束
+}
+
+func newSession(stdio object.IO, engine Engine) *session {
+	s := &session{out: stdio.Out, stdio: stdio, engine: engine}
+	s.reset()
+	return s
+}
+
+func (s *session) reset() {
+	s.constants = []object.Object{}
+	s.globals = make([]object.Object, vm.GlobalsSize)
+	s.symbolTable = compiler.NewSymbolTable()
 	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
+		s.symbolTable.DefineBuiltin(i, v.Name)
 	}
-	//env := object.NewEnvironment()
-	//macroEnv := object.NewEnvironment()
+	s.env = object.NewEnvironment()
+	s.env.SetIO(s.stdio)
+	s.macroEnv = object.NewEnvironment()
+	s.macroEnv.SetIO(s.stdio)
+}
+
+func Start(in io.Reader, out io.Writer) {
+	StartWithEngine(in, out, EngineVM)
+}
+
+func StartWithEngine(in io.Reader, out io.Writer, engine Engine) {
+	stdin := sharedReader(in)
+	reader := newLineReader(in, stdin, out)
+	s := newSession(object.IO{In: stdin, Out: out}, engine)
 
 	for {
-		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		input, err := readInput(reader)
+		if errors.Is(err, errInterrupted) {
+			continue
+		}
+		if err != nil {
 			return
 		}
-
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParseErrors(out, p.Errors())
+		if strings.TrimSpace(input) == "" {
 			continue
 		}
-		//evaluator.Definemacros(program, macroEnv)
-		//expanded := evaluator.ExpandMacro(program, macroEnv)
-		//eval := evaluator.Eval(expanded, env)
-		//if eval != nil {
-		//	io.WriteString(out, eval.Inspect())
-		//	io.WriteString(out, "\n")
-		//}
-
-		compile := compiler.NewWithState(symbolTable, constants)
-		err := compile.Compile(program)
-		if err != nil {
-			fmt.Fprintf(out, "Compilation failed:\n %s\n", err)
+		if strings.HasPrefix(strings.TrimSpace(input), ":") {
+			if quit := s.metaCommand(strings.TrimSpace(input)); quit || s.exited {
+				return
+			}
 			continue
 		}
-		code := compile.Bytecode()
-		constants = code.Constants
+		s.eval("", input)
+		if s.exited {
+			return
+		}
+	}
+}
 
-		ma := vm.NewWithGlobalsStore(code, globals)
-		err = ma.Run()
+// readInput 读取一条完整的输入，括号没有闭合时继续读下一行，空行强制结束输入
+func readInput(reader lineReader) (string, error) {
+	var lines []string
+	prompt := PROMPT
+	for {
+		line, err := reader.ReadLine(prompt)
 		if err != nil {
-			fmt.Fprintf(out, "Executing bytecode failed:\n %s\n", err)
-			continue
+			if errors.Is(err, io.EOF) && len(lines) > 0 {
+				return strings.Join(lines, "\n"), nil
+			}
+			return "", err
+		}
+		reader.AddHistory(line)
+		if len(lines) > 0 && strings.TrimSpace(line) == "" {
+			return strings.Join(lines, "\n"), nil
+		}
+		lines = append(lines, line)
+		input := strings.Join(lines, "\n")
+		if strings.HasPrefix(strings.TrimSpace(input), ":") || !needsMoreInput(input) {
+			return input, nil
+		}
+		prompt = CONTINUATION
+	}
+}
+
+// needsMoreInput 判断输入中是否还有没闭合的括号或块注释，字符串和注释中的括号不计入
+func needsMoreInput(input string) bool {
+	depth := 0
+	l := lexer.New(input)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		case token.ILLEGAL:
+			if strings.HasPrefix(tok.Literal, "/*") {
+				return true
+			}
+		}
+	}
+	return depth > 0
+}
+
+func (s *session) metaCommand(line string) (quit bool) {
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch cmd {
+	case ":quit", ":q", ":exit":
+		return true
+	case ":help":
+		io.WriteString(s.out, help)
+	case ":reset":
+		s.reset()
+		io.WriteString(s.out, "session reset\n")
+	case ":globals":
+		s.printGlobals()
+	case ":engine":
+		switch Engine(arg) {
+		case "":
+		case EngineVM, EngineEval:
+			s.engine = Engine(arg)
+		default:
+			fmt.Fprintf(s.out, "unknown engine %s, use vm or eval\n", arg)
+			return false
 		}
-		stackTop := ma.LastPoppedStackElem()
-		io.WriteString(out, stackTop.Inspect())
-		io.WriteString(out, "\n")
+		fmt.Fprintf(s.out, "engine: %s\n", s.engine)
+	case ":ast":
+		program, ok := s.parseAndExpand(arg)
+		if ok {
+			io.WriteString(s.out, program.String()+"\n")
+		}
+	case ":bytecode":
+		s.printBytecode(arg)
+	case ":load":
+		if arg == "" {
+			io.WriteString(s.out, "usage: :load <file>\n")
+			return false
+		}
+		src, err := os.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintf(s.out, "%s\n", err)
+			return false
+		}
+		s.eval(arg, string(src))
+	default:
+		fmt.Fprintf(s.out, "unknown command %s, try :help\n", cmd)
+	}
+	return false
+}
+
+func (s *session) parse(filename, input string) (*ast.Program, bool) {
+	p := parser.New(lexer.NewWithFilename(filename, input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParseErrors(s.out, p.Diagnostics(), input)
+		return nil, false
+	}
+	return program, true
+}
+
+// parseAndExpand 在宏环境的子环境中展开宏，输入中新定义的宏不会留在会话里
+func (s *session) parseAndExpand(input string) (*ast.Program, bool) {
+	program, ok := s.parse("", input)
+	if !ok {
+		return nil, false
+	}
+	program, err := evaluator.DefineAndExpandMacros(program, object.NewEnclosedEnvironment(s.macroEnv))
+	if err != nil {
+		fmt.Fprintf(s.out, "%s\n", err)
+		return nil, false
+	}
+	return program, true
+}
+
+// eval 展开宏之后用当前引擎运行一段输入，打印最后一个表达式语句的值
+func (s *session) eval(filename, input string) {
+	program, ok := s.parse(filename, input)
+	if !ok {
+		return
+	}
+	program, err := evaluator.DefineAndExpandMacros(program, s.macroEnv)
+	if err != nil {
+		fmt.Fprintf(s.out, "%s\n", err)
+		return
+	}
+	if s.engine == EngineEval {
+		s.evalAST(program)
+		return
+	}
+	s.runVM(program)
+}
+
+func (s *session) evalAST(program *ast.Program) {
+	evaluated := evaluator.Eval(program, s.env)
+	if _, ok := evaluated.(*object.Exit); ok {
+		s.exited = true
+		return
+	}
+	if evaluated != nil {
+		io.WriteString(s.out, evaluated.Inspect())
+		io.WriteString(s.out, "\n")
 	}
 }
 
-func printParseErrors(out io.Writer, errors []string) {
-	io.WriteString(out, "\t parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+func (s *session) runVM(program *ast.Program) {
+	compile := compiler.NewWithState(s.symbolTable, s.constants)
+	err := compile.Compile(program)
+	if err != nil {
+		fmt.Fprintf(s.out, "Compilation failed:\n %s\n", err)
+		return
 	}
+	code := compile.Bytecode()
+	s.constants = code.Constants
+
+	ma := vm.NewWithGlobalsStore(code, s.globals)
+	ma.SetIO(s.stdio)
+	err = ma.Run()
+	var exitErr *vm.ExitError
+	if errors.As(err, &exitErr) {
+		s.exited = true
+		return
+	}
+	if err != nil {
+		printRuntimeError(s.out, err)
+		return
+	}
+	stackTop := ma.LastPoppedStackElem()
+	if stackTop != nil {
+		io.WriteString(s.out, stackTop.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+}
+
+// printBytecode 在符号表的副本上编译，不会影响会话中已有的绑定
+func (s *session) printBytecode(input string) {
+	program, ok := s.parseAndExpand(input)
+	if !ok {
+		return
+	}
+	symbols := s.symbolTable.Clone()
+	constants := append([]object.Object{}, s.constants...)
+	compile := compiler.NewWithState(symbols, constants)
+	err := compile.Compile(program)
+	if err != nil {
+		fmt.Fprintf(s.out, "Compilation failed:\n %s\n", err)
+		return
+	}
+	compiler.Disassemble(s.out, compile.Bytecode(), symbols)
+}
+
+func (s *session) printGlobals() {
+	if s.engine == EngineEval {
+		for _, name := range s.env.Names() {
+			value, _ := s.env.Get(name)
+			fmt.Fprintf(s.out, "%s = %s\n", name, value.Inspect())
+		}
+		return
+	}
+	names := s.symbolTable.GlobalNames()
+	indexes := make([]int, 0, len(names))
+	for idx, name := range names {
+		if !strings.HasPrefix(name, "$") && s.globals[idx] != nil {
+			indexes = append(indexes, idx)
+		}
+	}
+	sort.Ints(indexes)
+	for _, idx := range indexes {
+		fmt.Fprintf(s.out, "%s = %s\n", names[idx], s.globals[idx].Inspect())
+	}
+}
+
+func printRuntimeError(out io.Writer, err error) {
+	var rtErr *vm.RuntimeError
+	if errors.As(err, &rtErr) {
+		io.WriteString(out, rtErr.Traceback())
+		return
+	}
+	fmt.Fprintf(out, "Executing bytecode failed:\n %s\n", err)
+}
+
+func printParseErrors(out io.Writer, diagnostics []parser.Diagnostic, input string) {
+	io.WriteString(out, "parser errors:\n")
+	io.WriteString(out, parser.RenderDiagnostics(diagnostics, input))
 }
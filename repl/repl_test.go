@@ -0,0 +1,129 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNeedsMoreInput(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"let x = 1;", false},
+		{"let f = fn(x) {", true},
+		{"let f = fn(x) {\n  x\n}", false},
+		{"puts(1,", true},
+		{"[1, [2,", true},
+		{`let s = "({[";`, false},
+		{"// {", false},
+		{"/* open", true},
+		{"/* open\nclose */ 1", false},
+		{")", false},
+	}
+
+	for _, ts := range tests {
+		if got := needsMoreInput(ts.input); got != ts.expected {
+			t.Errorf("needsMoreInput(%q) = %t, want %t", ts.input, got, ts.expected)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "lib.mk")
+	err := os.WriteFile(script, []byte("let double = fn(x) { x * 2 };"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := strings.Join([]string{
+		"let add = fn(a, b) {",
+		"  a + b",
+		"};",
+		"add(1,",
+		"  2)",
+		":globals",
+		":ast 1 + 2 * 3",
+		":load " + script,
+		"double(21)",
+		"let broken = [1,",
+		"",
+		":reset",
+		"add",
+		":nope",
+		":quit",
+		"1",
+	}, "\n")
+
+	var out strings.Builder
+	Start(strings.NewReader(input), &out)
+
+	for _, want := range []string{
+		">> .. .. ",
+		">> .. 3\n",
+		"add = Closure[",
+		"(1 + (2 * 3))\n",
+		">> 42\n",
+		"parser errors:",
+		"session reset\n",
+		"undefined variable add",
+		"unknown command :nope, try :help\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q. got=\n%s", want, out.String())
+		}
+	}
+	if strings.HasSuffix(out.String(), "1\n") {
+		t.Errorf("input after :quit was evaluated. got=\n%s", out.String())
+	}
+}
+
+func TestReadlineSharesInput(t *testing.T) {
+	for _, engine := range []Engine{EngineVM, EngineEval} {
+		input := "let x = readline();\nhello\nputs(x);\nlen(readline())\nmonkey\n"
+		var out strings.Builder
+		StartWithEngine(strings.NewReader(input), &out, engine)
+
+		for _, want := range []string{">> hello\nnull\n", ">> 6\n"} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("engine %s: output does not contain %q. got=\n%s", engine, want, out.String())
+			}
+		}
+		if strings.Contains(out.String(), "undefined variable") {
+			t.Errorf("engine %s: readline input was read by the REPL. got=\n%s", engine, out.String())
+		}
+	}
+}
+
+func TestEnginesAndMacros(t *testing.T) {
+	input := strings.Join([]string{
+		"let unless = macro(c, a, b) { quote(if (!(unquote(c))) { unquote(a) } else { unquote(b) }) };",
+		`unless(1 > 2, "vm-yes", "vm-no")`,
+		":engine eval",
+		`unless(3 > 2, "eval-yes", "eval-no")`,
+		"let x = 7;",
+		":globals",
+		":engine wasm",
+		":engine",
+	}, "\n")
+
+	var out strings.Builder
+	StartWithEngine(strings.NewReader(input), &out, EngineVM)
+
+	for _, want := range []string{
+		">> vm-yes\n",
+		"engine: eval\n",
+		">> eval-no\n",
+		"x = 7\n",
+		"unknown engine wasm, use vm or eval\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q. got=\n%s", want, out.String())
+		}
+	}
+	if strings.Count(out.String(), "engine: eval\n") != 2 {
+		t.Errorf("engine was not kept after an invalid switch. got=\n%s", out.String())
+	}
+}
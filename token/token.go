@@ -1,21 +1,64 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType //token类型
 	Literal string    //字面量
+	Pos     Position  //token第一个字符的位置
+	End     Position  //token最后一个字符之后的位置
+}
+
+// Comment 是词法分析时跳过的注释，Text包含注释符号本身
+type Comment struct {
+	Text string
+	Pos  Position
+	End  Position
+}
+
+// Position 描述源码中的一个位置，Line和Column都从1开始，Column按字节计数
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String 返回 file:line:col，没有文件名时返回 line:col，无效位置返回 "-"
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
 }
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"macro":  MACRO,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"macro":    MACRO,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
 }
 
 const (
@@ -24,6 +67,7 @@ const (
 	// 标识符+字面量
 	IDENT    = "IDENT" // add, foobar, x, y, ...
 	INT      = "INT"
+	FLOAT    = "FLOAT"
 	STRING   = "STRING"
 	LBRACKET = "["
 	RBRACKET = "]"
@@ -34,8 +78,19 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
 	LT       = "<"
 	GT       = ">"
+	LT_EQ    = "<="
+	GT_EQ    = ">="
+	AND      = "&&"
+	OR       = "||"
+	// 复合赋值
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+	PERCENT_ASSIGN  = "%="
 	// 分隔符
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -53,6 +108,11 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 
 	//二元判断
 	EQ     = "=="
@@ -0,0 +1,90 @@
+package vm
+
+import (
+	"myinterpreter/object"
+	"strings"
+)
+
+// Hook 在虚拟机执行每条指令之前调用，此时当前帧的ip指向将要执行的指令。
+// 返回错误时停止执行，Run返回包装了这个错误的运行时错误。调试器通过它暂停和单步执行
+type Hook func(vm *VM) error
+
+// SetHook 设置或者(传入nil时)清除指令钩子
+func (vm *VM) SetHook(hook Hook) {
+	vm.hook = hook
+}
+
+// Variable 是调试时看到的一个变量
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+// Frames 返回当前的调用栈，main帧在最前面，正在执行的帧在最后
+func (vm *VM) Frames() []*Frame {
+	return vm.frames[:vm.framesIndex]
+}
+
+// Locals 返回帧中的局部变量，参数在前，还没有赋值的变量值为nil。
+// 同名的let覆盖了前面的变量时只返回最后定义的那个，编译器生成的$开头的变量(如$iter)不返回
+func (vm *VM) Locals(f *Frame) []Variable {
+	names := f.cl.Fn.LocalNames
+	var vars []Variable
+	for i, name := range names {
+		if strings.HasPrefix(name, "$") || shadowed(names[i+1:], name) {
+			continue
+		}
+		value := vm.stack[f.basePointer+i]
+		if cell, ok := value.(*object.Cell); ok {
+			value = cell.Value
+		}
+		vars = append(vars, Variable{Name: name, Value: value})
+	}
+	return vars
+}
+
+func shadowed(later []string, name string) bool {
+	for _, n := range later {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FreeVariables 返回帧中的函数捕获的自由变量
+func (vm *VM) FreeVariables(f *Frame) []Variable {
+	vars := make([]Variable, len(f.cl.Free))
+	for i, cell := range f.cl.Free {
+		name := ""
+		if i < len(f.cl.Fn.FreeNames) {
+			name = f.cl.Fn.FreeNames[i]
+		}
+		vars[i] = Variable{Name: name, Value: cell.Value}
+	}
+	return vars
+}
+
+// Global 返回第index个全局变量，全局变量的名字在编译器的符号表中
+func (vm *VM) Global(index int) object.Object {
+	if index < 0 || index >= len(vm.globals) {
+		return nil
+	}
+	return vm.globals[index]
+}
+
+// OperandStack 返回帧的操作数栈，也就是局部变量之上、被调用的函数之下的部分，栈顶在最后
+func (vm *VM) OperandStack(f *Frame) []object.Object {
+	frames := vm.Frames()
+	start, end := f.basePointer+f.cl.Fn.NumLocals, vm.sp
+	for i, frame := range frames {
+		if frame == f && i+1 < len(frames) {
+			//下一帧的basePointer之前是被调用的函数和它的参数，不属于这一帧
+			end = frames[i+1].basePointer - 1
+		}
+	}
+	if end < start {
+		return nil
+	}
+	return append([]object.Object{}, vm.stack[start:end]...)
+}
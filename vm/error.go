@@ -0,0 +1,79 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"myinterpreter/token"
+)
+
+// RuntimeError 是虚拟机执行出错时返回的错误，Pos为出错指令对应的源码位置，
+// Frames记录出错时的调用栈，最内层的帧在前
+type RuntimeError struct {
+	Pos     token.Position
+	Message string
+	Frames  []TraceFrame
+	Err     error //原始的错误，例如*object.LimitError或context.DeadlineExceeded
+}
+
+// TraceFrame 是调用栈中的一帧，Pos为该帧当前正在执行的指令的位置
+type TraceFrame struct {
+	Function string
+	Pos      token.Position
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Message
+	}
+	return e.Message
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// Traceback 返回可读的调用栈，例如:
+//
+//	runtime error: unsupported types for binary operation: INTEGER STRING
+//	    at add (main.mk:2:5)
+//	    at <main> (main.mk:4:4)
+func (e *RuntimeError) Traceback() string {
+	var out bytes.Buffer
+
+	fmt.Fprintf(&out, "runtime error: %s\n", e.Message)
+	for _, f := range e.Frames {
+		fmt.Fprintf(&out, "    at %s (%s)\n", f.Function, f.Pos)
+	}
+	return out.String()
+}
+
+// ExitError 是脚本调用exit(code)时Run返回的错误，不是真正的运行时错误
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	return &RuntimeError{
+		Pos:     vm.currentFrame().SourcePosition(),
+		Message: err.Error(),
+		Frames:  vm.stackTrace(),
+		Err:     err,
+	}
+}
+
+func (vm *VM) stackTrace() []TraceFrame {
+	frames := make([]TraceFrame, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		f := vm.frames[i]
+		name := f.FunctionName()
+		if i == 0 {
+			name = "<main>"
+		}
+		frames = append(frames, TraceFrame{Function: name, Pos: f.SourcePosition()})
+	}
+	return frames
+}
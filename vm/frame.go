@@ -3,6 +3,7 @@ package vm
 import (
 	"myinterpreter/code"
 	"myinterpreter/object"
+	"myinterpreter/token"
 )
 
 type Frame struct {
@@ -18,3 +19,30 @@ func NewFrame(cl *object.Closure, basePointer int) *Frame {
 func (f *Frame) Instructions() code.Instructions {
 	return f.cl.Fn.Instructions
 }
+
+// SourcePosition 通过行号表找到当前ip处指令对应的源码位置
+func (f *Frame) SourcePosition() token.Position {
+	fn := f.cl.Fn
+	line, column := fn.LineTable.Lookup(f.ip)
+	if line == 0 {
+		return token.Position{}
+	}
+	return token.Position{Filename: fn.Filename, Line: line, Column: column}
+}
+
+func (f *Frame) FunctionName() string {
+	if f.cl.Fn.Name == "" {
+		return "<anonymous>"
+	}
+	return f.cl.Fn.Name
+}
+
+// Function 返回帧正在执行的函数
+func (f *Frame) Function() *object.CompiledFunction {
+	return f.cl.Fn
+}
+
+// IP 返回帧当前指令的偏移量
+func (f *Frame) IP() int {
+	return f.ip
+}
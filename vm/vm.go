@@ -1,7 +1,9 @@
 package vm
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"myinterpreter/code"
 	"myinterpreter/compiler"
 	"myinterpreter/object"
@@ -27,6 +29,16 @@ type VM struct {
 	globals     []object.Object
 	frames      []*Frame
 	framesIndex int
+
+	builtins   []*object.Builtin
+	builtinCtx *builtinContext
+
+	stdio   object.IO //puts和readline使用的输入输出
+	limits  object.Limits
+	budget  *object.Budget //RunContext开始时按limits创建，nil表示不限制
+	callErr error          //内置函数回调时发生的错误，内置函数返回后继续向外传播
+
+	hook Hook
 }
 
 func (vm *VM) currentFrame() *Frame {
@@ -44,19 +56,27 @@ func (vm *VM) popFrame() *Frame {
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		LineTable:    bytecode.LineTable,
+		Filename:     bytecode.Filename,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 	frames := make([]*Frame, MaxFrames)
 	frames[0] = mainFrame
-	return &VM{
+	vm := &VM{
 		constants:   bytecode.Constants,
 		stack:       make([]object.Object, StackSize),
 		sp:          0,
 		globals:     make([]object.Object, GlobalsSize),
 		frames:      frames,
 		framesIndex: 1,
+		builtins:    defaultBuiltins,
+		stdio:       object.StdIO(),
 	}
+	vm.builtinCtx = &builtinContext{vm: vm}
+	return vm
 }
 
 func (vm *VM) StackTop() object.Object {
@@ -76,13 +96,84 @@ func (vm *VM) push(o object.Object) error {
 	return nil
 }
 
+// SetLimits 设置下一次RunContext时的资源限制
+func (vm *VM) SetLimits(limits object.Limits) {
+	vm.limits = limits
+}
+
+// SetIO 设置puts和readline使用的输入输出，默认是进程的标准输入输出
+func (vm *VM) SetIO(stdio object.IO) {
+	vm.stdio = stdio
+}
+
 func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext 执行程序，ctx取消或超时时停止执行并返回包装了ctx.Err()的运行时错误，
+// 超出SetLimits设置的限制时返回包装了*object.LimitError的运行时错误。
+// 之后调用Call时仍然使用这次的ctx和剩下的额度
+func (vm *VM) RunContext(ctx context.Context) error {
+	if ctx.Done() != nil || vm.limits != (object.Limits{}) {
+		vm.budget = object.NewBudget(ctx, vm.limits)
+	}
+	err := vm.run(0)
+	if err != nil {
+		return vm.wrapError(err)
+	}
+	return nil
+}
+
+// Call 同步调用一个函数并返回结果。内置函数执行过程中可以通过它重入虚拟机，
+// Run结束后宿主程序也可以用它调用脚本中定义的函数。出错时撤销这次调用压入的帧
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	sp, depth := vm.sp, vm.framesIndex
+	err := vm.push(fn)
+	for _, arg := range args {
+		if err == nil {
+			err = vm.push(arg)
+		}
+	}
+	if err == nil {
+		err = vm.executeCall(len(args))
+	}
+	if err == nil {
+		err = vm.run(depth)
+	}
+	if err != nil {
+		err = vm.wrapError(err)
+		vm.sp, vm.framesIndex = sp, depth
+		return nil, err
+	}
+	return vm.pop(), nil
+}
+
+// wrapError 给错误加上出错位置和调用栈，已经处理过的错误原样返回
+func (vm *VM) wrapError(err error) error {
+	switch err.(type) {
+	case *ExitError, *RuntimeError:
+		return err
+	}
+	return vm.newRuntimeError(err)
+}
+
+// run 执行指令直到帧的数量降到depth，也就是深度为depth+1的帧返回时。
+// main帧不会返回，depth为0时执行到main的指令结束
+func (vm *VM) run(depth int) error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
 
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+	for vm.framesIndex > depth && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		if err := vm.budget.Step(); err != nil {
+			return err
+		}
 		vm.currentFrame().ip++
+		if vm.hook != nil {
+			if err := vm.hook(vm); err != nil {
+				return err
+			}
+		}
 		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
 		op = code.Opcode(ins[ip])
@@ -92,9 +183,9 @@ func (vm *VM) Run() error {
 			vm.currentFrame().ip += 2
 			err := vm.push(vm.constants[constIndex])
 			if err != nil {
-				return nil
+				return err
 			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
@@ -109,10 +200,10 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanEqual, code.OpLessThan, code.OpLessThanEqual:
 			err := vm.executeComparison(op)
 			if err != nil {
-				return nil
+				return err
 			}
 		case code.OpMinus:
 			err := vm.executeMinusOperator(op)
@@ -135,6 +226,26 @@ func (vm *VM) Run() error {
 			if !isTruthy(condition) {
 				vm.currentFrame().ip = pos - 1
 			}
+		case code.OpCompareJump:
+			cmp := code.Opcode(ins[ip+1])
+			pos := int(code.ReadUint16(ins[ip+2:]))
+			vm.currentFrame().ip += 3
+
+			err := vm.executeComparison(cmp)
+			if err != nil {
+				return err
+			}
+			if !isTruthy(vm.pop()) {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpJumpTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
 		case code.OpNull:
 			err := vm.push(Null)
 			if err != nil {
@@ -154,10 +265,13 @@ func (vm *VM) Run() error {
 		case code.OpArray:
 			numElem := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
-			array := vm.buildArray(vm.sp-numElem, vm.sp)
+			array, err := vm.buildArray(vm.sp-numElem, vm.sp)
+			if err != nil {
+				return err
+			}
 			vm.sp -= numElem
 
-			err := vm.push(array)
+			err = vm.push(array)
 			if err != nil {
 				return err
 			}
@@ -181,6 +295,22 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+		case code.OpDupTwo:
+			err := vm.push(vm.stack[vm.sp-2])
+			if err == nil {
+				err = vm.push(vm.stack[vm.sp-2])
+			}
+			if err != nil {
+				return err
+			}
 		case code.OpCall:
 			numArgs := int(ins[ip+1])
 			vm.currentFrame().ip += 1
@@ -192,6 +322,10 @@ func (vm *VM) Run() error {
 
 		case code.OpReturnValue:
 			returnValue := vm.pop()
+			if vm.framesIndex == 1 {
+				vm.returnFromMain(returnValue)
+				continue
+			}
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
 			err := vm.push(returnValue)
@@ -199,6 +333,10 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpReturn:
+			if vm.framesIndex == 1 {
+				vm.returnFromMain(Null)
+				continue
+			}
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
 			err := vm.push(Null)
@@ -206,23 +344,26 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpSetLocal:
-			localIdx := int(ins[ip+1])
 			vm.currentFrame().ip++
-			frame := vm.currentFrame()
-			vm.stack[frame.basePointer+localIdx] = vm.pop()
+			vm.setLocal(int(ins[ip+1]))
+		case code.OpAssignLocal:
+			vm.currentFrame().ip++
+			vm.assignLocal(int(ins[ip+1]))
 		case code.OpGetLocal:
-			localIdx := int(ins[ip+1])
 			vm.currentFrame().ip++
-			frame := vm.currentFrame()
-			err := vm.push(vm.stack[frame.basePointer+localIdx])
+			err := vm.getLocal(int(ins[ip+1]))
+			if err != nil {
+				return err
+			}
+		case code.OpGetLocalCell:
+			vm.currentFrame().ip++
+			err := vm.getLocalCell(int(ins[ip+1]))
 			if err != nil {
 				return err
 			}
 		case code.OpGetBuiltin:
-			builtinIdx := int(ins[ip+1])
 			vm.currentFrame().ip++
-			definition := object.Builtins[builtinIdx]
-			err := vm.push(definition.Builtin)
+			err := vm.push(vm.builtins[ins[ip+1]])
 			if err != nil {
 				return err
 			}
@@ -235,10 +376,22 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpGetFree:
-			freeIdx := int(ins[ip+1])
 			vm.currentFrame().ip++
-			currentClosure := vm.currentFrame().cl
-			err := vm.push(currentClosure.Free[freeIdx])
+			err := vm.push(vm.currentFrame().cl.Free[ins[ip+1]].Value)
+			if err != nil {
+				return err
+			}
+		case code.OpSetFree:
+			vm.currentFrame().ip++
+			vm.currentFrame().cl.Free[ins[ip+1]].Value = vm.pop()
+		case code.OpGetFreeCell:
+			vm.currentFrame().ip++
+			err := vm.push(vm.currentFrame().cl.Free[ins[ip+1]])
+			if err != nil {
+				return err
+			}
+		case code.OpWide:
+			err := vm.executeWide(ins, ip)
 			if err != nil {
 				return err
 			}
@@ -248,6 +401,21 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpIter:
+			iterable := vm.pop()
+			iter, ok := object.NewIterator(iterable)
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", iterable.Type())
+			}
+			err := vm.push(iter)
+			if err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			err := vm.executeIterNext()
+			if err != nil {
+				return err
+			}
 		case code.OpPop:
 			vm.pop()
 		}
@@ -255,18 +423,119 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+// returnFromMain 执行main中的return，和解释器一样结束程序。
+// 返回值放在栈顶之上，和最后一个表达式语句的值一样由LastPoppedStackElem读取
+func (vm *VM) returnFromMain(returnValue object.Object) {
+	vm.stack[vm.sp] = returnValue
+	vm.currentFrame().ip = len(vm.currentFrame().Instructions()) - 1
+}
+
+// setLocal 和assignLocal相同。循环中再次执行的let和for的循环变量使用同一个槽，
+// 和全局变量以及解释器一样，它们是同一个绑定，所以已经被闭包捕获时也写入Cell
+func (vm *VM) setLocal(idx int) {
+	vm.assignLocal(idx)
+}
+
+// assignLocal 给已有的局部变量赋值，变量已经被闭包捕获时写入它的Cell
+func (vm *VM) assignLocal(idx int) {
+	slot := &vm.stack[vm.currentFrame().basePointer+idx]
+	if cell, ok := (*slot).(*object.Cell); ok {
+		cell.Value = vm.pop()
+	} else {
+		*slot = vm.pop()
+	}
+}
+
+func (vm *VM) getLocal(idx int) error {
+	local := vm.stack[vm.currentFrame().basePointer+idx]
+	if cell, ok := local.(*object.Cell); ok {
+		local = cell.Value
+	}
+	return vm.push(local)
+}
+
+func (vm *VM) getLocalCell(idx int) error {
+	slot := &vm.stack[vm.currentFrame().basePointer+idx]
+	cell, ok := (*slot).(*object.Cell)
+	if !ok {
+		//第一次被捕获时才把变量装进Cell
+		cell = &object.Cell{Value: *slot}
+		*slot = cell
+	}
+	return vm.push(cell)
+}
+
+// executeWide 执行带OpWide前缀的指令，它的1字节操作数被加宽成了2字节
+func (vm *VM) executeWide(ins code.Instructions, ip int) error {
+	op := code.Opcode(ins[ip+1])
+	operand := int(code.ReadUint16(ins[ip+2:]))
+	vm.currentFrame().ip += 3
+
+	switch op {
+	case code.OpGetLocal:
+		return vm.getLocal(operand)
+	case code.OpSetLocal:
+		vm.setLocal(operand)
+	case code.OpAssignLocal:
+		vm.assignLocal(operand)
+	case code.OpGetLocalCell:
+		return vm.getLocalCell(operand)
+	case code.OpCall:
+		return vm.executeCall(operand)
+	case code.OpGetBuiltin:
+		return vm.push(vm.builtins[operand])
+	case code.OpClosure:
+		numFree := int(code.ReadUint16(ins[ip+4:]))
+		vm.currentFrame().ip += 2
+		return vm.pushClosure(operand, numFree)
+	case code.OpGetFree:
+		return vm.push(vm.currentFrame().cl.Free[operand].Value)
+	case code.OpSetFree:
+		vm.currentFrame().cl.Free[operand].Value = vm.pop()
+	case code.OpGetFreeCell:
+		return vm.push(vm.currentFrame().cl.Free[operand])
+	default:
+		return fmt.Errorf("opcode %d cannot be wide", op)
+	}
+	return nil
+}
+
+func (vm *VM) executeIterNext() error {
+	iter, ok := vm.pop().(*object.Iterator)
+	if !ok {
+		return fmt.Errorf("not an iterator")
+	}
+	elem, ok := iter.Next()
+	if !ok {
+		return vm.push(False)
+	}
+	err := vm.push(elem)
+	if err != nil {
+		return err
+	}
+	return vm.push(True)
+}
+
 func (vm *VM) pushClosure(constIdx, numFree int) error {
 	constant := vm.constants[constIdx]
 	function, ok := constant.(*object.CompiledFunction)
 	if !ok {
 		return fmt.Errorf("not a function: %+v", constant)
 	}
-	free := make([]object.Object, numFree)
+	free := make([]*object.Cell, numFree)
 	for i := 0; i < numFree; i++ {
-		free[i] = vm.stack[vm.sp-numFree+i]
+		switch v := vm.stack[vm.sp-numFree+i].(type) {
+		case *object.Cell:
+			free[i] = v
+		default: //OpCurrentClosure压入的函数自身不能被赋值，直接包装即可
+			free[i] = &object.Cell{Value: v}
+		}
 	}
 	vm.sp -= numFree
 	closure := &object.Closure{Fn: function, Free: free}
+	if err := vm.allocate(closure); err != nil {
+		return err
+	}
 	return vm.push(closure)
 }
 
@@ -284,8 +553,21 @@ func (vm *VM) executeCall(numArgs int) error {
 
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
-	res := builtin.Fn(args...)
+	res := builtin.Fn(vm.builtinCtx, args...)
+	if err := vm.callErr; err != nil {
+		vm.callErr = nil
+		return err
+	}
+	if exit, ok := res.(*object.Exit); ok {
+		return &ExitError{Code: int(exit.Code)}
+	}
+	if err := vm.allocate(res); err != nil {
+		return err
+	}
 	vm.sp = vm.sp - numArgs - 1
+	if b, ok := res.(*object.Boolean); ok {
+		res = nativeBoolToBooleanObject(b.Value) //比较布尔值时比较的是指针
+	}
 	if res != nil {
 		vm.push(res)
 	} else {
@@ -294,14 +576,53 @@ func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	return nil
 }
 
+// builtinContext 是虚拟机提供给内置函数的CallContext。回调出错时记下错误，
+// 内置函数返回后由callBuiltin继续传播，出错位置和调用栈保持回调内部的样子
+type builtinContext struct {
+	vm *VM
+}
+
+func (c *builtinContext) Call(fn object.Object, args ...object.Object) object.Object {
+	res, err := c.vm.Call(fn, args...)
+	if err == nil {
+		return res
+	}
+	if c.vm.callErr == nil {
+		c.vm.callErr = err
+	}
+	if exit, ok := err.(*ExitError); ok {
+		return &object.Exit{Code: int64(exit.Code)}
+	}
+	return &object.Error{Message: err.Error()}
+}
+
+func (c *builtinContext) IO() object.IO {
+	return c.vm.stdio
+}
+
 func (vm *VM) callFunction(cl *object.Closure, numArgs int) error {
 	if numArgs != cl.Fn.NumParameters {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
 			cl.Fn.NumParameters, numArgs)
 	}
+	//main帧不算调用深度，frames用完时也按超出调用深度处理
+	if vm.framesIndex >= MaxFrames {
+		return &object.LimitError{Limit: object.LimitCallDepth, Max: MaxFrames - 1}
+	}
+	if err := vm.budget.CheckDepth(vm.framesIndex); err != nil {
+		return err
+	}
+	if vm.sp-numArgs+cl.Fn.NumLocals > StackSize {
+		return fmt.Errorf("stack overflow")
+	}
 	frame := NewFrame(cl, vm.sp-numArgs)
 	vm.pushFrame(frame)
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	//清空还没有赋值的局部变量，栈上以前留下的Cell可能属于别的闭包，setLocal不能写进去，
+	//调试时也不会看到以前留下的值
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
 	return nil
 }
 
@@ -341,27 +662,65 @@ func (vm *VM) executeHashIndex(left, index object.Object) error {
 	return vm.push(pair.Value)
 }
 
+// executeSetIndex 原地修改数组元素或hash中的值，并把值压栈作为赋值表达式的结果
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("index out of range: %d (length %d)", i.Value, len(left.Elements))
+		}
+		left.Elements[i.Value] = value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		hashKey := key.HashKey()
+		if _, exists := left.Pairs[hashKey]; !exists {
+			if err := vm.budget.AddHashEntry(); err != nil {
+				return err
+			}
+		}
+		left.Pairs[hashKey] = object.HashPair{Key: index, Value: value}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+	return vm.push(value)
+}
+
 func (vm *VM) buildHash(start, end int) (object.Object, error) {
 	hashPairs := make(map[object.HashKey]object.HashPair)
 	for i := start; i < end; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
-		pair := object.HashPair{key, value}
+		pair := object.HashPair{Key: key, Value: value}
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
 			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
 		}
 		hashPairs[hashKey.HashKey()] = pair
 	}
-	return &object.Hash{hashPairs}, nil
+	hash := &object.Hash{Pairs: hashPairs}
+	return hash, vm.allocate(hash)
 }
 
-func (vm *VM) buildArray(start, end int) object.Object {
+func (vm *VM) buildArray(start, end int) (object.Object, error) {
 	elems := make([]object.Object, end-start)
 	for i := start; i < end; i++ {
 		elems[i-start] = vm.stack[i]
 	}
-	return &object.Array{Elements: elems}
+	array := &object.Array{Elements: elems}
+	return array, vm.allocate(array)
+}
+
+// allocate 把运行时新建的数组、hash、字符串和闭包计入对象个数和内存的限额，
+// 超出时返回*object.LimitError。内置函数返回的这几种对象一律按新建的计算
+func (vm *VM) allocate(obj object.Object) error {
+	return vm.budget.Allocate(obj)
 }
 
 func isTruthy(obj object.Object) bool {
@@ -391,11 +750,14 @@ func (vm *VM) executeBangOperator(op code.Opcode) error {
 
 func (vm *VM) executeMinusOperator(op code.Opcode) error {
 	operand := vm.pop()
-	if operand.Type() != object.INTEGER_OBJ {
+	switch operand := operand.(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
 		return fmt.Errorf("unsupported tyoe for negation: %s", operand.Type())
 	}
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
 }
 
 func (vm *VM) executeComparison(op code.Opcode) error {
@@ -404,6 +766,12 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if isNumber(left) && isNumber(right) {
+		return vm.executeFloatComparison(op, left, right)
+	}
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left, right)
+	}
 
 	switch op {
 	case code.OpEqual:
@@ -415,6 +783,21 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	}
 }
 
+// executeStringComparison 字符串按值比较，结果不受常量去重的影响
+func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %d(%s %s)", op, left.Type(), right.Type())
+	}
+}
+
 func nativeBoolToBooleanObject(b bool) object.Object {
 	if b {
 		return True
@@ -433,6 +816,12 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object
 		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpLessThanEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
 	default:
 		return fmt.Errorf("unknow operator:%d", op)
 	}
@@ -446,6 +835,8 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	switch {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeBinaryFloatOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
@@ -459,7 +850,11 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Ob
 	}
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
-	return vm.push(&object.String{Value: leftValue + rightValue})
+	str := &object.String{Value: leftValue + rightValue}
+	if err := vm.allocate(str); err != nil {
+		return err
+	}
+	return vm.push(str)
 }
 
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
@@ -474,13 +869,80 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 	case code.OpMul:
 		result = leftValue * rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		result = leftValue / rightValue
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftValue % rightValue
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
 	return vm.push(&object.Integer{Value: result})
 }
 
+// executeBinaryFloatOperation 处理至少一边为float的算术运算，int会先转换成float
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Object) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+	var result float64
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+	return vm.push(&object.Float{Value: result})
+}
+
+// executeFloatComparison 比较至少有一个是浮点数的两个数，用CompareNumbers精确比较
+func (vm *VM) executeFloatComparison(op code.Opcode, left, right object.Object) error {
+	c, ok := object.CompareNumbers(left, right)
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(ok && c == 0))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(!ok || c != 0))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(ok && c > 0))
+	case code.OpGreaterThanEqual:
+		return vm.push(nativeBoolToBooleanObject(ok && c >= 0))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(ok && c < 0))
+	case code.OpLessThanEqual:
+		return vm.push(nativeBoolToBooleanObject(ok && c <= 0))
+	default:
+		return fmt.Errorf("unknow operator:%d", op)
+	}
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
 func (vm *VM) pop() object.Object {
 	o := vm.stack[vm.sp-1]
 	vm.sp--
@@ -496,3 +958,23 @@ func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	vm.globals = s
 	return vm
 }
+
+// NewWithState 使用给定的全局变量和内置函数表，OpGetBuiltin的操作数是builtins中的下标。
+// 嵌入的程序在object.Builtins后面追加自己的函数，编译时用同样的下标定义符号
+func NewWithState(bytecode *compiler.Bytecode, globals []object.Object, builtins []*object.Builtin) *VM {
+	vm := NewWithGlobalsStore(bytecode, globals)
+	vm.builtins = builtins
+	return vm
+}
+
+// defaultBuiltins 是object.Builtins中的内置函数，所有虚拟机共用，不会被修改
+var defaultBuiltins = DefaultBuiltins()
+
+// DefaultBuiltins 返回标准内置函数表的副本，下标和object.Builtins一致
+func DefaultBuiltins() []*object.Builtin {
+	builtins := make([]*object.Builtin, len(object.Builtins))
+	for i, def := range object.Builtins {
+		builtins[i] = def.Builtin
+	}
+	return builtins
+}
@@ -1,13 +1,19 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"myinterpreter/ast"
+	"myinterpreter/code"
 	"myinterpreter/compiler"
+	"myinterpreter/evaluator"
 	"myinterpreter/lexer"
 	"myinterpreter/object"
 	"myinterpreter/parser"
+	"strings"
 	"testing"
+	"time"
 )
 
 func parse(input string) *ast.Program {
@@ -27,28 +33,44 @@ func testIntegerObject(obj object.Object, expected int64) error {
 	return nil
 }
 
+func testFloatObject(obj object.Object, expected float64) error {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T(%+v)", obj, obj)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g,want=%g", result.Value, expected)
+	}
+	return nil
+}
+
 type vmTestCase struct {
 	input    string
 	expected any
 }
 
+// optimizationLevels 每个用例在所有优化级别下都要得到相同的结果
+var optimizationLevels = []int{compiler.OptNone, compiler.OptBasic, compiler.OptPeephole}
+
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
 	for _, ts := range tests {
-		program := parse(ts.input)
-		compile := compiler.New()
-		err := compile.Compile(program)
-		if err != nil {
-			t.Fatalf("compiler error: %s", err)
-		}
-		vm := New(compile.Bytecode())
-		err = vm.Run()
-		if err != nil {
-			t.Fatalf("vm error: %s", err)
+		for _, level := range optimizationLevels {
+			program := parse(ts.input)
+			compile := compiler.New(compiler.WithOptimization(level))
+			err := compile.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error (O%d): %s", level, err)
+			}
+			vm := New(compile.Bytecode())
+			err = vm.Run()
+			if err != nil {
+				t.Fatalf("vm error (O%d): %s", level, err)
+			}
+			stackElem := vm.LastPoppedStackElem()
+			testExpectedObject(t, ts.expected, stackElem)
 		}
-		stackElem := vm.LastPoppedStackElem()
-		testExpectedObject(t, ts.expected, stackElem)
 	}
 }
 
@@ -134,6 +156,11 @@ func testExpectedObject(t *testing.T, expected any, actual object.Object) {
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case float64:
+		err := testFloatObject(actual, expected)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case bool:
 		err := testBooleanObject(actual, expected)
 		if err != nil {
@@ -231,6 +258,20 @@ func TestStringExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+// TestStringEquality 字符串按值比较，常量是否去重、是否折叠都不影响结果，所有优化级别结果相同
+func TestStringEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`let a = "x"; let b = "x"; a == b`, true},
+		{`let a = "x"; let b = "x"; a != b`, false},
+		{`let s = "ab"; s == "a" + "b"`, true},
+		{`"ab" == "a" + "b"`, true},
+		{`"a" != "b"`, true},
+		{`let f = fn(s) { if (s == "yes") { 1 } else { 2 } }; f("y" + "es") + f("no")`, 3},
+		{`let a = "x"; let b = "y"; a == b`, false},
+	}
+	runVmTests(t, tests)
+}
+
 func TestArrayLiterals(t *testing.T) {
 	tests := []vmTestCase{
 		{"[]", []int{}},
@@ -276,6 +317,9 @@ func TestIndexExpressions(t *testing.T) {
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
+		{"{2: 1}[2.0]", 1},
+		{"{2.0: 1}[2]", 1},
+		{"{2: 1}[2.5]", Null},
 	}
 	runVmTests(t, tests)
 }
@@ -483,15 +527,15 @@ func TestCallingFunctionWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
 			input:    `fn() { 1; }(1);`,
-			expected: `wrong number of arguments: want=0, got=1`,
+			expected: `1:1: wrong number of arguments: want=0, got=1`,
 		},
 		{
 			input:    `fn(a) { a; }();`,
-			expected: `wrong number of arguments: want=1, got=0`,
+			expected: `1:1: wrong number of arguments: want=1, got=0`,
 		},
 		{
 			input:    `fn(a, b) { a + b; }(1);`,
-			expected: `wrong number of arguments: want=2, got=1`,
+			expected: `1:1: wrong number of arguments: want=2, got=1`,
 		},
 	}
 
@@ -705,3 +749,780 @@ func TestRecursiveFibonacci(t *testing.T) {
 	}
 	runVmTests(t, tests)
 }
+
+func TestRuntimeErrorPosition(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedPos string
+		expectedMsg string
+	}{
+		{
+			"let a = 1;\nlet b = \"two\";\na + b;",
+			"3:3",
+			"unsupported types for binary operation: INTEGER STRING",
+		},
+		{
+			"let f = fn(x) {\n  x - true\n};\nf(1);",
+			"2:5",
+			"unsupported types for binary operation: INTEGER BOOLEAN",
+		},
+		{
+			"[1, 2]\n  [fn() {}];",
+			"2:3",
+			"index operator not supported:ARRAY",
+		},
+		{
+			"let f = fn(n) { let a = 1; let b = 2; f(n + 1) };\nf(0);",
+			"1:25",
+			"stack overflow",
+		},
+	}
+
+	for _, ts := range tests {
+		program := parse(ts.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("expected *RuntimeError. got=%T (%v)", err, err)
+		}
+		if rtErr.Pos.String() != ts.expectedPos {
+			t.Errorf("wrong error position. want=%q, got=%q", ts.expectedPos, rtErr.Pos)
+		}
+		if rtErr.Message != ts.expectedMsg {
+			t.Errorf("wrong error message. want=%q, got=%q", ts.expectedMsg, rtErr.Message)
+		}
+	}
+}
+
+func TestRuntimeErrorLineTable(t *testing.T) {
+	input := `let compare = fn(a, b) {
+  if (a > b) { a } else { b }
+};
+compare(1, 2);
+compare("one", 2);`
+
+	program := parser.New(lexer.NewWithFilename("max.mk", input)).ParseProgram()
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none")
+	}
+
+	expected := "max.mk:2:9: unknown operator: 10(STRING INTEGER)"
+	if err.Error() != expected {
+		t.Errorf("wrong VM error: want=%q, got=%q", expected, err)
+	}
+}
+
+func TestRuntimeErrorTraceback(t *testing.T) {
+	input := `let inner = fn(x) {
+  x + "!"
+};
+let outer = fn(x) {
+  fn() { inner(x) }()
+};
+outer(1);`
+
+	program := parser.New(lexer.NewWithFilename("trace.mk", input)).ParseProgram()
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError. got=%T (%v)", err, err)
+	}
+
+	expected := `runtime error: unsupported types for binary operation: INTEGER STRING
+    at inner (trace.mk:2:5)
+    at <anonymous> (trace.mk:5:10)
+    at outer (trace.mk:5:3)
+    at <main> (trace.mk:7:1)
+`
+	if rtErr.Traceback() != expected {
+		t.Errorf("wrong traceback.\nwant=%q\ngot=%q", expected, rtErr.Traceback())
+	}
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 - 1", -0.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"1e-3 * 1000", 1.0},
+		{"1 < 1.5", true},
+		{"2.0 == 2", true},
+		{"2.5 != 2.5", false},
+		{"2.5 > 3", false},
+	}
+	runVmTests(t, tests)
+}
+
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"7.5 % 2", 1.5},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"2.5 >= 2", true},
+		{"1.5 <= 1", false},
+		{"9007199254740993 == 9007199254740992.0", false},
+		{"9007199254740993 != 9007199254740992.0", true},
+		{"9007199254740993 > 9007199254740992.0", true},
+		{"9007199254740992.0 >= 9007199254740993", false},
+		{"9007199254740992 == 9007199254740992.0", true},
+		{"sort_by([9007199254740993, 9007199254740992.0], fn(x) { x })[0]", 9007199254740992.0},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", true},
+		{"null_value() || 0", true},
+		{"false && 1 / 0 == 1", false},
+		{"true || 1 % 0 == 1", true},
+		{"1 < 2 && 2 < 3 || false", true},
+	}
+	for i := range tests {
+		tests[i].input = "let null_value = fn() {};" + tests[i].input
+	}
+	runVmTests(t, tests)
+}
+
+func TestDivisionByZero(t *testing.T) {
+	for _, input := range []string{"1 / 0", "1 % 0"} {
+		program := parse(input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil || err.Error() != "1:3: division by zero" {
+			t.Errorf("wrong VM error for %q: %v", input, err)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{`fn() { while (true) { return 1; } }()`, 1},
+		{`fn() { while (false) { return 1; } }()`, Null},
+		{`fn() { while (true) { break; } 2 }()`, 2},
+		{`fn() { for (x in [1, 2, 3]) { if (x > 1) { return x; } } }()`, 2},
+		{`fn() { for (x in [1, 2, 3]) { if (x < 3) { continue; } return x; } }()`, 3},
+		{`fn() { for (k in {3: "c", 1: "a", 2: "b"}) { return k; } }()`, 1},
+		{`fn() { for (c in "monkey") { return c; } }()`, "m"},
+		{`fn() { for (x in []) { return x; } 0 }()`, 0},
+		{`
+let find = fn(rows, target) {
+  for (row in rows) {
+    for (x in row) {
+      if (x == target) { break; }
+      if (x > target) { return -1; }
+    }
+    if (first(row) == target) { return row; }
+  }
+};
+find([[1, 2], [3, 4]], 3)`, []int{3, 4}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{`let x = 1; x = 2; x`, 2},
+		{`let x = 1; x = x + 1`, 2},
+		{`let a = 1; let b = 2; a = b = 3; a + b`, 6},
+		{`let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x %= 4; x`, 2},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{`fn() { let x = 1; x = x * 10; x }()`, 10},
+		{`fn(n) { n += 1; n }(41)`, 42},
+		{`
+let newCounter = fn() {
+  let count = 0;
+  fn() { count += 1; }
+};
+let counterA = newCounter();
+let counterB = newCounter();
+counterA(); counterA(); counterB();
+counterA()`, 3},
+		{`
+let f = fn() {
+  let x = 1;
+  let inc = fn() { x = x + 1; };
+  inc(); inc();
+  x
+};
+f()`, 3},
+		{`
+let f = fn() {
+  let x = 1;
+  let get = fn() { fn() { x } };
+  x = 5;
+  get()()
+};
+f()`, 5},
+		{`
+let f = fn() {
+  let x = 0;
+  let outer = fn() { fn() { x += 10; } };
+  outer()();
+  x
+};
+f()`, 10},
+		{`
+let sum = 0;
+let i = 0;
+while (i < 5) { i += 1; sum += i; }
+sum`, 15},
+		{`
+let fns = [];
+for (x in [1, 2, 3]) { fns = push(fns, fn() { x }); }
+fns[0]() + fns[2]()`, 6},
+		{`
+fn() {
+  let fns = [];
+  for (x in [1, 2, 3]) { fns = push(fns, fn() { x }); }
+  fns[0]() + fns[2]()
+}()`, 6},
+		{`
+let fib = fn(n) {
+  let a = 0;
+  let b = 1;
+  while (n > 0) { let t = a + b; a = b; b = t; n -= 1; }
+  a
+};
+fib(50)`, 12586269025},
+	}
+	runVmTests(t, tests)
+}
+
+// TestClosureCaptureMatchesEvaluator 循环中捕获的变量在全局和函数中、在虚拟机和解释器中都是同一个绑定
+func TestClosureCaptureMatchesEvaluator(t *testing.T) {
+	tests := []string{
+		`let fns = []; for (x in [1, 2, 3]) { fns = push(fns, fn() { x }); } fns[0]() + fns[2]()`,
+		`fn() { let fns = []; for (x in [1, 2, 3]) { fns = push(fns, fn() { x }); } fns[0]() + fns[2]() }()`,
+		`let fns = []; let i = 0; while (i < 3) { let v = i; fns = push(fns, fn() { v }); i += 1; } map(fns, fn(f) { f() })`,
+		`fn() { let fns = []; let i = 0; while (i < 3) { let v = i; fns = push(fns, fn() { v }); i += 1; } map(fns, fn(f) { f() }) }()`,
+		`let f = fn() { let x = 1; let g = fn() { x }; x = 2; g() }; f() + f()`,
+	}
+	for _, input := range tests {
+		want := evaluator.Eval(parse(input), object.NewEnvironment())
+		vm := New(compileInput(t, input))
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem(); got.Inspect() != want.Inspect() {
+			t.Errorf("%s\nvm=%s, evaluator=%s", input, got.Inspect(), want.Inspect())
+		}
+	}
+}
+
+// TestEvaluationOrderMatchesEvaluator <和<=的两边和其他运算符一样从左到右计算
+func TestEvaluationOrderMatchesEvaluator(t *testing.T) {
+	tests := []string{
+		`let log = []; let f = fn(x) { log = push(log, x); x }; f(1) < f(2); log`,
+		`let log = []; let f = fn(x) { log = push(log, x); x }; f(1) <= f(2); log`,
+		`let log = []; let f = fn(x) { log = push(log, x); x }; if (f(3) < f(2)) { 0 }; log`,
+		`[1 < 2, 2 < 1, 2 <= 2, 1.5 < 2, 2 <= 1.5]`,
+	}
+	for _, input := range tests {
+		want := evaluator.Eval(parse(input), object.NewEnvironment())
+		for _, level := range optimizationLevels {
+			comp := compiler.New(compiler.WithOptimization(level))
+			if err := comp.Compile(parse(input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			vm := New(comp.Bytecode())
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			if got := vm.LastPoppedStackElem(); got.Inspect() != want.Inspect() {
+				t.Errorf("%s (O%d)\nvm=%s, evaluator=%s", input, level, got.Inspect(), want.Inspect())
+			}
+		}
+	}
+}
+
+func TestLoopControlMatchesEvaluator(t *testing.T) {
+	tests := []string{
+		`let n = 0; let s = 0; while (n < 3000) { n += 1; if (n % 2 == 0) { continue } s += n; } s`,
+		`let n = 0; while (true) { n += 1; if (n > 10) { if (n % 7 == 0) { break } } } n`,
+		`let out = []; for (x in [1, 2, 3, 4]) { if (x == 2) { continue } else { if (x == 4) { break } } out = push(out, x); } out`,
+		`let n = 0; while (n < 3000) { n += 1; let y = 1 + if (n > 0) { 2 } else { 3 }; } n`,
+	}
+	for _, input := range tests {
+		want := evaluator.Eval(parse(input), object.NewEnvironment())
+		for _, level := range optimizationLevels {
+			comp := compiler.New(compiler.WithOptimization(level))
+			if err := comp.Compile(parse(input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			vm := New(comp.Bytecode())
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			if got := vm.LastPoppedStackElem(); got.Inspect() != want.Inspect() {
+				t.Errorf("%s (O%d)\nvm=%s, evaluator=%s", input, level, got.Inspect(), want.Inspect())
+			}
+		}
+	}
+
+	//表达式中的break和continue在解析时就被拒绝，两种引擎都不会执行到
+	rejected := []string{
+		`let n = 0; while (n < 3) { n += 1; let y = if (true) { break }; puts(y) }`,
+		`let n = 0; while (n < 3000) { n += 1; let y = 1 + if (true) { continue } else { 2 }; }`,
+	}
+	for _, input := range rejected {
+		p := parser.New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) != 1 {
+			t.Errorf("expected one parser error for %q. got=%q", input, p.Errors())
+		}
+	}
+}
+
+func TestAssignFunctionNameMatchesEvaluator(t *testing.T) {
+	tests := []string{
+		`let f = fn() { f = 1 }; f(); f`,
+		`let f = fn() { let r = f; f = 2; r == f }; [f(), f]`,
+		`let f = fn(n) { if (n > 0) { f(n - 1) } else { f = "done" } }; f(3); f`,
+		`let g = fn() { let f = fn() { f = 5; 1 }; [f(), f] }; g()`,
+		`let g = fn() { let n = 41; let f = fn() { n += 1; f = n }; f(); f }; g()`,
+	}
+	for _, input := range tests {
+		want := evaluator.Eval(parse(input), object.NewEnvironment())
+		for _, level := range optimizationLevels {
+			comp := compiler.New(compiler.WithOptimization(level))
+			if err := comp.Compile(parse(input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			vm := New(comp.Bytecode())
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			if got := vm.LastPoppedStackElem(); got.Inspect() != want.Inspect() {
+				t.Errorf("%s (O%d)\nvm=%s, evaluator=%s", input, level, got.Inspect(), want.Inspect())
+			}
+		}
+	}
+}
+
+func TestReturnFromMain(t *testing.T) {
+	tests := []string{
+		`let a = 1; return a + 4; a = 2`,
+		`let x = 1; if (x > 0) { return x * 10 }; x`,
+		`for (x in [1, 2, 3]) { if (x == 2) { return x } }`,
+	}
+	for _, input := range tests {
+		want := evaluator.Eval(parse(input), object.NewEnvironment())
+		for _, level := range optimizationLevels {
+			comp := compiler.New(compiler.WithOptimization(level))
+			if err := comp.Compile(parse(input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			vm := New(comp.Bytecode())
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			if got := vm.LastPoppedStackElem(); got.Inspect() != want.Inspect() {
+				t.Errorf("%s (O%d)\nvm=%s, evaluator=%s", input, level, got.Inspect(), want.Inspect())
+			}
+		}
+	}
+
+	//手工构造的字节码也可以在main中返回
+	vm := New(&compiler.Bytecode{
+		Instructions: append(code.Make(code.OpTrue), code.Make(code.OpReturnValue)...),
+		Constants:    []object.Object{},
+	})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if err := testBooleanObject(vm.LastPoppedStackElem(), true); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIndexAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{`let a = [1, 2, 3]; a[1] = 5; a`, []int{1, 5, 3}},
+		{`let a = [1, 2, 3]; a[2] = 9`, 9},
+		{`let a = [1, 2, 3]; let b = a; b[0] = 7; a[0]`, 7},
+		{`let a = [[1], [2]]; a[1][0] += 40; a[1][0]`, 42},
+		{`let h = {}; h[1] = 10; h[2] = 20; h[1] = 11; h`, map[object.HashKey]int64{
+			(&object.Integer{Value: 1}).HashKey(): 11,
+			(&object.Integer{Value: 2}).HashKey(): 20,
+		}},
+		{`let h = {"a": 1}; h["a"] += 1; h["a"]`, 2},
+		{`
+let counts = {};
+for (w in ["x", "y", "x", "x"]) {
+  if (!counts[w]) { counts[w] = 0; }
+  counts[w] += 1;
+}
+counts["x"] * 10 + counts["y"]`, 31},
+		{`
+let squares = [0, 0, 0, 0];
+let i = 0;
+while (i < len(squares)) { squares[i] = i * i; i += 1; }
+squares`, []int{0, 1, 4, 9}},
+		{`let calls = 0; let f = fn() { calls += 1; [0] }; f()[0] += 1; calls`, 1},
+	}
+	runVmTests(t, tests)
+}
+
+func TestIndexAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let a = [1]; a[1] = 2`, "1:19: index out of range: 1 (length 1)"},
+		{`let a = [1]; a[-1] = 2`, "1:20: index out of range: -1 (length 1)"},
+		{`let a = [1]; a["x"] = 2`, "1:21: array index must be INTEGER, got STRING"},
+		{`let h = {}; h[fn() {}] = 1`, "1:24: unusable as hash key: CLOSURE"},
+		{`let s = "abc"; s[0] = "x"`, "1:21: index assignment not supported: STRING"},
+	}
+
+	for _, ts := range tests {
+		program := parse(ts.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+		if err.Error() != ts.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", ts.expected, err)
+		}
+	}
+}
+
+func TestRunUnmarshaledBytecode(t *testing.T) {
+	program := parse(`
+let newAdder = fn(a) { fn(b) { a + b } };
+let addTwo = newAdder(2);
+[addTwo(40), "mk" + "c", 0.5 * 3]`)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	data, err := compiler.Marshal(comp.Bytecode())
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+	bytecode, err := compiler.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("unmarshal error: %s", err)
+	}
+
+	vm := New(bytecode)
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	result := vm.LastPoppedStackElem()
+	if result.Inspect() != `[42, mkc, 1.5]` {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+}
+
+func TestExit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{`exit(); 1`, 0},
+		{`exit(3); 1`, 3},
+		{`let f = fn() { for (x in [1, 2]) { if (x == 2) { exit(x) } } 5 }; f(); 1`, 2},
+	}
+
+	for _, ts := range tests {
+		program := parse(ts.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		exit, ok := err.(*ExitError)
+		if !ok {
+			t.Fatalf("expected ExitError. got=%T(%v)", err, err)
+		}
+		if exit.Code != ts.expected {
+			t.Errorf("wrong exit code. want=%d, got=%d", ts.expected, exit.Code)
+		}
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	names := make([]string, 300)
+	values := make([]string, 300)
+	for i := range names {
+		names[i] = wideName(i)
+		values[i] = fmt.Sprint(i + 1)
+	}
+	params := strings.Join(names, ", ")
+	args := strings.Join(values, ", ")
+
+	var lets strings.Builder
+	for i, name := range names {
+		fmt.Fprintf(&lets, "let %s = %d; ", name, i+1)
+	}
+
+	tests := []vmTestCase{
+		{
+			//300个参数，后面的参数需要宽操作数读取
+			input:    fmt.Sprintf("let f = fn(%s) { %s + %s }; f(%s)", params, names[0], names[299], args),
+			expected: 301,
+		},
+		{
+			input:    fmt.Sprintf("fn() { %s %s = %s * 2; %s }()", lets.String(), names[299], names[299], names[299]),
+			expected: 600,
+		},
+		{
+			//闭包捕获编号超过255的局部变量
+			input:    fmt.Sprintf("fn() { %s let g = fn() { %s = %s + 1; %s }; g(); g() }()", lets.String(), names[280], names[280], names[280]),
+			expected: 283,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+// wideName 生成只包含字母的变量名
+func wideName(i int) string {
+	name := ""
+	for {
+		name = string(rune('a'+i%26)) + name
+		i /= 26
+		if i == 0 {
+			return "v" + name
+		}
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`map([], fn(x) { x })`, []int{}},
+		{`let n = 10; map([1, 2], fn(x) { x + n })`, []int{11, 12}},
+		{`map([[1], [2, 3]], len)`, []int{1, 2}},
+		{`filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })`, []int{2, 4}},
+		{`reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })`, 10},
+		{`reduce([], 5, fn(acc, x) { acc + x })`, 5},
+		{`let sum = 0; each([1, 2, 3], fn(x) { sum = sum + x }); sum`, 6},
+		{`any([1, 2, 3], fn(x) { x > 2 })`, true},
+		{`any([1, 2, 3], fn(x) { x > 3 }) == false`, true},
+		{`all([1, 2, 3], fn(x) { x > 0 })`, true},
+		{`all([], fn(x) { false })`, true},
+		{`let calls = 0; any([1, 2, 3], fn(x) { calls = calls + 1; x == 1 }); calls`, 1},
+		{`sort_by([3, 1, 2], fn(x) { x })`, []int{1, 2, 3}},
+		{`sort_by([1, 2, 3, 4], fn(x) { -x })`, []int{4, 3, 2, 1}},
+		{`map(sort_by([[2, 1], [1, 2], [2, 3]], fn(p) { p[0] }), fn(p) { p[1] })`, []int{2, 1, 3}},
+		{`sort_by(["b", "a"], fn(x) { x })[0]`, "a"},
+		{`map(map([1, 2], fn(x) { map([x], fn(y) { y * 10 }) }), first)`, []int{10, 20}},
+		{`map(1, fn(x) { x })`, &object.Error{Message: "first argument to `map` must be ARRAY, got INTEGER"}},
+		{`filter([1], 1)`, &object.Error{Message: "second argument to `filter` must be a function, got INTEGER"}},
+		{`sort_by([1, "a"], fn(x) { x })`, &object.Error{Message: "sort_by keys must be all numbers or all strings"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestHigherOrderBuiltinErrors(t *testing.T) {
+	input := `let check = fn(x) {
+  x + "!"
+};
+map([1], check);`
+
+	program := parser.New(lexer.NewWithFilename("map.mk", input)).ParseProgram()
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	rtErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected *RuntimeError. got=%T (%v)", err, err)
+	}
+	expected := `runtime error: unsupported types for binary operation: INTEGER STRING
+    at check (map.mk:2:5)
+    at <main> (map.mk:4:1)
+`
+	if rtErr.Traceback() != expected {
+		t.Errorf("wrong traceback.\nwant=%q\ngot=%q", expected, rtErr.Traceback())
+	}
+
+	vm = New(compileInput(t, `each([1, 2], fn(x) { exit(x + 6) }); 0`))
+	err = vm.Run()
+	exit, ok := err.(*ExitError)
+	if !ok || exit.Code != 7 {
+		t.Errorf("expected exit status 7. got=%v", err)
+	}
+}
+
+func TestCallFromHost(t *testing.T) {
+	vm := New(compileInput(t, `let add = fn(a, b) { a + b }; 0`))
+	err := vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	add := vm.globals[0]
+
+	res, err := vm.Call(add, &object.Integer{Value: 2}, &object.Integer{Value: 3})
+	if err != nil {
+		t.Fatalf("call error: %s", err)
+	}
+	testExpectedObject(t, 5, res)
+
+	_, err = vm.Call(add, &object.Integer{Value: 2}, &object.String{Value: "x"})
+	if err == nil {
+		t.Fatalf("expected call error")
+	}
+	//出错后虚拟机仍然可以继续调用
+	res, err = vm.Call(add, &object.Integer{Value: 1}, &object.Integer{Value: 1})
+	if err != nil {
+		t.Fatalf("call error after failed call: %s", err)
+	}
+	testExpectedObject(t, 2, res)
+}
+
+func compileInput(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		expected object.LimitError
+	}{
+		{"let f = fn() { f() }; f()", object.Limits{}, object.LimitError{Limit: object.LimitCallDepth, Max: MaxFrames - 1}},
+		{"let f = fn(n) { f(n + 1) }; f(0)", object.Limits{MaxCallDepth: 10}, object.LimitError{Limit: object.LimitCallDepth, Max: 10}},
+		{"while (true) { }", object.Limits{MaxInstructions: 1000}, object.LimitError{Limit: object.LimitInstructions, Max: 1000}},
+		{`let s = ""; while (true) { s = s + "x" }`, object.Limits{MaxAllocations: 50}, object.LimitError{Limit: object.LimitAllocations, Max: 50}},
+		{"let a = []; while (true) { a = push(a, 1) }", object.Limits{MaxAllocations: 50}, object.LimitError{Limit: object.LimitAllocations, Max: 50}},
+		{"map([1, 2, 3], fn(x) { [x] })", object.Limits{MaxAllocations: 3}, object.LimitError{Limit: object.LimitAllocations, Max: 3}},
+	}
+
+	for _, ts := range tests {
+		vm := New(compileInput(t, ts.input))
+		vm.SetLimits(ts.limits)
+		err := vm.Run()
+		var limitErr *object.LimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%q: expected *object.LimitError. got=%T (%v)", ts.input, err, err)
+			continue
+		}
+		if *limitErr != ts.expected {
+			t.Errorf("%q: wrong limit error. want=%v, got=%v", ts.input, ts.expected, limitErr)
+		}
+	}
+
+	//没有超出限制时正常执行
+	vm := New(compileInput(t, "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(10)"))
+	vm.SetLimits(object.Limits{MaxInstructions: 1000, MaxCallDepth: 11, MaxAllocations: 1})
+	err := vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 0, vm.LastPoppedStackElem())
+}
+
+func TestRunContext(t *testing.T) {
+	vm := New(compileInput(t, "while (true) { }"))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := vm.RunContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded. got=%v", err)
+	}
+	if _, ok := err.(*RuntimeError); !ok {
+		t.Errorf("expected *RuntimeError. got=%T", err)
+	}
+
+	vm = New(compileInput(t, "let f = fn() { f() }; while (true) { }"))
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = vm.RunContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled. got=%v", err)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	tests := []string{
+		`let a = []; while (true) { a = push(a, 1) }`,
+		`let s = "x"; while (true) { s = s + s }`,
+		`let h = {}; let i = 0; while (true) { h[i] = [i, i, i]; i = i + 1 }`,
+		`let h = {}; let i = 0; while (true) { h[i] = i; i = i + 1 }`,
+		`let fs = []; let i = 0; while (true) { let n = i; fs = push(fs, fn() { n }); i = i + 1 }`,
+		`map([1, 2, 3], fn(x) { let s = "ab"; while (true) { s = s + s } })`,
+	}
+
+	for _, input := range tests {
+		vm := New(compileInput(t, input))
+		vm.SetLimits(object.Limits{MaxMemory: 1 << 16})
+		err := vm.Run()
+		if err == nil || !strings.HasSuffix(err.Error(), "memory limit exceeded (max 65536)") {
+			t.Errorf("%q: expected memory limit error. got=%v", input, err)
+			continue
+		}
+		if vm.budget.Memory() > 3<<16 {
+			t.Errorf("%q: accounted %d bytes, far over the limit", input, vm.budget.Memory())
+		}
+	}
+
+	vm := New(compileInput(t, `let a = []; let i = 0; while (i < 100) { a = push(a, i); i = i + 1 } len(a)`))
+	vm.SetLimits(object.Limits{MaxMemory: 1 << 20})
+	err := vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 100, vm.LastPoppedStackElem())
+
+	//给已有的键赋值不会让hash变大
+	vm = New(compileInput(t, `let h = {1: 0}; let i = 0; while (i < 100000) { h[1] = i; i = i + 1 } h[1]`))
+	vm.SetLimits(object.Limits{MaxMemory: 1 << 16})
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 99999, vm.LastPoppedStackElem())
+}